@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
@@ -15,7 +16,10 @@ import (
 	grpcAdapter "github.com/zhanserikAmangeldi/chat-service/internal/adapters/grpc"
 	"github.com/zhanserikAmangeldi/chat-service/internal/adapters/handler"
 	"github.com/zhanserikAmangeldi/chat-service/internal/adapters/repository"
+	"github.com/zhanserikAmangeldi/chat-service/internal/adapters/scanner"
 	"github.com/zhanserikAmangeldi/chat-service/internal/adapters/websocket"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/ports"
 	"github.com/zhanserikAmangeldi/chat-service/internal/core/service"
 	"github.com/zhanserikAmangeldi/chat-service/internal/middleware"
 	"github.com/zhanserikAmangeldi/chat-service/internal/migration"
@@ -42,8 +46,26 @@ func main() {
 	log.Println("Migrations applied successfully")
 
 	redisAddr := fmt.Sprintf("%s:%s", cfg.RedisHost, cfg.RedisPort)
-	redisClient := redisAdapter.NewRedisClient(redisAddr)
-	log.Println("Connected to Redis")
+	redisClient := redisAdapter.NewRedisClient(redisAddr, redisAdapter.RoutingConfig{
+		Mode:        cfg.RedisRouting,
+		InstanceID:  cfg.InstanceID,
+		InstanceTTL: cfg.InstanceTTL,
+	})
+	log.Printf("Connected to Redis (instance %s)", redisClient.InstanceID())
+
+	// Durable events go through Redis Streams unless plain pub/sub is asked
+	// for, in which case reconnecting clients cannot catch up.
+	var publisher redisAdapter.IRedisClient = redisClient
+	var eventLog redisAdapter.IEventLog
+	if cfg.RedisDelivery != "pubsub" {
+		streamConfig := redisAdapter.DefaultStreamConfig()
+		if cfg.EventRetention > 0 {
+			streamConfig.Retention = cfg.EventRetention
+		}
+		streamClient := redisAdapter.NewStreamClient(redisClient, streamConfig)
+		publisher, eventLog = streamClient, streamClient
+		go background.StartStreamJanitor(context.Background(), streamClient, time.Minute)
+	}
 
 	userClient, err := grpcAdapter.NewUserClient(cfg.UserServiceURL)
 	if err != nil {
@@ -63,13 +85,34 @@ func main() {
 	}
 	log.Println("Connected to MinIO")
 
-	wsManager := websocket.NewClientManager()
+	wsConfig := websocket.DefaultConfig()
+	if cfg.WSSendQueue > 0 {
+		wsConfig.SendQueueSize = cfg.WSSendQueue
+	}
+	if cfg.WSWriteTimeout > 0 {
+		wsConfig.WriteTimeout = cfg.WSWriteTimeout
+	}
+	if cfg.WSPingInterval > 0 {
+		wsConfig.PingInterval = cfg.WSPingInterval
+	}
+	if cfg.WSPongTimeout > 0 {
+		wsConfig.PongTimeout = cfg.WSPongTimeout
+	}
+	if cfg.WSMaxMessage > 0 {
+		wsConfig.MaxMessageSize = cfg.WSMaxMessage
+	}
+	wsManager := websocket.NewClientManager(wsConfig)
 	repo := repository.NewPostgresRepository(db)
-	chatService := service.NewChatService(repo, redisClient, userClient)
+	chatService := service.NewChatService(repo, publisher, userClient, minioService)
 
-	go background.StartRedisListener(context.Background(), redisClient, wsManager)
+	// Session entries outlive a couple of missed heartbeats before expiring.
+	presenceService := service.NewPresenceService(repo, publisher, redisAdapter.NewPresenceStore(redisClient), 2*wsConfig.PongTimeout)
+	go background.StartPresenceSweeper(context.Background(), presenceService, wsConfig.PongTimeout)
 
-	wsHandler := handler.NewWSHandler(wsManager, cfg.JWTSecret, redisClient, repo)
+	go background.StartRedisListener(context.Background(), publisher, wsManager)
+	go background.StartRouteHeartbeat(context.Background(), redisClient, wsManager, cfg.InstanceTTL/3)
+
+	wsHandler := handler.NewWSHandler(wsManager, cfg.JWTSecret, redisClient, repo, presenceService, eventLog, chatService)
 	http.HandleFunc("/ws", wsHandler.HandleConnection)
 
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -87,7 +130,34 @@ func main() {
 
 	mux := http.NewServeMux()
 	chatHandler := handler.NewChatHandler(chatService)
-	fileHandler := handler.NewFileHandler(minioService, chatService)
+	uploadExpiry := cfg.UploadExpiry
+	if uploadExpiry <= 0 {
+		uploadExpiry = 24 * time.Hour
+	}
+	uploadService := service.NewUploadService(repo, minioService, uploadExpiry)
+	go background.StartUploadJanitor(context.Background(), uploadService, uploadExpiry/24)
+	presignService := service.NewPresignService(repo, minioService, 15*time.Minute)
+	go background.StartPresignJanitor(context.Background(), presignService, 15*time.Minute)
+	imageService := service.NewImageService(minioService)
+
+	// Without a clamd address uploads are not scanned and count as clean.
+	var fileScanner ports.FileScanner
+	if cfg.ClamdAddr != "" {
+		clamd := scanner.NewClamdScanner(cfg.ClamdAddr, cfg.ScanTimeout)
+		if err := clamd.Ping(context.Background()); err != nil {
+			log.Printf("ClamAV is not reachable yet, uploads stay pending until it is: %v", err)
+		} else {
+			log.Println("Connected to ClamAV")
+		}
+		fileScanner = clamd
+	} else {
+		log.Println("CLAMD_ADDR is not set, uploads are not scanned for malware")
+	}
+	scanService := service.NewScanService(repo, minioService, fileScanner, chatService, imageService)
+	go background.StartScanSweeper(context.Background(), scanService, service.ScanRetryInterval)
+
+	fileHandler := handler.NewFileHandler(minioService, chatService, uploadService, imageService, scanService, presignService)
+	presenceHandler := handler.NewPresenceHandler(presenceService)
 
 	createGroupHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -139,10 +209,12 @@ func main() {
 			ConversationID int64   `json:"conversation_id"`
 			Content        string  `json:"content"`
 			MessageType    string  `json:"message_type"`
-			FileURL        *string `json:"file_url,omitempty"`
 			FileName       *string `json:"file_name,omitempty"`
 			MimeType       *string `json:"mime_type,omitempty"`
 			FileSize       *int64  `json:"file_size,omitempty"`
+			ReplyTo        *int64  `json:"reply_to_message_id,omitempty"`
+			ClientMsgID    *string `json:"client_msg_id,omitempty"`
+			model.Attachment
 		}
 
 		var req SendMessageRequest
@@ -158,10 +230,12 @@ func main() {
 			req.Content,
 			req.ConversationID,
 			req.MessageType,
-			req.FileURL,
 			req.FileName,
 			req.MimeType,
 			req.FileSize,
+			req.ReplyTo,
+			req.ClientMsgID,
+			req.Attachment,
 		)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -174,20 +248,48 @@ func main() {
 
 	authMiddleware := middleware.AuthMiddleware(cfg.JWTSecret)
 
+	http.Handle("/ws/stats", authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(wsManager.Stats())
+	})))
+
 	mux.Handle("/api/v1/groups/create", authMiddleware(createGroupHandler))
+	mux.Handle("/api/v1/groups/rename", authMiddleware(http.HandlerFunc(chatHandler.RenameGroup)))
+	mux.Handle("/api/v1/groups/members", authMiddleware(http.HandlerFunc(chatHandler.GetGroupMembers)))
+	mux.Handle("/api/v1/groups/members/role", authMiddleware(http.HandlerFunc(chatHandler.UpdateMemberRole)))
+	mux.Handle("/api/v1/groups/members/add", authMiddleware(http.HandlerFunc(chatHandler.AddGroupMember)))
+	mux.Handle("/api/v1/groups/members/remove", authMiddleware(http.HandlerFunc(chatHandler.RemoveGroupMember)))
+	mux.Handle("/api/v1/groups/leave", authMiddleware(http.HandlerFunc(chatHandler.LeaveGroup)))
 	mux.Handle("/api/v1/messages/send", authMiddleware(sendMessageHandler))
 	mux.Handle("/api/v1/messages/history", authMiddleware(http.HandlerFunc(chatHandler.GetHistory)))
+	mux.Handle("/api/v1/messages/thread", authMiddleware(http.HandlerFunc(chatHandler.GetThread)))
+	mux.Handle("/api/v1/messages/thread/reply", authMiddleware(http.HandlerFunc(chatHandler.SendThreadReply)))
+	mux.Handle("/api/v1/messages/thread/read", authMiddleware(http.HandlerFunc(chatHandler.MarkThreadRead)))
+	mux.Handle("/api/v1/threads", authMiddleware(http.HandlerFunc(chatHandler.GetThreads)))
 
 	mux.Handle("/api/v1/conversations", authMiddleware(http.HandlerFunc(chatHandler.GetConversations)))
 	mux.Handle("/api/v1/messages/read", authMiddleware(http.HandlerFunc(chatHandler.MarkAsRead)))
+	mux.Handle("/api/v1/messages/read_up_to", authMiddleware(http.HandlerFunc(chatHandler.MarkReadUpTo)))
 	mux.Handle("/api/v1/messages/reactions/add", authMiddleware(http.HandlerFunc(chatHandler.AddReaction)))
 	mux.Handle("/api/v1/messages/reactions/remove", authMiddleware(http.HandlerFunc(chatHandler.RemoveReaction)))
 	mux.Handle("/api/v1/messages/edit", authMiddleware(http.HandlerFunc(chatHandler.EditMessage)))
 	mux.Handle("/api/v1/messages/delete", authMiddleware(http.HandlerFunc(chatHandler.DeleteMessage)))
+	mux.Handle("/api/v1/messages/search", authMiddleware(http.HandlerFunc(chatHandler.SearchMessages)))
+	mux.Handle("/api/v1/messages/forward", authMiddleware(http.HandlerFunc(chatHandler.ForwardMessages)))
+	mux.Handle("/api/v1/messages/pin", authMiddleware(http.HandlerFunc(chatHandler.PinMessage)))
+	mux.Handle("/api/v1/messages/unpin", authMiddleware(http.HandlerFunc(chatHandler.UnpinMessage)))
+	mux.Handle("/api/v1/conversations/pins", authMiddleware(http.HandlerFunc(chatHandler.GetPinnedMessages)))
+
+	mux.Handle("/api/v1/presence", authMiddleware(http.HandlerFunc(presenceHandler.GetPresence)))
+	mux.Handle("/api/v1/presence/privacy", authMiddleware(http.HandlerFunc(presenceHandler.SetVisibility)))
 
 	mux.Handle("/api/v1/files/upload", authMiddleware(http.HandlerFunc(fileHandler.UploadFile)))
 	mux.Handle("/api/v1/files/send", authMiddleware(http.HandlerFunc(fileHandler.SendMessageWithFile)))
 	mux.Handle("/api/v1/files/get", authMiddleware(http.HandlerFunc(fileHandler.GetFile)))
+	mux.Handle("/api/v1/files/presign", authMiddleware(http.HandlerFunc(fileHandler.PresignUpload)))
+	mux.Handle("/api/v1/files/finalize", authMiddleware(http.HandlerFunc(fileHandler.FinalizeUpload)))
+	mux.Handle("/api/v1/files/uploads", authMiddleware(http.HandlerFunc(fileHandler.CreateUpload)))
+	mux.Handle("/api/v1/files/uploads/", authMiddleware(http.HandlerFunc(fileHandler.Upload)))
 
 	http.Handle("/api/", mux)
 
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -18,17 +19,39 @@ type Config struct {
 	RedisHost      string
 	RedisPort      string
 	RedisDB        int
+	RedisRouting   string
+	RedisDelivery  string
+	EventRetention time.Duration
+	InstanceID     string
+	InstanceTTL    time.Duration
 	UserServiceURL string
 	MinioHost      string
 	MinioApiPort   string
 	MinioAccessKey string
 	MinioSecretKey string
 	MinioUseSSL    bool
+	UploadExpiry   time.Duration
+	ClamdAddr      string
+	ScanTimeout    time.Duration
 	JWTSecret      string
+	WSSendQueue    int
+	WSWriteTimeout time.Duration
+	WSPingInterval time.Duration
+	WSPongTimeout  time.Duration
+	WSMaxMessage   int64
 }
 
 func Load() *Config {
 	redisDB, _ := strconv.Atoi(getEnv("REDIS_DB", "0"))
+	eventRetention, _ := time.ParseDuration(getEnv("EVENT_RETENTION", "24h"))
+	instanceTTL, _ := time.ParseDuration(getEnv("INSTANCE_TTL", "30s"))
+	uploadExpiry, _ := time.ParseDuration(getEnv("UPLOAD_EXPIRY", "24h"))
+	scanTimeout, _ := time.ParseDuration(getEnv("SCAN_TIMEOUT", "1m"))
+	wsSendQueue, _ := strconv.Atoi(getEnv("WS_SEND_QUEUE", "256"))
+	wsWriteTimeout, _ := time.ParseDuration(getEnv("WS_WRITE_TIMEOUT", "10s"))
+	wsPingInterval, _ := time.ParseDuration(getEnv("WS_PING_INTERVAL", "54s"))
+	wsPongTimeout, _ := time.ParseDuration(getEnv("WS_PONG_TIMEOUT", "60s"))
+	wsMaxMessage, _ := strconv.ParseInt(getEnv("WS_MAX_MESSAGE_SIZE", "65536"), 10, 64)
 
 	return &Config{
 		HTTPPort:       getEnv("CHAT_HTTP_PORT", "8082"),
@@ -42,12 +65,25 @@ func Load() *Config {
 		RedisHost:      getEnv("REDIS_HOST", "localhost"),
 		RedisPort:      getEnv("REDIS_PORT", "6379"),
 		RedisDB:        redisDB,
+		RedisRouting:   getEnv("REDIS_ROUTING", "targeted"),
+		RedisDelivery:  getEnv("REDIS_DELIVERY", "streams"),
+		EventRetention: eventRetention,
+		InstanceID:     getEnv("INSTANCE_ID", ""),
+		InstanceTTL:    instanceTTL,
 		UserServiceURL: getEnv("USER_SERVICE_URL", "localhost:9091"),
 		MinioHost:      getEnv("MINIO_HOST", "localhost"),
 		MinioApiPort:   getEnv("MINIO_PORT", "9000"),
 		MinioAccessKey: getEnv("MINIO_USER", "admin"),
 		MinioSecretKey: getEnv("MINIO_PASSWORD", "admin123"),
+		UploadExpiry:   uploadExpiry,
+		ClamdAddr:      getEnv("CLAMD_ADDR", ""),
+		ScanTimeout:    scanTimeout,
 		JWTSecret:      getEnv("JWT_SECRET", "your-super-secret-key"),
+		WSSendQueue:    wsSendQueue,
+		WSWriteTimeout: wsWriteTimeout,
+		WSPingInterval: wsPingInterval,
+		WSPongTimeout:  wsPongTimeout,
+		WSMaxMessage:   wsMaxMessage,
 	}
 }
 
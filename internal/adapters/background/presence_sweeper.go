@@ -0,0 +1,36 @@
+package background
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/service"
+)
+
+// StartPresenceSweeper periodically announces offline the users whose
+// sessions expired without disconnecting, e.g. after an instance crashed.
+func StartPresenceSweeper(ctx context.Context, presenceService *service.PresenceService, interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		offline, err := presenceService.SweepExpired(ctx)
+		if err != nil {
+			log.Printf("Failed to sweep expired presence: %v", err)
+		}
+		if offline > 0 {
+			log.Printf("Announced %d users with expired sessions offline", offline)
+		}
+	}
+}
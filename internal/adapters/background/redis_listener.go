@@ -10,7 +10,7 @@ import (
 	"github.com/zhanserikAmangeldi/chat-service/internal/redis"
 )
 
-func StartRedisListener(ctx context.Context, redisClient *redis.RedisClient, wsManager *websocket.ClientManager) {
+func StartRedisListener(ctx context.Context, redisClient redis.IRedisClient, wsManager *websocket.ClientManager) {
 	log.Println("Started Redis Subscriber...")
 
 	msgChan := redisClient.Subscribe(ctx)
@@ -21,76 +21,42 @@ func StartRedisListener(ctx context.Context, redisClient *redis.RedisClient, wsM
 }
 
 func handleBroadcastMessage(payload redis.BroadcastMessage, wsManager *websocket.ClientManager) {
-	switch payload.Type {
-	case "message":
-		if payload.Message != nil {
-			sendToRecipients(wsManager, payload.RecipientIDs, model.WSMessage{
-				Type:    "message",
-				Payload: payload.Message,
-			})
-		}
-
-	case "message_edit":
-		if payload.Message != nil {
-			sendToRecipients(wsManager, payload.RecipientIDs, model.WSMessage{
-				Type:    "message_edit",
-				Payload: payload.Message,
-			})
-		}
-
-	case "message_delete":
-		sendToRecipients(wsManager, payload.RecipientIDs, model.WSMessage{
-			Type:    "message_delete",
-			Payload: payload.Payload,
-		})
-
-	case "typing":
-		sendToRecipients(wsManager, payload.RecipientIDs, model.WSMessage{
-			Type:    "typing",
-			Payload: payload.Payload,
-		})
-
-	case "status":
-		sendToRecipients(wsManager, payload.RecipientIDs, model.WSMessage{
-			Type:    "status",
-			Payload: payload.Payload,
-		})
-
-	case "reaction_add":
-		sendToRecipients(wsManager, payload.RecipientIDs, model.WSMessage{
-			Type:    "reaction_add",
-			Payload: payload.Payload,
-		})
-
-	case "reaction_remove":
-		sendToRecipients(wsManager, payload.RecipientIDs, model.WSMessage{
-			Type:    "reaction_remove",
-			Payload: payload.Payload,
-		})
-
-	case "read_receipt":
-		sendToRecipients(wsManager, payload.RecipientIDs, model.WSMessage{
-			Type:    "read_receipt",
-			Payload: payload.Payload,
-		})
-
-	default:
+	message, ok := payload.WSMessage()
+	if !ok {
 		log.Printf("Unknown broadcast type: %s", payload.Type)
+		return
 	}
+
+	sendToRecipients(wsManager, payload.RecipientIDs, payload.EventIDs, message)
 }
 
-func sendToRecipients(wsManager *websocket.ClientManager, recipientIDs []int64, message model.WSMessage) {
-	msgBytes, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Failed to marshal message: %v", err)
+// sendToRecipients delivers the frame to every recipient. Durable events carry
+// a per-recipient event ID, so each recipient gets its own frame.
+func sendToRecipients(wsManager *websocket.ClientManager, recipientIDs []int64, eventIDs map[int64]int64, message model.WSMessage) {
+	if len(eventIDs) == 0 {
+		msgBytes, err := json.Marshal(message)
+		if err != nil {
+			log.Printf("Failed to marshal message: %v", err)
+			return
+		}
+
+		for _, userID := range recipientIDs {
+			wsManager.SendToUser(userID, msgBytes)
+		}
 		return
 	}
 
 	for _, userID := range recipientIDs {
-		if conn, ok := wsManager.GetClient(userID); ok {
-			if err := conn.WriteMessage(1, msgBytes); err != nil {
-				log.Printf("Failed to write to WS for user %d: %v", userID, err)
-			}
+		if !wsManager.IsOnline(userID) {
+			continue
+		}
+
+		message.EventID = eventIDs[userID]
+		msgBytes, err := json.Marshal(message)
+		if err != nil {
+			log.Printf("Failed to marshal message: %v", err)
+			return
 		}
+		wsManager.SendToUser(userID, msgBytes)
 	}
 }
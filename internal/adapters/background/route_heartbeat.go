@@ -0,0 +1,34 @@
+package background
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/adapters/websocket"
+	"github.com/zhanserikAmangeldi/chat-service/internal/redis"
+)
+
+// StartRouteHeartbeat periodically refreshes this instance's entry in the
+// connection registry together with the users connected to it. Once the
+// instance stops, its routes are ignored after the instance TTL.
+func StartRouteHeartbeat(ctx context.Context, redisClient *redis.RedisClient, wsManager *websocket.ClientManager, interval time.Duration) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := redisClient.RefreshInstance(ctx, wsManager.Users()); err != nil {
+			log.Printf("Failed to refresh instance routes: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package background
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/service"
+)
+
+// StartScanSweeper periodically retries the scans of files that are still
+// pending, so their messages are not held back forever when a scan failed.
+func StartScanSweeper(ctx context.Context, scanService *service.ScanService, interval time.Duration) {
+	if interval <= 0 {
+		interval = service.ScanRetryInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		scanned, err := scanService.RetryPending(ctx)
+		if err != nil {
+			log.Printf("Failed to retry pending scans: %v", err)
+		}
+		if scanned > 0 {
+			log.Printf("Scanned %d files left pending", scanned)
+		}
+	}
+}
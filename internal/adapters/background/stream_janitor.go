@@ -0,0 +1,36 @@
+package background
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/redis"
+)
+
+// StartStreamJanitor periodically removes the consumer groups and live
+// streams left behind by instances that are gone.
+func StartStreamJanitor(ctx context.Context, streamClient *redis.StreamClient, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pruned, err := streamClient.PruneDeadInstances(ctx)
+		if err != nil {
+			log.Printf("Failed to prune dead instance streams: %v", err)
+		}
+		if pruned > 0 {
+			log.Printf("Pruned the event streams of %d dead instances", pruned)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package background
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/service"
+)
+
+// StartUploadJanitor periodically discards resumable uploads that stopped
+// receiving chunks, so their parts do not pile up in storage, and forgets
+// finished uploads once they expire.
+func StartUploadJanitor(ctx context.Context, uploadService *service.UploadService, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		expired, err := uploadService.ExpireUploads(ctx)
+		if err != nil {
+			log.Printf("Failed to expire uploads: %v", err)
+		}
+		if expired > 0 {
+			log.Printf("Expired %d abandoned uploads", expired)
+		}
+	}
+}
+
+// StartPresignJanitor periodically deletes the staging objects of direct
+// uploads whose presigned requests expired, finalized or not.
+func StartPresignJanitor(ctx context.Context, presignService *service.PresignService, interval time.Duration) {
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		expired, err := presignService.ExpireUploads(ctx)
+		if err != nil {
+			log.Printf("Failed to expire presigned uploads: %v", err)
+		}
+		if expired > 0 {
+			log.Printf("Expired %d presigned uploads", expired)
+		}
+	}
+}
@@ -26,6 +26,14 @@ func (m *MockUserClient) ValidateUsersExist(ctx context.Context, userIDs []int64
 	return args.Bool(0), args.Error(1)
 }
 
+func (m *MockUserClient) GetUsernames(ctx context.Context, userIDs []int64) (map[int64]string, error) {
+	args := m.Called(ctx, userIDs)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[int64]string), args.Error(1)
+}
+
 func (m *MockUserClient) Close() {
 	m.Called()
 }
@@ -2,25 +2,46 @@ package grpc
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	pb "github.com/zhanserikAmangeldi/chat-service/proto"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// usernameCacheTTL bounds how long a renamed user keeps matching mentions
+	// of their old name.
+	usernameCacheTTL      = 10 * time.Minute
+	maxConcurrentLookups  = 8
+	usernameLookupTimeout = 2 * time.Second
 )
 
 type IUserClient interface {
 	NewUserClient(address string) (*UserClient, error)
 	ValidateUserExists(ctx context.Context, userID int64) (bool, error)
 	ValidateUsersExist(ctx context.Context, userIDs []int64) (bool, error)
+	GetUsernames(ctx context.Context, userIDs []int64) (map[int64]string, error)
 	Close()
 }
 
 type UserClient struct {
 	client pb.UserServiceClient
 	conn   *grpc.ClientConn
+
+	mu        sync.Mutex
+	usernames map[int64]cachedUsername
+}
+
+type cachedUsername struct {
+	name    string
+	expires time.Time
 }
 
 func (c *UserClient) NewUserClient(address string) (*UserClient, error) {
@@ -84,3 +105,75 @@ func (c *UserClient) ValidateUsersExist(ctx context.Context, userIDs []int64) (b
 	log.Printf("[gRPC] ValidateUsersExist success %v\n", res)
 	return res.Exists, nil
 }
+
+// GetUsernames looks up the usernames of the given users. The user service has
+// no batch lookup, so users missing from the cache are fetched concurrently,
+// each with its own timeout; unknown users are skipped. When some lookups fail
+// the usernames found are returned along with an error saying how many failed.
+func (c *UserClient) GetUsernames(ctx context.Context, userIDs []int64) (map[int64]string, error) {
+	usernames := make(map[int64]string, len(userIDs))
+	var missing []int64
+
+	now := time.Now()
+	c.mu.Lock()
+	for _, id := range userIDs {
+		if cached, ok := c.usernames[id]; ok && now.Before(cached.expires) {
+			usernames[id] = cached.name
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(missing) == 0 {
+		return usernames, nil
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		failed  int
+		lastErr error
+	)
+	slots := make(chan struct{}, maxConcurrentLookups)
+	for _, id := range missing {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(id int64) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			lookupCtx, cancel := context.WithTimeout(ctx, usernameLookupTimeout)
+			defer cancel()
+			res, err := c.client.GetUser(lookupCtx, &pb.GetUserRequest{Id: id})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if status.Code(err) == codes.NotFound {
+					return
+				}
+				failed++
+				lastErr = err
+				return
+			}
+			usernames[id] = res.Username
+			c.cacheUsername(id, res.Username)
+		}(id)
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		return usernames, fmt.Errorf("looking up %d of %d usernames failed: %w", failed, len(missing), lastErr)
+	}
+	return usernames, nil
+}
+
+func (c *UserClient) cacheUsername(id int64, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.usernames == nil {
+		c.usernames = make(map[int64]cachedUsername)
+	}
+	c.usernames[id] = cachedUsername{name: name, expires: time.Now().Add(usernameCacheTTL)}
+}
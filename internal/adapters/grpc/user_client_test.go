@@ -0,0 +1,116 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pb "github.com/zhanserikAmangeldi/chat-service/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeUserService answers GetUser from a fixed set of users, after delay.
+type fakeUserService struct {
+	users map[int64]string
+	delay time.Duration
+	fail  map[int64]bool
+
+	calls    atomic.Int32
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+}
+
+func (f *fakeUserService) GetUser(ctx context.Context, in *pb.GetUserRequest, opts ...grpc.CallOption) (*pb.GetUserResponse, error) {
+	f.calls.Add(1)
+	f.mu.Lock()
+	f.inFlight++
+	f.peak = max(f.peak, f.inFlight)
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+
+	if f.fail[in.Id] {
+		return nil, status.Error(codes.Unavailable, "unavailable")
+	}
+	name, ok := f.users[in.Id]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	return &pb.GetUserResponse{Id: in.Id, Username: name}, nil
+}
+
+func (f *fakeUserService) CheckUsersExist(ctx context.Context, in *pb.CheckUsersExistRequest, opts ...grpc.CallOption) (*pb.CheckUsersExistResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestGetUsernames_LargeGroupWithinTimeout(t *testing.T) {
+	users := make(map[int64]string)
+	ids := make([]int64, 0, 100)
+	for id := int64(1); id <= 100; id++ {
+		users[id] = "user"
+		ids = append(ids, id)
+	}
+	// Sequential lookups would need 5s, well past the per-lookup timeout.
+	service := &fakeUserService{users: users, delay: 50 * time.Millisecond}
+	client := &UserClient{client: service}
+
+	names, err := client.GetUsernames(context.Background(), ids)
+
+	require.NoError(t, err)
+	assert.Len(t, names, 100)
+	assert.LessOrEqual(t, service.peak, maxConcurrentLookups)
+}
+
+func TestGetUsernames_SkipsUnknownUsers(t *testing.T) {
+	service := &fakeUserService{users: map[int64]string{1: "alice"}}
+	client := &UserClient{client: service}
+
+	names, err := client.GetUsernames(context.Background(), []int64{1, 2})
+
+	require.NoError(t, err)
+	assert.Equal(t, map[int64]string{1: "alice"}, names)
+}
+
+func TestGetUsernames_ReportsPartialFailure(t *testing.T) {
+	service := &fakeUserService{
+		users: map[int64]string{1: "alice", 2: "bob", 3: "carol"},
+		fail:  map[int64]bool{2: true},
+	}
+	client := &UserClient{client: service}
+
+	names, err := client.GetUsernames(context.Background(), []int64{1, 2, 3})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 of 3")
+	assert.Equal(t, map[int64]string{1: "alice", 3: "carol"}, names)
+}
+
+func TestGetUsernames_CachesUsernames(t *testing.T) {
+	service := &fakeUserService{users: map[int64]string{1: "alice", 2: "bob"}}
+	client := &UserClient{client: service}
+
+	_, err := client.GetUsernames(context.Background(), []int64{1})
+	require.NoError(t, err)
+	names, err := client.GetUsernames(context.Background(), []int64{1, 2})
+
+	require.NoError(t, err)
+	assert.Equal(t, map[int64]string{1: "alice", 2: "bob"}, names)
+	assert.Equal(t, int32(2), service.calls.Load())
+}
@@ -1,11 +1,14 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
 	"github.com/zhanserikAmangeldi/chat-service/internal/core/service"
 	"github.com/zhanserikAmangeldi/chat-service/internal/middleware"
 )
@@ -85,6 +88,39 @@ func (h *ChatHandler) MarkAsRead(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
+func (h *ChatHandler) MarkReadUpTo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	type MarkReadUpToRequest struct {
+		ConversationID int64 `json:"conversation_id"`
+		MessageID      int64 `json:"message_id"`
+	}
+
+	var req MarkReadUpToRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	err := h.chatService.MarkReadUpTo(r.Context(), userID, req.ConversationID, req.MessageID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
 func (h *ChatHandler) AddReaction(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -231,6 +267,348 @@ func (h *ChatHandler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
+func (h *ChatHandler) ForwardMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	type ForwardMessagesRequest struct {
+		MessageIDs     []int64 `json:"message_ids"`
+		ConversationID int64   `json:"conversation_id"`
+	}
+
+	var req ForwardMessagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.MessageIDs) == 0 || req.ConversationID == 0 {
+		http.Error(w, "message_ids and conversation_id are required", http.StatusBadRequest)
+		return
+	}
+
+	messages, err := h.chatService.ForwardMessages(r.Context(), userID, req.MessageIDs, req.ConversationID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+func (h *ChatHandler) PinMessage(w http.ResponseWriter, r *http.Request) {
+	h.handlePinChange(w, r, h.chatService.PinMessage)
+}
+
+func (h *ChatHandler) UnpinMessage(w http.ResponseWriter, r *http.Request) {
+	h.handlePinChange(w, r, h.chatService.UnpinMessage)
+}
+
+func (h *ChatHandler) handlePinChange(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, userID, messageID int64) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	type PinRequest struct {
+		MessageID int64 `json:"message_id"`
+	}
+
+	var req PinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.MessageID == 0 {
+		http.Error(w, "message_id is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := change(r.Context(), userID, req.MessageID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+func (h *ChatHandler) GetPinnedMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID, err := parseOptionalID(r, "conversation_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if conversationID == 0 {
+		http.Error(w, "conversation_id is required", http.StatusBadRequest)
+		return
+	}
+
+	pins, err := h.chatService.GetPinnedMessages(r.Context(), userID, conversationID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pins)
+}
+
+func (h *ChatHandler) RenameGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	type RenameGroupRequest struct {
+		ConversationID int64  `json:"conversation_id"`
+		Name           string `json:"name"`
+	}
+
+	var req RenameGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.ConversationID == 0 || req.Name == "" {
+		http.Error(w, "conversation_id and name are required", http.StatusBadRequest)
+		return
+	}
+
+	err := h.chatService.RenameGroup(r.Context(), req.ConversationID, userID, req.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+func (h *ChatHandler) GetGroupMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	conversationIDStr := r.URL.Query().Get("conversation_id")
+	if conversationIDStr == "" {
+		http.Error(w, "conversation_id is required", http.StatusBadRequest)
+		return
+	}
+
+	conversationID, err := strconv.ParseInt(conversationIDStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid conversation_id", http.StatusBadRequest)
+		return
+	}
+
+	members, err := h.chatService.GetGroupMembers(r.Context(), conversationID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+func (h *ChatHandler) UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	type UpdateMemberRoleRequest struct {
+		ConversationID int64  `json:"conversation_id"`
+		UserID         int64  `json:"user_id"`
+		Role           string `json:"role"`
+	}
+
+	var req UpdateMemberRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.ConversationID == 0 || req.UserID == 0 || req.Role == "" {
+		http.Error(w, "conversation_id, user_id and role are required", http.StatusBadRequest)
+		return
+	}
+
+	err := h.chatService.UpdateMemberRole(r.Context(), req.ConversationID, userID, req.UserID, req.Role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+func (h *ChatHandler) AddGroupMember(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	type AddGroupMemberRequest struct {
+		ConversationID int64 `json:"conversation_id"`
+		UserID         int64 `json:"user_id"`
+	}
+
+	var req AddGroupMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.ConversationID == 0 || req.UserID == 0 {
+		http.Error(w, "conversation_id and user_id are required", http.StatusBadRequest)
+		return
+	}
+
+	err := h.chatService.AddGroupMember(r.Context(), req.ConversationID, userID, req.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+func (h *ChatHandler) RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	conversationIDStr := r.URL.Query().Get("conversation_id")
+	memberIDStr := r.URL.Query().Get("user_id")
+
+	if conversationIDStr == "" || memberIDStr == "" {
+		http.Error(w, "conversation_id and user_id are required", http.StatusBadRequest)
+		return
+	}
+
+	conversationID, err := strconv.ParseInt(conversationIDStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid conversation_id", http.StatusBadRequest)
+		return
+	}
+
+	memberID, err := strconv.ParseInt(memberIDStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user_id", http.StatusBadRequest)
+		return
+	}
+
+	err = h.chatService.RemoveGroupMember(r.Context(), conversationID, userID, memberID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+func (h *ChatHandler) LeaveGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	type LeaveGroupRequest struct {
+		ConversationID int64 `json:"conversation_id"`
+	}
+
+	var req LeaveGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.ConversationID == 0 {
+		http.Error(w, "conversation_id is required", http.StatusBadRequest)
+		return
+	}
+
+	err := h.chatService.LeaveGroup(r.Context(), req.ConversationID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
 func (h *ChatHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -252,27 +630,277 @@ func (h *ChatHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
 	var conversationID int64
 	fmt.Sscanf(conversationIDStr, "%d", &conversationID)
 
-	limitStr := r.URL.Query().Get("limit")
-	offsetStr := r.URL.Query().Get("offset")
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		fmt.Sscanf(limitStr, "%d", &limit)
+	}
+
+	cursor, err := parseMessageCursor(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.chatService.GetHistory(r.Context(), userID, conversationID, cursor, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func (h *ChatHandler) GetThread(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	rootID, err := parseOptionalID(r, "root_message_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rootID == 0 {
+		http.Error(w, "root_message_id is required", http.StatusBadRequest)
+		return
+	}
 
 	limit := 50
-	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		fmt.Sscanf(limitStr, "%d", &limit)
+	}
 
-	if limitStr != "" {
+	cursor, err := parseMessageCursor(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.chatService.GetThread(r.Context(), userID, rootID, cursor, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func (h *ChatHandler) SendThreadReply(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	type SendThreadReplyRequest struct {
+		RootMessageID int64  `json:"root_message_id"`
+		Content       string `json:"content"`
+	}
+
+	var req SendThreadReplyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.RootMessageID == 0 || req.Content == "" {
+		http.Error(w, "root_message_id and content are required", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := h.chatService.SendThreadReply(r.Context(), userID, req.RootMessageID, req.Content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
+
+func (h *ChatHandler) MarkThreadRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	type MarkThreadReadRequest struct {
+		RootMessageID int64 `json:"root_message_id"`
+		MessageID     int64 `json:"message_id"`
+	}
+
+	var req MarkThreadReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.RootMessageID == 0 || req.MessageID == 0 {
+		http.Error(w, "root_message_id and message_id are required", http.StatusBadRequest)
+		return
+	}
+
+	err := h.chatService.MarkThreadRead(r.Context(), userID, req.RootMessageID, req.MessageID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+func (h *ChatHandler) GetThreads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID, err := parseOptionalID(r, "conversation_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := 20
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
 		fmt.Sscanf(limitStr, "%d", &limit)
 	}
-	if offsetStr != "" {
-		fmt.Sscanf(offsetStr, "%d", &offset)
+
+	threads, err := h.chatService.GetUserThreads(r.Context(), userID, conversationID, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(threads)
+}
+
+func (h *ChatHandler) SearchMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	_ = userID // TODO: Check if user is participant
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
 
-	messages, err := h.chatService.GetHistory(r.Context(), conversationID, limit, offset)
+	query := r.URL.Query()
+	search := model.MessageSearch{
+		Query:       query.Get("q"),
+		MessageType: query.Get("message_type"),
+	}
+	if search.Query == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if search.ConversationID, err = parseOptionalID(r, "conversation_id"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if search.SenderID, err = parseOptionalID(r, "sender_id"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if search.BeforeID, err = parseOptionalID(r, "before_id"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if search.From, err = parseOptionalTime(r, "from"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if search.To, err = parseOptionalTime(r, "to"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := 20
+	if limitStr := query.Get("limit"); limitStr != "" {
+		fmt.Sscanf(limitStr, "%d", &limit)
+	}
+
+	page, err := h.chatService.SearchMessages(r.Context(), userID, search, limit)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	json.NewEncoder(w).Encode(page)
+}
+
+func parseMessageCursor(r *http.Request) (model.MessageCursor, error) {
+	var cursor model.MessageCursor
+	var err error
+
+	if cursor.BeforeID, err = parseOptionalID(r, "before_id"); err != nil {
+		return cursor, err
+	}
+	if cursor.AfterID, err = parseOptionalID(r, "after_id"); err != nil {
+		return cursor, err
+	}
+	if cursor.AroundID, err = parseOptionalID(r, "around_id"); err != nil {
+		return cursor, err
+	}
+	return cursor, nil
+}
+
+// parseOptionalTime parses an RFC 3339 query parameter, returning nil when it
+// is absent.
+func parseOptionalTime(r *http.Request, name string) (*time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s", name)
+	}
+	return &t, nil
+}
+
+func parseOptionalID(r *http.Request, name string) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+	return id, nil
 }
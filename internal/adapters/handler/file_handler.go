@@ -1,29 +1,43 @@
 package handler
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
 	"github.com/zhanserikAmangeldi/chat-service/internal/core/service"
 	"github.com/zhanserikAmangeldi/chat-service/internal/middleware"
 )
 
 type FileHandler struct {
-	minioService *service.MinioService
-	chatService  *service.ChatService
+	minioService   *service.MinioService
+	chatService    *service.ChatService
+	uploadService  *service.UploadService
+	imageService   *service.ImageService
+	scanService    *service.ScanService
+	presignService *service.PresignService
 }
 
-func NewFileHandler(minioService *service.MinioService, chatService *service.ChatService) *FileHandler {
+func NewFileHandler(minioService *service.MinioService, chatService *service.ChatService, uploadService *service.UploadService, imageService *service.ImageService, scanService *service.ScanService, presignService *service.PresignService) *FileHandler {
 	return &FileHandler{
-		minioService: minioService,
-		chatService:  chatService,
+		minioService:   minioService,
+		chatService:    chatService,
+		uploadService:  uploadService,
+		imageService:   imageService,
+		scanService:    scanService,
+		presignService: presignService,
 	}
 }
 
@@ -34,14 +48,18 @@ const (
 	MaxVideoSize = 100 * 1024 * 1024 // 100 MB
 )
 
+// UploadFileResponse describes a stored file. Its Attachment is what a
+// message is sent with. Messages sent while FileStatus is pending are
+// delivered once the file has been scanned; until then the file has no URL.
 type UploadFileResponse struct {
-	FileURL     string `json:"file_url"`
+	FileURL     string `json:"file_url,omitempty"`
 	FileName    string `json:"file_name"`
 	FileSize    int64  `json:"file_size"`
 	MimeType    string `json:"mime_type"`
 	MessageType string `json:"message_type"`
-	ObjectName  string `json:"object_name"`
-	Bucket      string `json:"bucket"`
+	FileStatus  string `json:"file_status"`
+	model.Attachment
+	model.ImageInfo
 }
 
 func (h *FileHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
@@ -69,9 +87,10 @@ func (h *FileHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	contentType := header.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = "application/octet-stream"
+	contentType, err := sniffContentType(file, header.Header.Get("Content-Type"))
+	if err != nil {
+		writeSniffError(w, err)
+		return
 	}
 
 	fileSize := header.Size
@@ -96,26 +115,82 @@ func (h *FileHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fileURL, err := h.minioService.GetFileURL(r.Context(), bucket, objectName, 7*24*time.Hour)
+	response, err := h.uploadResponse(r.Context(), userID, bucket, objectName, header.Filename, fileSize, contentType)
 	if err != nil {
 		http.Error(w, "Failed to generate file URL: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	messageType := determineMessageType(contentType)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-	response := UploadFileResponse{
+// uploadResponse queues a stored file for scanning and describes it to the
+// client that uploaded it. Links to the file are only handed out once it is
+// known to be clean.
+func (h *FileHandler) uploadResponse(ctx context.Context, userID int64, bucket, objectName, fileName string, fileSize int64, contentType string) (*UploadFileResponse, error) {
+	info := h.describeImage(ctx, bucket, objectName)
+	status, err := h.scanService.Register(ctx, userID, bucket, objectName, fileName, info)
+	if err != nil {
+		return nil, err
+	}
+	return h.fileResponse(ctx, bucket, objectName, fileName, fileSize, contentType, status, info)
+}
+
+// fileResponse describes a registered file with the given scan status and
+// image info.
+func (h *FileHandler) fileResponse(ctx context.Context, bucket, objectName, fileName string, fileSize int64, contentType, status string, info model.ImageInfo) (*UploadFileResponse, error) {
+	var err error
+	var fileURL string
+	if status == model.FileStatusClean {
+		fileURL, err = h.minioService.GetFileURL(ctx, bucket, objectName, 7*24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		info.Thumbnails = service.SignThumbnails(ctx, h.minioService, info.Thumbnails)
+	} else {
+		info = model.ImageInfo{}
+	}
+
+	return &UploadFileResponse{
 		FileURL:     fileURL,
-		FileName:    header.Filename,
+		FileName:    fileName,
 		FileSize:    fileSize,
 		MimeType:    contentType,
-		MessageType: messageType,
-		ObjectName:  objectName,
-		Bucket:      bucket,
+		MessageType: determineMessageType(contentType),
+		FileStatus:  status,
+		Attachment: model.Attachment{
+			Bucket:     bucket,
+			ObjectName: objectName,
+		},
+		ImageInfo: info,
+	}, nil
+}
+
+// describeImage makes the thumbnails of a newly stored image. Its result is
+// recorded with the object, so messages sent with it get the same info.
+// Images that cannot be processed are still sent, just without thumbnails.
+func (h *FileHandler) describeImage(ctx context.Context, bucket, objectName string) model.ImageInfo {
+	if bucket != service.ImagesBucket {
+		return model.ImageInfo{}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	info, err := h.imageService.Process(ctx, objectName)
+	if err != nil {
+		log.Printf("Failed to process image %s: %v", objectName, err)
+		return model.ImageInfo{}
+	}
+	return info
+}
+
+// deleteFile removes a stored file together with any thumbnails made for it
+// and its scan record.
+func (h *FileHandler) deleteFile(ctx context.Context, bucket, objectName string) {
+	_ = h.minioService.DeleteFile(ctx, bucket, objectName)
+	if bucket == service.ImagesBucket {
+		h.imageService.DeleteThumbnails(ctx, objectName)
+	}
+	h.scanService.Forget(ctx, bucket, objectName)
 }
 
 func (h *FileHandler) SendMessageWithFile(w http.ResponseWriter, r *http.Request) {
@@ -146,6 +221,11 @@ func (h *FileHandler) SendMessageWithFile(w http.ResponseWriter, r *http.Request
 	conversationIDStr := r.FormValue("conversation_id")
 	recipientIDStr := r.FormValue("recipient_id")
 	caption := r.FormValue("caption")
+	replyToStr := r.FormValue("reply_to_message_id")
+	var clientMsgID *string
+	if value := r.FormValue("client_msg_id"); value != "" {
+		clientMsgID = &value
+	}
 
 	var conversationID, recipientID int64
 	if conversationIDStr != "" {
@@ -155,9 +235,20 @@ func (h *FileHandler) SendMessageWithFile(w http.ResponseWriter, r *http.Request
 		recipientID, _ = strconv.ParseInt(recipientIDStr, 10, 64)
 	}
 
-	contentType := header.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = "application/octet-stream"
+	var replyToMessageID *int64
+	if replyToStr != "" {
+		replyTo, err := strconv.ParseInt(replyToStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid reply_to_message_id", http.StatusBadRequest)
+			return
+		}
+		replyToMessageID = &replyTo
+	}
+
+	contentType, err := sniffContentType(file, header.Header.Get("Content-Type"))
+	if err != nil {
+		writeSniffError(w, err)
+		return
 	}
 
 	fileSize := header.Size
@@ -181,15 +272,20 @@ func (h *FileHandler) SendMessageWithFile(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	fileURL, err := h.minioService.GetFileURL(r.Context(), bucket, objectName, 7*24*time.Hour)
-	if err != nil {
-		http.Error(w, "Failed to generate file URL", http.StatusInternalServerError)
+	fileName := header.Filename
+	info := h.describeImage(r.Context(), bucket, objectName)
+	if _, err := h.scanService.Register(r.Context(), userID, bucket, objectName, fileName, info); err != nil {
+		h.deleteFile(r.Context(), bucket, objectName)
+		http.Error(w, "Failed to register file", http.StatusInternalServerError)
 		return
 	}
 
 	messageType := determineMessageType(contentType)
+	attachment := model.Attachment{
+		Bucket:     bucket,
+		ObjectName: objectName,
+	}
 
-	fileName := header.Filename
 	msg, err := h.chatService.SendMessage(
 		r.Context(),
 		userID,
@@ -197,17 +293,25 @@ func (h *FileHandler) SendMessageWithFile(w http.ResponseWriter, r *http.Request
 		caption,
 		conversationID,
 		messageType,
-		&fileURL,
 		&fileName,
 		&contentType,
 		&fileSize,
+		replyToMessageID,
+		clientMsgID,
+		attachment,
 	)
 	if err != nil {
-		_ = h.minioService.DeleteFile(r.Context(), bucket, objectName)
+		h.deleteFile(r.Context(), bucket, objectName)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// A retried upload got the message of the first attempt, whose file is
+	// already stored.
+	if msg.FileObject == nil || *msg.FileObject != objectName {
+		h.deleteFile(r.Context(), bucket, objectName)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(msg)
 }
@@ -238,6 +342,16 @@ func (h *FileHandler) GetFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	scan, err := h.scanService.Lookup(r.Context(), bucket, objectName)
+	if err != nil {
+		http.Error(w, "Failed to look up file", http.StatusInternalServerError)
+		return
+	}
+	if scan != nil && scan.Status != model.FileStatusClean {
+		http.Error(w, "File has not been scanned yet", http.StatusForbidden)
+		return
+	}
+
 	fileURL, err := h.minioService.GetFileURL(r.Context(), bucket, objectName, time.Hour)
 	if err != nil {
 		http.Error(w, "Failed to generate file URL", http.StatusInternalServerError)
@@ -250,26 +364,241 @@ func (h *FileHandler) GetFile(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+type PresignUploadResponse struct {
+	UploadID  string            `json:"upload_id"`
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	FormData  map[string]string `json:"form_data,omitempty"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// PresignUpload lets the client upload a file straight to storage instead of
+// through this service. A PUT must declare the exact size up front; a POST
+// policy accepts anything up to the limit for the content type. The upload
+// has to be finalized with its upload_id before the file can be sent.
+func (h *FileHandler) PresignUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	type PresignUploadRequest struct {
+		Method   string `json:"method"`
+		FileName string `json:"file_name"`
+		MimeType string `json:"mime_type"`
+		FileSize int64  `json:"file_size"`
+	}
+
+	var req PresignUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if !isAllowedFileType(req.MimeType) {
+		http.Error(w, "File type not allowed: "+req.MimeType, http.StatusBadRequest)
+		return
+	}
+
+	var method string
+	var size int64
+	switch strings.ToUpper(req.Method) {
+	case "", http.MethodPut:
+		if req.FileSize <= 0 {
+			http.Error(w, "file_size is required", http.StatusBadRequest)
+			return
+		}
+		if err := validateFileSize(req.MimeType, req.FileSize); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		method, size = http.MethodPut, req.FileSize
+	case http.MethodPost:
+		method, size = http.MethodPost, maxFileSize(req.MimeType)
+	default:
+		http.Error(w, "method must be PUT or POST", http.StatusBadRequest)
+		return
+	}
+
+	upload, request, err := h.presignService.Presign(r.Context(), userID, method, req.FileName, req.MimeType, size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := PresignUploadResponse{
+		UploadID:  upload.ID,
+		Method:    request.Method,
+		URL:       request.URL,
+		Headers:   request.Headers,
+		FormData:  request.FormData,
+		ExpiresAt: upload.ExpiresAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// FinalizeUpload moves a file uploaded through PresignUpload out of reach of
+// its presigned request, checks it and returns what is needed to send it.
+// Files that do not match the limits enforced on regular uploads are deleted.
+func (h *FileHandler) FinalizeUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	type FinalizeUploadRequest struct {
+		UploadID string `json:"upload_id"`
+	}
+
+	var req FinalizeUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	upload, objectName, err := h.presignService.Finalize(r.Context(), userID, req.UploadID)
+	if errors.Is(err, service.ErrUploadNotFound) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bucket := upload.Bucket
+
+	info, err := h.minioService.GetFileInfo(r.Context(), bucket, objectName)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	reject := func(reason string) {
+		_ = h.minioService.DeleteFile(r.Context(), bucket, objectName)
+		http.Error(w, reason, http.StatusBadRequest)
+	}
+
+	contentType, err := h.sniffStoredFile(r.Context(), bucket, objectName, info.ContentType)
+	if errors.Is(err, service.ErrContentTypeMismatch) {
+		reject(err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !isAllowedFileType(contentType) {
+		reject("File type not allowed: " + contentType)
+		return
+	}
+	if service.DetermineBucket(contentType) != bucket {
+		reject("File type does not match bucket")
+		return
+	}
+	if info.Size <= 0 {
+		reject("File is empty")
+		return
+	}
+	if err := validateFileSize(contentType, info.Size); err != nil {
+		reject(err.Error())
+		return
+	}
+
+	fileName := upload.FileName
+	if fileName == "" {
+		fileName = filepath.Base(objectName)
+	}
+
+	response, err := h.uploadResponse(r.Context(), userID, bucket, objectName, fileName, info.Size, contentType)
+	if err != nil {
+		http.Error(w, "Failed to generate file URL: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// sniffContentType checks the type claimed for an uploaded file against its
+// leading bytes and rewinds the file for storing.
+func sniffContentType(file multipart.File, claimed string) (string, error) {
+	head, err := readHead(file)
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return service.ResolveContentType(head, claimed)
+}
+
+// sniffStoredFile checks the type claimed for a file that was uploaded
+// without passing through this service.
+func (h *FileHandler) sniffStoredFile(ctx context.Context, bucket, objectName, claimed string) (string, error) {
+	obj, err := h.minioService.GetFile(ctx, bucket, objectName)
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+
+	head, err := readHead(obj)
+	if err != nil {
+		return "", err
+	}
+	return service.ResolveContentType(head, claimed)
+}
+
+func readHead(r io.Reader) ([]byte, error) {
+	head := make([]byte, service.SniffLength)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+func writeSniffError(w http.ResponseWriter, err error) {
+	if errors.Is(err, service.ErrContentTypeMismatch) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "Failed to read file: "+err.Error(), http.StatusInternalServerError)
+}
+
 func validateFileSize(contentType string, size int64) error {
+	if limit := maxFileSize(contentType); size > limit {
+		return fmt.Errorf("%s size exceeds limit of %d MB", determineMessageType(contentType), limit/(1024*1024))
+	}
+	return nil
+}
+
+func maxFileSize(contentType string) int64 {
 	switch {
 	case isImage(contentType):
-		if size > MaxImageSize {
-			return fmt.Errorf("image size exceeds limit of %d MB", MaxImageSize/(1024*1024))
-		}
+		return MaxImageSize
 	case isAudio(contentType):
-		if size > MaxAudioSize {
-			return fmt.Errorf("audio size exceeds limit of %d MB", MaxAudioSize/(1024*1024))
-		}
+		return MaxAudioSize
 	case isVideo(contentType):
-		if size > MaxVideoSize {
-			return fmt.Errorf("video size exceeds limit of %d MB", MaxVideoSize/(1024*1024))
-		}
+		return MaxVideoSize
 	default:
-		if size > MaxFileSize {
-			return fmt.Errorf("file size exceeds limit of %d MB", MaxFileSize/(1024*1024))
-		}
+		return MaxFileSize
 	}
-	return nil
 }
 
 func isAllowedFileType(contentType string) bool {
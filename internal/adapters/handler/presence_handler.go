@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/service"
+	"github.com/zhanserikAmangeldi/chat-service/internal/middleware"
+)
+
+type PresenceHandler struct {
+	presenceService *service.PresenceService
+}
+
+func NewPresenceHandler(presenceService *service.PresenceService) *PresenceHandler {
+	return &PresenceHandler{presenceService: presenceService}
+}
+
+// GetPresence answers GET /api/v1/presence?user_ids=1,2,3.
+func (h *PresenceHandler) GetPresence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	idsParam := r.URL.Query().Get("user_ids")
+	if idsParam == "" {
+		http.Error(w, "user_ids is required", http.StatusBadRequest)
+		return
+	}
+
+	var userIDs []int64
+	for _, part := range strings.Split(idsParam, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid user_ids", http.StatusBadRequest)
+			return
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	presences, err := h.presenceService.GetPresence(r.Context(), userID, userIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(presences)
+}
+
+func (h *PresenceHandler) SetVisibility(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	type SetVisibilityRequest struct {
+		Visibility string `json:"visibility"`
+	}
+
+	var req SetVisibilityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.presenceService.SetVisibility(r.Context(), userID, req.Visibility); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
@@ -0,0 +1,281 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/service"
+	"github.com/zhanserikAmangeldi/chat-service/internal/middleware"
+)
+
+// Resumable uploads follow the tus protocol: POST creates an upload, HEAD
+// reports how many bytes arrived, and PATCH appends a chunk at that offset.
+const (
+	tusVersion     = "1.0.0"
+	uploadsPath    = "/api/v1/files/uploads"
+	offsetMimeType = "application/offset+octet-stream"
+)
+
+// CreateUpload starts a resumable upload. The client declares the file size
+// in Upload-Length and its name and type in Upload-Metadata.
+func (h *FileHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size <= 0 {
+		http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "Invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+
+	fileName := metadata["filename"]
+	if fileName == "" {
+		http.Error(w, "filename is required in Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+
+	contentType := metadata["filetype"]
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	if err := validateFileSize(contentType, size); err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if !isAllowedFileType(contentType) {
+		http.Error(w, "File type not allowed: "+contentType, http.StatusBadRequest)
+		return
+	}
+
+	upload, err := h.uploadService.CreateUpload(r.Context(), userID, fileName, contentType, size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", uploadsPath+"/"+upload.ID)
+	setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Upload serves an upload created by CreateUpload: HEAD reports its offset,
+// PATCH appends a chunk and DELETE abandons it. The PATCH storing the last
+// chunk answers with the same body as UploadFile. Until the finished upload
+// expires, sending that chunk again or a GET answers with it too.
+func (h *FileHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	id := strings.TrimPrefix(r.URL.Path, uploadsPath+"/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		upload, err := h.uploadService.GetUpload(r.Context(), userID, id)
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		setUploadHeaders(w, upload)
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodGet:
+		upload, err := h.uploadService.GetUpload(r.Context(), userID, id)
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+
+		setUploadHeaders(w, upload)
+		if !upload.Done() {
+			http.Error(w, "Upload is not finished", http.StatusConflict)
+			return
+		}
+		h.writeFinishedUpload(w, r, userID, upload)
+
+	case http.MethodPatch:
+		h.writeChunk(w, r, userID, id)
+
+	case http.MethodDelete:
+		if err := h.uploadService.CancelUpload(r.Context(), userID, id); err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *FileHandler) writeChunk(w http.ResponseWriter, r *http.Request, userID int64, id string) {
+	if r.Header.Get("Content-Type") != offsetMimeType {
+		http.Error(w, "Content-Type must be "+offsetMimeType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	if r.ContentLength <= 0 {
+		http.Error(w, "Content-Length is required", http.StatusLengthRequired)
+		return
+	}
+
+	var body io.Reader = http.MaxBytesReader(w, r.Body, r.ContentLength)
+
+	// Reject a file that is not what it claims to be before any more of it
+	// is uploaded.
+	if offset == 0 {
+		upload, err := h.uploadService.GetUpload(r.Context(), userID, id)
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+
+		buffered := bufio.NewReaderSize(body, service.SniffLength)
+		head, _ := buffered.Peek(service.SniffLength)
+		if _, err := service.ResolveContentType(head, upload.MimeType); err != nil {
+			_ = h.uploadService.CancelUpload(r.Context(), userID, id)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = buffered
+	}
+
+	upload, err := h.uploadService.WriteChunk(r.Context(), userID, id, offset, body, r.ContentLength)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	setUploadHeaders(w, upload)
+	if !upload.Done() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	h.writeFinishedUpload(w, r, userID, upload)
+}
+
+// writeFinishedUpload answers with the stored file of a finished upload. The
+// file is checked and registered for scanning the first time; afterwards the
+// recorded result is returned.
+func (h *FileHandler) writeFinishedUpload(w http.ResponseWriter, r *http.Request, userID int64, upload *model.FileUpload) {
+	scan, err := h.scanService.Lookup(r.Context(), upload.Bucket, upload.ObjectName)
+	if err != nil {
+		http.Error(w, "Failed to look up file", http.StatusInternalServerError)
+		return
+	}
+	if scan != nil && scan.Status == model.FileStatusInfected {
+		http.Error(w, "File was infected and has been deleted", http.StatusGone)
+		return
+	}
+
+	contentType, err := h.sniffStoredFile(r.Context(), upload.Bucket, upload.ObjectName, upload.MimeType)
+	if err != nil {
+		if errors.Is(err, service.ErrContentTypeMismatch) {
+			h.deleteFile(r.Context(), upload.Bucket, upload.ObjectName)
+		}
+		writeSniffError(w, err)
+		return
+	}
+
+	var response *UploadFileResponse
+	if scan != nil {
+		response, err = h.fileResponse(r.Context(), upload.Bucket, upload.ObjectName, upload.FileName, upload.Size, contentType, scan.Status, scan.ImageInfo)
+	} else {
+		response, err = h.uploadResponse(r.Context(), userID, upload.Bucket, upload.ObjectName, upload.FileName, upload.Size, contentType)
+	}
+	if err != nil {
+		http.Error(w, "Failed to generate file URL: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func setUploadHeaders(w http.ResponseWriter, upload *model.FileUpload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	if !upload.Done() {
+		w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+func writeUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrUploadNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrUploadOffsetMismatch):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrUploadChunkTooSmall):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrUploadChunkTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header: comma separated
+// pairs of a key and its base64 encoded value.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, errors.New("malformed metadata pair")
+		}
+	}
+	return metadata, nil
+}
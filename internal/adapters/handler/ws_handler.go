@@ -3,14 +3,18 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"github.com/zhanserikAmangeldi/chat-service/internal/core/ports"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	ws "github.com/gorilla/websocket"
 	"github.com/zhanserikAmangeldi/chat-service/internal/adapters/websocket"
 	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/service"
 	redisAdapter "github.com/zhanserikAmangeldi/chat-service/internal/redis"
 )
 
@@ -19,6 +23,47 @@ type WSHandler struct {
 	jwtSecret   string
 	redisClient *redisAdapter.RedisClient
 	chatRepo    ports.ChatRepository
+	presence    *service.PresenceService
+	events      redisAdapter.IEventLog
+	chatService *service.ChatService
+}
+
+// maxCatchUpEvents is how many missed events a reconnecting client is sent.
+// Beyond that it is told to reload instead.
+const maxCatchUpEvents = 100
+
+// Payloads of the requests clients make over the socket. Field names match
+// the equivalent HTTP endpoints.
+type wsSendMessageRequest struct {
+	RecipientID    int64   `json:"recipient_id"`
+	ConversationID int64   `json:"conversation_id"`
+	Content        string  `json:"content"`
+	MessageType    string  `json:"message_type"`
+	FileName       *string `json:"file_name,omitempty"`
+	MimeType       *string `json:"mime_type,omitempty"`
+	FileSize       *int64  `json:"file_size,omitempty"`
+	ReplyTo        *int64  `json:"reply_to_message_id,omitempty"`
+	ClientMsgID    *string `json:"client_msg_id,omitempty"`
+	model.Attachment
+}
+
+type wsEditRequest struct {
+	MessageID  int64  `json:"message_id"`
+	NewContent string `json:"new_content"`
+}
+
+type wsMessageRequest struct {
+	MessageID int64 `json:"message_id"`
+}
+
+type wsReadUpToRequest struct {
+	ConversationID int64 `json:"conversation_id"`
+	MessageID      int64 `json:"message_id"`
+}
+
+type wsReactionRequest struct {
+	MessageID int64  `json:"message_id"`
+	Reaction  string `json:"reaction"`
 }
 
 var upgrader = ws.Upgrader{
@@ -29,12 +74,15 @@ var upgrader = ws.Upgrader{
 	},
 }
 
-func NewWSHandler(manager *websocket.ClientManager, jwtSecret string, redisClient *redisAdapter.RedisClient, chatRepo ports.ChatRepository) *WSHandler {
+func NewWSHandler(manager *websocket.ClientManager, jwtSecret string, redisClient *redisAdapter.RedisClient, chatRepo ports.ChatRepository, presence *service.PresenceService, events redisAdapter.IEventLog, chatService *service.ChatService) *WSHandler {
 	return &WSHandler{
 		manager:     manager,
 		jwtSecret:   jwtSecret,
 		redisClient: redisClient,
 		chatRepo:    chatRepo,
+		presence:    presence,
+		events:      events,
+		chatService: chatService,
 	}
 }
 
@@ -75,18 +123,67 @@ func (h *WSHandler) HandleConnection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.manager.AddClient(userID, conn)
-	log.Printf("[WS] User connected: %d", userID)
+	session := h.manager.NewSession(userID, r.URL.Query().Get("device_id"), conn)
+
+	// Queue the session info first so the client learns its session ID
+	// before any event.
+	sessionInfo, _ := json.Marshal(model.WSMessage{
+		Type:    "session",
+		Payload: model.SessionInfo{SessionID: session.ID, DeviceID: session.DeviceID},
+	})
+	session.Send(sessionInfo)
+
+	// Live frames are held back until what the client missed has been
+	// queued ahead of them.
+	session.Hold()
+
+	h.manager.AddClient(session)
+	go session.WritePump()
+	log.Printf("[WS] User %d connected on device %s (session %s)", userID, session.DeviceID, session.ID)
+
+	// The route is registered before the replay is read, so that an event
+	// appended in between is at least delivered live. Live frames the replay
+	// already covered are skipped by the session.
+	if err := h.redisClient.RegisterUser(r.Context(), userID); err != nil {
+		log.Printf("[WS] Failed to register route of user %d: %v", userID, err)
+	}
+
+	lastEventID, catchUp := parseLastEventID(r)
+	h.replayMissed(r.Context(), session, lastEventID, catchUp && h.events != nil)
+
+	if err := h.presence.Connect(r.Context(), userID, session.ID); err != nil {
+		log.Printf("[WS] Failed to record presence of user %d: %v", userID, err)
+	}
 
+	// Whether the client closed the socket, stopped answering pings or was
+	// dropped as a slow consumer, the read below fails and this runs.
 	defer func() {
-		h.manager.RemoveClient(userID)
-		log.Printf("[WS] User disconnected: %d", userID)
+		remaining := h.manager.RemoveClient(userID, session.ID)
+		log.Printf("[WS] User %d disconnected session %s, %d sessions left", userID, session.ID, remaining)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if remaining == 0 {
+			if err := h.redisClient.UnregisterUser(ctx, userID); err != nil {
+				log.Printf("[WS] Failed to unregister route of user %d: %v", userID, err)
+			}
+		}
+		if err := h.presence.Disconnect(ctx, userID, session.ID); err != nil {
+			log.Printf("[WS] Failed to clear presence of user %d: %v", userID, err)
+		}
 	}()
 
+	session.PrepareRead(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := h.presence.Heartbeat(ctx, userID, session.ID); err != nil {
+			log.Printf("[WS] Failed to refresh presence of user %d: %v", userID, err)
+		}
+	})
 	for {
-		_, message, err := conn.ReadMessage()
+		message, err := session.ReadMessage()
 		if err != nil {
-			log.Printf("[WS] User %d disconnected: %v", userID, err)
+			log.Printf("[WS] User %d session %s disconnected: %v", userID, session.ID, err)
 			break
 		}
 
@@ -96,11 +193,114 @@ func (h *WSHandler) HandleConnection(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		h.handleWSMessage(r.Context(), userID, wsMsg)
+		h.handleWSMessage(r.Context(), session, wsMsg)
 	}
 }
 
-func (h *WSHandler) handleWSMessage(ctx context.Context, userID int64, msg model.WSMessage) {
+func parseLastEventID(r *http.Request) (int64, bool) {
+	value := r.URL.Query().Get("last_event_id")
+	if value == "" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// replayMissed queues what the client missed while disconnected and resumes
+// live delivery: with a last_event_id the events after it and a caught_up
+// frame, and in any case the messages the user has not acknowledged yet.
+func (h *WSHandler) replayMissed(ctx context.Context, session *websocket.Session, lastEventID int64, catchUp bool) {
+	var replay [][]byte
+	result := model.CatchUpResult{LastEventID: lastEventID}
+	replayedMessages := make(map[int64]bool)
+
+	if catchUp {
+		replay, result = h.catchUp(ctx, session.UserID, lastEventID, replayedMessages)
+	}
+
+	undelivered, err := h.chatService.GetUndeliveredMessages(ctx, session.UserID)
+	if err != nil {
+		log.Printf("[WS] Failed to load undelivered messages of user %d: %v", session.UserID, err)
+	}
+	for i := range undelivered {
+		if replayedMessages[undelivered[i].ID] {
+			continue
+		}
+		data, err := json.Marshal(model.WSMessage{Type: "message", Payload: &undelivered[i]})
+		if err != nil {
+			continue
+		}
+		replay = append(replay, data)
+		replayedMessages[undelivered[i].ID] = true
+	}
+
+	if catchUp {
+		done, _ := json.Marshal(model.WSMessage{Type: "caught_up", Payload: result})
+		replay = append(replay, done)
+	}
+
+	session.Resume(replay, func(data []byte) bool {
+		var frame struct {
+			Type    string `json:"type"`
+			EventID int64  `json:"event_id"`
+			Payload struct {
+				ID int64 `json:"id"`
+			} `json:"payload"`
+		}
+		if json.Unmarshal(data, &frame) != nil {
+			return false
+		}
+		if catchUp && frame.EventID > 0 && frame.EventID <= result.LastEventID {
+			return true
+		}
+		return frame.Type == "message" && replayedMessages[frame.Payload.ID]
+	})
+}
+
+// catchUp builds the frames of the user's events after lastEventID, noting
+// the messages among them in replayedMessages.
+func (h *WSHandler) catchUp(ctx context.Context, userID, lastEventID int64, replayedMessages map[int64]bool) ([][]byte, model.CatchUpResult) {
+	result := model.CatchUpResult{LastEventID: lastEventID}
+
+	events, complete, err := h.events.EventsSince(ctx, userID, lastEventID)
+	if err != nil {
+		log.Printf("[WS] Failed to load missed events of user %d: %v", userID, err)
+		return nil, result
+	}
+
+	if len(events) > 0 {
+		result.LastEventID = events[len(events)-1].EventIDs[userID]
+	}
+	if len(events) > maxCatchUpEvents {
+		return nil, result
+	}
+	result.Complete = complete
+
+	var frames [][]byte
+	for _, event := range events {
+		message, ok := event.WSMessage()
+		if !ok {
+			continue
+		}
+		message.EventID = event.EventIDs[userID]
+		data, err := json.Marshal(message)
+		if err != nil {
+			continue
+		}
+		frames = append(frames, data)
+		if event.Type == "message" {
+			replayedMessages[event.Message.ID] = true
+		}
+	}
+	return frames, result
+}
+
+func (h *WSHandler) handleWSMessage(ctx context.Context, session *websocket.Session, msg model.WSMessage) {
+	userID := session.UserID
+
 	switch msg.Type {
 	case "typing":
 		var typingEvent model.TypingEvent
@@ -121,6 +321,20 @@ func (h *WSHandler) handleWSMessage(ctx context.Context, userID int64, msg model
 
 		_ = h.redisClient.PublishTyping(ctx, typingEvent, recipients)
 
+	case "send_message", "edit", "delete", "react", "unreact", "mark_read", "mark_read_up_to":
+		h.handleRequest(ctx, session, msg)
+
+	case "ack":
+		var ack model.AckEvent
+		if err := decodePayload(msg.Payload, &ack); err != nil {
+			log.Printf("[WS] Invalid ack: %v", err)
+			return
+		}
+
+		if err := h.chatService.AcknowledgeMessages(ctx, userID, ack.MessageIDs); err != nil {
+			log.Printf("[WS] Failed to record deliveries for user %d: %v", userID, err)
+		}
+
 	case "status":
 		var statusEvent model.OnlineStatusEvent
 		data, _ := json.Marshal(msg.Payload)
@@ -129,25 +343,98 @@ func (h *WSHandler) handleWSMessage(ctx context.Context, userID int64, msg model
 			return
 		}
 
-		statusEvent.UserID = userID
+		// Clients only pick between online and away for this session;
+		// offline follows from the connection closing.
+		if err := h.presence.SetStatus(ctx, userID, session.ID, statusEvent.Status); err != nil {
+			log.Printf("[WS] Invalid status from user %d: %v", userID, err)
+		}
+	}
+}
 
-		// TODO: Broadcast to user's contacts/chat participants
-		conversations, err := h.chatRepo.GetUserConversations(ctx, userID, 100, 0)
+// handleRequest runs a client request through the chat service and answers
+// the requesting session with a response frame carrying the request ID.
+func (h *WSHandler) handleRequest(ctx context.Context, session *websocket.Session, msg model.WSMessage) {
+	var response model.WSResponse
+	if msg.RequestID == "" {
+		response.Error = "request_id is required"
+	} else {
+		data, err := h.runRequest(ctx, session.UserID, msg)
 		if err != nil {
-			log.Printf("[WS] Failed to get user conversations: %v", err)
-			return
+			response.Error = err.Error()
+		} else {
+			response.OK = true
+			response.Data = data
 		}
+	}
 
-		var recipients []int64
-		for _, conv := range conversations {
-			parts, _ := h.chatRepo.GetParticipants(ctx, conv.ID)
-			for _, p := range parts {
-				if p != userID {
-					recipients = append(recipients, p)
-				}
-			}
+	frame, err := json.Marshal(model.WSMessage{Type: "response", RequestID: msg.RequestID, Payload: response})
+	if err != nil {
+		log.Printf("[WS] Failed to marshal response: %v", err)
+		return
+	}
+	session.Send(frame)
+}
+
+func (h *WSHandler) runRequest(ctx context.Context, userID int64, msg model.WSMessage) (interface{}, error) {
+	switch msg.Type {
+	case "send_message":
+		var req wsSendMessageRequest
+		if err := decodePayload(msg.Payload, &req); err != nil {
+			return nil, err
+		}
+		return h.chatService.SendMessage(ctx, userID, req.RecipientID, req.Content, req.ConversationID, req.MessageType,
+			req.FileName, req.MimeType, req.FileSize, req.ReplyTo, req.ClientMsgID, req.Attachment)
+
+	case "edit":
+		var req wsEditRequest
+		if err := decodePayload(msg.Payload, &req); err != nil {
+			return nil, err
+		}
+		return nil, h.chatService.EditMessage(ctx, req.MessageID, userID, req.NewContent)
+
+	case "delete":
+		var req wsMessageRequest
+		if err := decodePayload(msg.Payload, &req); err != nil {
+			return nil, err
 		}
+		return nil, h.chatService.DeleteMessage(ctx, req.MessageID, userID)
 
-		_ = h.redisClient.PublishStatus(ctx, statusEvent, recipients)
+	case "react", "unreact":
+		var req wsReactionRequest
+		if err := decodePayload(msg.Payload, &req); err != nil {
+			return nil, err
+		}
+		if msg.Type == "react" {
+			return nil, h.chatService.AddReaction(ctx, req.MessageID, userID, req.Reaction)
+		}
+		return nil, h.chatService.RemoveReaction(ctx, req.MessageID, userID, req.Reaction)
+
+	case "mark_read":
+		var req wsMessageRequest
+		if err := decodePayload(msg.Payload, &req); err != nil {
+			return nil, err
+		}
+		return nil, h.chatService.MarkMessageAsRead(ctx, req.MessageID, userID)
+
+	case "mark_read_up_to":
+		var req wsReadUpToRequest
+		if err := decodePayload(msg.Payload, &req); err != nil {
+			return nil, err
+		}
+		return nil, h.chatService.MarkReadUpTo(ctx, userID, req.ConversationID, req.MessageID)
+	}
+
+	return nil, errors.New("unknown request type")
+}
+
+// decodePayload converts a frame's generic payload into v.
+func decodePayload(payload interface{}, v interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("invalid payload")
 	}
+	return nil
 }
@@ -4,12 +4,32 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
 	"github.com/zhanserikAmangeldi/chat-service/internal/core/ports"
 )
 
+// messageColumns lists the messages columns scanned into model.Message.
+const messageColumns = `id, conversation_id, sender_id, content, message_type,
+	` + scannedFileURL + `, file_name, file_size, mime_type,
+	created_at, edited_at, deleted_at, reply_to_message_id, thread_root_id,
+	forwarded_from_message_id, forwarded_from_sender_id, forwarded_from_conversation_id, client_msg_id,
+	image_width, image_height, blurhash, ` + scannedThumbnails + `,
+	file_bucket, file_object, file_status`
+
+// scannedFileURL and scannedThumbnails leave out the stored URLs of a file
+// that is still being scanned, so nothing read from the database can hand
+// out a link to it before it is found clean.
+const (
+	scannedFileURL    = `CASE WHEN file_status = 'pending' THEN NULL ELSE file_url END AS file_url`
+	scannedThumbnails = `CASE WHEN file_status = 'pending' THEN NULL ELSE thumbnails END AS thumbnails`
+)
+
 type PostgresRepository struct {
 	db *sqlx.DB
 }
@@ -23,19 +43,56 @@ func (r *PostgresRepository) CreateConversation(ctx context.Context, conv *model
 	return r.db.QueryRowContext(ctx, query, conv.IsGroup, conv.Name, conv.CreatedAt).Scan(&conv.ID)
 }
 
+func (r *PostgresRepository) UpdateConversationName(ctx context.Context, id int64, name string) error {
+	query := `UPDATE conversations SET name = $2 WHERE id = $1`
+	result, err := r.db.ExecContext(ctx, query, id, name)
+	if err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("conversation not found")
+	}
+	return nil
+}
+
 func (r *PostgresRepository) AddParticipant(ctx context.Context, part *model.Participant) error {
-	query := `INSERT INTO participants (conversation_id, user_id, joined_at) VALUES ($1, $2, $3)`
-	_, err := r.db.ExecContext(ctx, query, part.ConversationID, part.UserID, part.JoinedAt)
+	if part.Role == "" {
+		part.Role = model.RoleMember
+	}
+	query := `INSERT INTO participants (conversation_id, user_id, role, joined_at) VALUES ($1, $2, $3, $4)`
+	_, err := r.db.ExecContext(ctx, query, part.ConversationID, part.UserID, part.Role, part.JoinedAt)
 	return err
 }
 
+func (r *PostgresRepository) RemoveParticipant(ctx context.Context, convID, userID int64) error {
+	query := `DELETE FROM participants WHERE conversation_id = $1 AND user_id = $2`
+	result, err := r.db.ExecContext(ctx, query, convID, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("participant not found")
+	}
+	return nil
+}
+
+// SaveMessage inserts msg and sets its ID. A message repeating the sender's
+// client_msg_id in the conversation is not inserted and ErrDuplicateMessage
+// is returned instead.
 func (r *PostgresRepository) SaveMessage(ctx context.Context, msg *model.Message) error {
 	query := `
-		INSERT INTO messages (conversation_id, sender_id, content, message_type, file_url, file_name, file_size, mime_type, created_at) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) 
+		INSERT INTO messages (conversation_id, sender_id, content, message_type, file_url, file_name, file_size, mime_type, created_at, reply_to_message_id, thread_root_id,
+		                      forwarded_from_message_id, forwarded_from_sender_id, forwarded_from_conversation_id, client_msg_id,
+		                      image_width, image_height, blurhash, thumbnails, file_bucket, file_object, file_status) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22) 
+		ON CONFLICT (conversation_id, sender_id, client_msg_id) WHERE client_msg_id IS NOT NULL DO NOTHING
 		RETURNING id
 	`
-	return r.db.QueryRowContext(ctx, query,
+	err := r.db.QueryRowContext(ctx, query,
 		msg.ConversationID,
 		msg.SenderID,
 		msg.Content,
@@ -45,33 +102,295 @@ func (r *PostgresRepository) SaveMessage(ctx context.Context, msg *model.Message
 		msg.FileSize,
 		msg.MimeType,
 		msg.CreatedAt,
+		msg.ReplyToMessageID,
+		msg.ThreadRootID,
+		msg.ForwardedFromMessageID,
+		msg.ForwardedFromSenderID,
+		msg.ForwardedFromConversationID,
+		msg.ClientMsgID,
+		msg.ImageWidth,
+		msg.ImageHeight,
+		msg.Blurhash,
+		msg.Thumbnails,
+		msg.FileBucket,
+		msg.FileObject,
+		msg.FileStatus,
 	).Scan(&msg.ID)
+	if err == sql.ErrNoRows {
+		return ports.ErrDuplicateMessage
+	}
+	return err
 }
 
-func (r *PostgresRepository) GetMessages(ctx context.Context, conversationID int64, limit, offset int) ([]model.Message, error) {
+// GetMessages returns up to limit messages of the main timeline newest first.
+// With afterID set it returns the messages immediately following that ID,
+// otherwise the ones immediately preceding beforeID (or the latest ones when
+// beforeID is 0). Thread replies are only listed by GetThreadMessages.
+// Messages held back for a file scan are only listed for their sender, the
+// viewer given by userID.
+func (r *PostgresRepository) GetMessages(ctx context.Context, conversationID, userID, beforeID, afterID int64, limit int) ([]model.Message, error) {
+	return r.selectMessagePage(ctx, "conversation_id = $1 AND thread_root_id IS NULL", conversationID, userID, beforeID, afterID, limit)
+}
+
+// GetThreadMessages pages through the replies of a thread like GetMessages.
+func (r *PostgresRepository) GetThreadMessages(ctx context.Context, rootMessageID, userID, beforeID, afterID int64, limit int) ([]model.Message, error) {
+	return r.selectMessagePage(ctx, "thread_root_id = $1", rootMessageID, userID, beforeID, afterID, limit)
+}
+
+// visibleTo matches the messages the user bound to param may read: a message
+// whose file is still pending a scan is held back from everyone but its
+// sender until the scan passes.
+func visibleTo(param string) string {
+	return "(file_status IS DISTINCT FROM 'pending' OR sender_id = " + param + ")"
+}
+
+// selectMessagePage runs a keyset query over the messages matching filter,
+// which must reference its single argument as $1, and visible to userID.
+func (r *PostgresRepository) selectMessagePage(ctx context.Context, filter string, filterArg, userID, beforeID, afterID int64, limit int) ([]model.Message, error) {
 	var messages []model.Message
-	query := `
-		SELECT id, conversation_id, sender_id, content, message_type, 
-		       file_url, file_name, file_size, mime_type, 
-		       created_at, edited_at, deleted_at
-		FROM messages 
-		WHERE conversation_id = $1 AND deleted_at IS NULL
-		ORDER BY created_at DESC 
-		LIMIT $2 OFFSET $3
-	`
-	err := r.db.SelectContext(ctx, &messages, query, conversationID, limit, offset)
+	var err error
+
+	switch {
+	case afterID > 0:
+		query := `
+			SELECT ` + messageColumns + `
+			FROM messages
+			WHERE ` + filter + ` AND deleted_at IS NULL AND ` + visibleTo("$2") + ` AND id > $3
+			ORDER BY id ASC
+			LIMIT $4
+		`
+		err = r.db.SelectContext(ctx, &messages, query, filterArg, userID, afterID, limit)
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	case beforeID > 0:
+		query := `
+			SELECT ` + messageColumns + `
+			FROM messages
+			WHERE ` + filter + ` AND deleted_at IS NULL AND ` + visibleTo("$2") + ` AND id < $3
+			ORDER BY id DESC
+			LIMIT $4
+		`
+		err = r.db.SelectContext(ctx, &messages, query, filterArg, userID, beforeID, limit)
+	default:
+		query := `
+			SELECT ` + messageColumns + `
+			FROM messages
+			WHERE ` + filter + ` AND deleted_at IS NULL AND ` + visibleTo("$2") + `
+			ORDER BY id DESC
+			LIMIT $3
+		`
+		err = r.db.SelectContext(ctx, &messages, query, filterArg, userID, limit)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	for i := range messages {
-		messages[i].ReadBy, _ = r.GetMessageReads(ctx, messages[i].ID)
-		messages[i].Reactions, _ = r.GetMessageReactions(ctx, messages[i].ID)
+	if err := r.attachMessageDetails(ctx, messages); err != nil {
+		return nil, err
 	}
 
 	return messages, nil
 }
 
+// attachMessageDetails fills delivery and read receipts, reactions, mentions, quoted
+// previews and thread statistics for a page of messages with one query per relation
+// instead of one per message.
+func (r *PostgresRepository) attachMessageDetails(ctx context.Context, messages []model.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+	}
+
+	reads, err := r.getMessageReadsByIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := r.getMessageDeliveriesByIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	reactions, err := r.getMessageReactionsByIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	threads, err := r.getThreadStatsByRootIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	mentions, err := r.getMessageMentionsByIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	var replyIDs []int64
+	for i := range messages {
+		if messages[i].ReplyToMessageID != nil {
+			replyIDs = append(replyIDs, *messages[i].ReplyToMessageID)
+		}
+	}
+
+	previews, err := r.getMessagePreviewsByIDs(ctx, replyIDs)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].DeliveredTo = deliveries[messages[i].ID]
+		messages[i].ReadBy = reads[messages[i].ID]
+		messages[i].Reactions = reactions[messages[i].ID]
+		messages[i].Mentions = mentions[messages[i].ID]
+		if messages[i].ReplyToMessageID != nil {
+			messages[i].ReplyTo = previews[*messages[i].ReplyToMessageID]
+		}
+		if stats, ok := threads[messages[i].ID]; ok {
+			messages[i].ReplyCount = stats.ReplyCount
+			messages[i].LastReplyAt = &stats.LastReplyAt
+		}
+	}
+
+	return nil
+}
+
+type threadStats struct {
+	RootMessageID int64     `db:"thread_root_id"`
+	ReplyCount    int       `db:"reply_count"`
+	LastReplyAt   time.Time `db:"last_reply_at"`
+}
+
+func (r *PostgresRepository) getThreadStatsByRootIDs(ctx context.Context, rootIDs []int64) (map[int64]threadStats, error) {
+	var rows []threadStats
+	query := `
+		SELECT thread_root_id, COUNT(*) AS reply_count, MAX(created_at) AS last_reply_at
+		FROM messages
+		WHERE thread_root_id = ANY($1) AND deleted_at IS NULL
+		GROUP BY thread_root_id
+	`
+	if err := r.db.SelectContext(ctx, &rows, query, pq.Array(rootIDs)); err != nil {
+		return nil, err
+	}
+
+	stats := make(map[int64]threadStats, len(rows))
+	for _, row := range rows {
+		stats[row.RootMessageID] = row
+	}
+	return stats, nil
+}
+
+func (r *PostgresRepository) getMessagePreviewsByIDs(ctx context.Context, messageIDs []int64) (map[int64]*model.MessagePreview, error) {
+	previews := make(map[int64]*model.MessagePreview, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return previews, nil
+	}
+
+	var rows []model.Message
+	query := `
+		SELECT id, conversation_id, sender_id, content, message_type, created_at, deleted_at
+		FROM messages
+		WHERE id = ANY($1)
+	`
+	if err := r.db.SelectContext(ctx, &rows, query, pq.Array(messageIDs)); err != nil {
+		return nil, err
+	}
+
+	for i := range rows {
+		previews[rows[i].ID] = rows[i].Preview()
+	}
+	return previews, nil
+}
+
+// getMessageReadsByIDs derives who read each message from read cursors: the
+// participants' conversation cursors for the main timeline and their thread
+// cursors for thread replies.
+func (r *PostgresRepository) getMessageReadsByIDs(ctx context.Context, messageIDs []int64) (map[int64][]int64, error) {
+	var rows []struct {
+		MessageID int64 `db:"message_id"`
+		UserID    int64 `db:"user_id"`
+	}
+	query := `
+		SELECT m.id AS message_id, p.user_id
+		FROM messages m
+		JOIN participants p ON p.conversation_id = m.conversation_id
+		WHERE m.id = ANY($1)
+		  AND p.user_id <> m.sender_id
+		  AND m.id <= CASE
+			WHEN m.thread_root_id IS NULL THEN p.last_read_message_id
+			ELSE COALESCE((
+				SELECT tr.last_read_message_id FROM thread_reads tr
+				WHERE tr.root_message_id = m.thread_root_id AND tr.user_id = p.user_id
+			), 0)
+		  END
+		ORDER BY m.id, p.user_id
+	`
+	if err := r.db.SelectContext(ctx, &rows, query, pq.Array(messageIDs)); err != nil {
+		return nil, err
+	}
+
+	reads := make(map[int64][]int64, len(messageIDs))
+	for _, row := range rows {
+		reads[row.MessageID] = append(reads[row.MessageID], row.UserID)
+	}
+	return reads, nil
+}
+
+func (r *PostgresRepository) getMessageDeliveriesByIDs(ctx context.Context, messageIDs []int64) (map[int64][]int64, error) {
+	var rows []model.MessageDelivery
+	query := `SELECT message_id, user_id, delivered_at FROM message_deliveries WHERE message_id = ANY($1)`
+	if err := r.db.SelectContext(ctx, &rows, query, pq.Array(messageIDs)); err != nil {
+		return nil, err
+	}
+
+	deliveries := make(map[int64][]int64, len(messageIDs))
+	for _, row := range rows {
+		deliveries[row.MessageID] = append(deliveries[row.MessageID], row.UserID)
+	}
+	return deliveries, nil
+}
+
+func (r *PostgresRepository) getMessageMentionsByIDs(ctx context.Context, messageIDs []int64) (map[int64][]int64, error) {
+	var rows []struct {
+		MessageID int64 `db:"message_id"`
+		UserID    int64 `db:"user_id"`
+	}
+	query := `SELECT message_id, user_id FROM message_mentions WHERE message_id = ANY($1) ORDER BY user_id`
+	if err := r.db.SelectContext(ctx, &rows, query, pq.Array(messageIDs)); err != nil {
+		return nil, err
+	}
+
+	mentions := make(map[int64][]int64, len(messageIDs))
+	for _, row := range rows {
+		mentions[row.MessageID] = append(mentions[row.MessageID], row.UserID)
+	}
+	return mentions, nil
+}
+
+func (r *PostgresRepository) getMessageReactionsByIDs(ctx context.Context, messageIDs []int64) (map[int64][]model.Reaction, error) {
+	var rows []model.Reaction
+	query := `
+		SELECT id, message_id, user_id, reaction, created_at
+		FROM message_reactions
+		WHERE message_id = ANY($1)
+		ORDER BY id
+	`
+	if err := r.db.SelectContext(ctx, &rows, query, pq.Array(messageIDs)); err != nil {
+		return nil, err
+	}
+
+	reactions := make(map[int64][]model.Reaction, len(messageIDs))
+	for _, row := range rows {
+		reactions[row.MessageID] = append(reactions[row.MessageID], row)
+	}
+	return reactions, nil
+}
+
 func (r *PostgresRepository) GetConversationByID(ctx context.Context, id int64) (*model.Conversation, error) {
 	var conv model.Conversation
 	query := `SELECT * FROM conversations WHERE id = $1`
@@ -111,9 +430,123 @@ func (r *PostgresRepository) IsParticipant(ctx context.Context, convID, userID i
 	return exists, err
 }
 
+func (r *PostgresRepository) GetParticipant(ctx context.Context, convID, userID int64) (*model.Participant, error) {
+	var part model.Participant
+	query := `SELECT conversation_id, user_id, role, joined_at, last_read_message_id FROM participants WHERE conversation_id = $1 AND user_id = $2`
+	err := r.db.GetContext(ctx, &part, query, convID, userID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &part, nil
+}
+
+func (r *PostgresRepository) ListParticipants(ctx context.Context, convID int64) ([]model.Participant, error) {
+	var parts []model.Participant
+	query := `
+		SELECT conversation_id, user_id, role, joined_at, last_read_message_id
+		FROM participants
+		WHERE conversation_id = $1
+		ORDER BY joined_at, user_id
+	`
+	err := r.db.SelectContext(ctx, &parts, query, convID)
+	return parts, err
+}
+
+func (r *PostgresRepository) UpdateParticipantRole(ctx context.Context, convID, userID int64, role string) error {
+	query := `UPDATE participants SET role = $3 WHERE conversation_id = $1 AND user_id = $2`
+	result, err := r.db.ExecContext(ctx, query, convID, userID, role)
+	if err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("participant not found")
+	}
+	return nil
+}
+
+// TransferOwnership makes newOwnerID the owner of the group and demotes
+// ownerID to admin in one transaction. The conversation row is locked, so
+// ownership changes of a group happen one at a time and it never ends up
+// with two owners.
+func (r *PostgresRepository) TransferOwnership(ctx context.Context, convID, ownerID, newOwnerID int64) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT id FROM conversations WHERE id = $1 FOR UPDATE`, convID); err != nil {
+		return err
+	}
+
+	var role string
+	err = tx.GetContext(ctx, &role, `SELECT role FROM participants WHERE conversation_id = $1 AND user_id = $2 FOR UPDATE`, convID, ownerID)
+	if err == sql.ErrNoRows || (err == nil && role != model.RoleOwner) {
+		return fmt.Errorf("user is no longer the group owner")
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE participants SET role = $3 WHERE conversation_id = $1 AND user_id = $2`, convID, newOwnerID, model.RoleOwner)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("participant not found")
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE participants SET role = $3 WHERE conversation_id = $1 AND user_id = $2`, convID, ownerID, model.RoleAdmin); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ClaimOwnership makes userID the owner of a group that has none left. It
+// reports false when the group has an owner already, e.g. because ownership
+// was handed over while the previous owner was leaving.
+func (r *PostgresRepository) ClaimOwnership(ctx context.Context, convID, userID int64) (bool, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT id FROM conversations WHERE id = $1 FOR UPDATE`, convID); err != nil {
+		return false, err
+	}
+
+	var owners int
+	if err := tx.GetContext(ctx, &owners, `SELECT COUNT(*) FROM participants WHERE conversation_id = $1 AND role = $2`, convID, model.RoleOwner); err != nil {
+		return false, err
+	}
+	if owners > 0 {
+		return false, nil
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE participants SET role = $3 WHERE conversation_id = $1 AND user_id = $2`, convID, userID, model.RoleOwner)
+	if err != nil {
+		return false, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return false, fmt.Errorf("participant not found")
+	}
+
+	return true, tx.Commit()
+}
+
+// GetUserConversations loads the page in a single round trip: the last
+// message comes from a lateral join and participant IDs from an array
+// subquery, so the cost does not grow with the number of conversations.
 func (r *PostgresRepository) GetUserConversations(ctx context.Context, userID int64, limit, offset int) ([]model.ConversationWithLastMessage, error) {
 	query := `
-		SELECT DISTINCT
+		SELECT
 			c.id,
 			c.is_group,
 			c.name,
@@ -121,14 +554,67 @@ func (r *PostgresRepository) GetUserConversations(ctx context.Context, userID in
 			(
 				SELECT COUNT(*) 
 				FROM messages m
-				LEFT JOIN message_reads mr ON m.id = mr.message_id AND mr.user_id = $1
 				WHERE m.conversation_id = c.id 
+				AND m.id > p.last_read_message_id
 				AND m.sender_id != $1
 				AND m.deleted_at IS NULL
-				AND mr.message_id IS NULL
-			) as unread_count
+				AND m.thread_root_id IS NULL
+				AND (m.file_status IS NULL OR m.file_status <> 'pending')
+			) as unread_count,
+			(
+				SELECT COUNT(*)
+				FROM message_mentions mm
+				JOIN messages m ON m.id = mm.message_id
+				WHERE mm.user_id = $1
+				AND m.conversation_id = c.id
+				AND m.sender_id != $1
+				AND m.deleted_at IS NULL
+				AND (m.file_status IS NULL OR m.file_status <> 'pending')
+				AND m.id > CASE
+					WHEN m.thread_root_id IS NULL THEN p.last_read_message_id
+					ELSE COALESCE((
+						SELECT tr.last_read_message_id FROM thread_reads tr
+						WHERE tr.root_message_id = m.thread_root_id AND tr.user_id = $1
+					), 0)
+				END
+			) as unread_mentions,
+			ARRAY(
+				SELECT p2.user_id FROM participants p2
+				WHERE p2.conversation_id = c.id
+				ORDER BY p2.user_id
+			) as participant_ids,
+			lm.id, lm.conversation_id, lm.sender_id, lm.content, lm.message_type,
+			lm.file_url, lm.file_name, lm.file_size, lm.mime_type,
+			lm.created_at, lm.edited_at, lm.deleted_at,
+			lm.file_bucket, lm.file_object, lm.file_status,
+			pm.id, pm.conversation_id, pm.sender_id, pm.content, pm.message_type,
+			pm.file_url, pm.file_name, pm.file_size, pm.mime_type,
+			pm.created_at, pm.edited_at, pm.deleted_at,
+			pm.file_bucket, pm.file_object, pm.file_status
 		FROM conversations c
 		JOIN participants p ON c.id = p.conversation_id
+		LEFT JOIN LATERAL (
+			SELECT id, conversation_id, sender_id, content, message_type,
+			       ` + scannedFileURL + `, file_name, file_size, mime_type,
+			       created_at, edited_at, deleted_at,
+			       file_bucket, file_object, file_status
+			FROM messages
+			WHERE conversation_id = c.id AND deleted_at IS NULL AND thread_root_id IS NULL
+			  AND ` + visibleTo("$1") + `
+			ORDER BY id DESC
+			LIMIT 1
+		) lm ON true
+		LEFT JOIN LATERAL (
+			SELECT m.id, m.conversation_id, m.sender_id, m.content, m.message_type,
+			       ` + scannedFileURL + `, m.file_name, m.file_size, m.mime_type,
+			       m.created_at, m.edited_at, m.deleted_at,
+			       m.file_bucket, m.file_object, m.file_status
+			FROM pinned_messages pin
+			JOIN messages m ON m.id = pin.message_id
+			WHERE pin.conversation_id = c.id AND m.deleted_at IS NULL
+			ORDER BY pin.pinned_at DESC
+			LIMIT 1
+		) pm ON true
 		WHERE p.user_id = $1
 		ORDER BY c.created_at DESC
 		LIMIT $2 OFFSET $3
@@ -143,30 +629,83 @@ func (r *PostgresRepository) GetUserConversations(ctx context.Context, userID in
 	var conversations []model.ConversationWithLastMessage
 	for rows.Next() {
 		var conv model.ConversationWithLastMessage
-		err := rows.Scan(&conv.ID, &conv.IsGroup, &conv.Name, &conv.CreatedAt, &conv.UnreadCount)
+		var last, pinned nullableMessage
+		err := rows.Scan(
+			&conv.ID, &conv.IsGroup, &conv.Name, &conv.CreatedAt, &conv.UnreadCount, &conv.UnreadMentions,
+			pq.Array(&conv.ParticipantIDs),
+			&last.ID, &last.ConversationID, &last.SenderID, &last.Content, &last.MessageType,
+			&last.FileURL, &last.FileName, &last.FileSize, &last.MimeType,
+			&last.CreatedAt, &last.EditedAt, &last.DeletedAt,
+			&last.FileBucket, &last.FileObject, &last.FileStatus,
+			&pinned.ID, &pinned.ConversationID, &pinned.SenderID, &pinned.Content, &pinned.MessageType,
+			&pinned.FileURL, &pinned.FileName, &pinned.FileSize, &pinned.MimeType,
+			&pinned.CreatedAt, &pinned.EditedAt, &pinned.DeletedAt,
+			&pinned.FileBucket, &pinned.FileObject, &pinned.FileStatus,
+		)
 		if err != nil {
 			return nil, err
 		}
 
-		lastMsg, _ := r.GetLastMessage(ctx, conv.ID)
-		conv.LastMessage = lastMsg
-
-		conv.ParticipantIDs, _ = r.GetParticipants(ctx, conv.ID)
+		conv.LastMessage = last.toMessage()
+		conv.PinnedMessage = pinned.toMessage()
 
 		conversations = append(conversations, conv)
 	}
 
-	return conversations, nil
+	return conversations, rows.Err()
+}
+
+// nullableMessage scans message columns produced by an outer join, where
+// every column is NULL when there is no matching message.
+type nullableMessage struct {
+	ID             sql.NullInt64
+	ConversationID sql.NullInt64
+	SenderID       sql.NullInt64
+	Content        sql.NullString
+	MessageType    sql.NullString
+	FileURL        *string
+	FileName       *string
+	FileSize       *int64
+	MimeType       *string
+	CreatedAt      sql.NullTime
+	EditedAt       *time.Time
+	DeletedAt      *time.Time
+	FileBucket     *string
+	FileObject     *string
+	FileStatus     *string
+}
+
+func (n nullableMessage) toMessage() *model.Message {
+	if !n.ID.Valid {
+		return nil
+	}
+	return &model.Message{
+		ID:             n.ID.Int64,
+		ConversationID: n.ConversationID.Int64,
+		SenderID:       n.SenderID.Int64,
+		Content:        n.Content.String,
+		MessageType:    n.MessageType.String,
+		FileURL:        n.FileURL,
+		FileName:       n.FileName,
+		FileSize:       n.FileSize,
+		MimeType:       n.MimeType,
+		CreatedAt:      n.CreatedAt.Time,
+		EditedAt:       n.EditedAt,
+		DeletedAt:      n.DeletedAt,
+		FileRef: model.FileRef{
+			FileBucket: n.FileBucket,
+			FileObject: n.FileObject,
+			FileStatus: n.FileStatus,
+		},
+	}
 }
 
 func (r *PostgresRepository) GetLastMessage(ctx context.Context, conversationID int64) (*model.Message, error) {
 	var msg model.Message
 	query := `
-		SELECT id, conversation_id, sender_id, content, message_type,
-		       file_url, file_name, file_size, mime_type,
-		       created_at, edited_at, deleted_at
+		SELECT ` + messageColumns + `
 		FROM messages
-		WHERE conversation_id = $1 AND deleted_at IS NULL
+		WHERE conversation_id = $1 AND deleted_at IS NULL AND thread_root_id IS NULL
 		ORDER BY created_at DESC
 		LIMIT 1
 	`
@@ -180,21 +719,152 @@ func (r *PostgresRepository) GetLastMessage(ctx context.Context, conversationID
 	return &msg, nil
 }
 
-func (r *PostgresRepository) MarkMessageAsRead(ctx context.Context, messageID, userID int64) error {
+// MarkReadUpTo moves the user's read cursor in the conversation forward to
+// messageID. It reports false when the cursor already was there or beyond.
+func (r *PostgresRepository) MarkReadUpTo(ctx context.Context, conversationID, userID, messageID int64) (bool, error) {
 	query := `
-		INSERT INTO message_reads (message_id, user_id, read_at)
-		VALUES ($1, $2, NOW())
-		ON CONFLICT (message_id, user_id) DO NOTHING
+		UPDATE participants
+		SET last_read_message_id = $3
+		WHERE conversation_id = $1 AND user_id = $2 AND last_read_message_id < $3
 	`
-	_, err := r.db.ExecContext(ctx, query, messageID, userID)
-	return err
+	result, err := r.db.ExecContext(ctx, query, conversationID, userID, messageID)
+	if err != nil {
+		return false, err
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
 }
 
 func (r *PostgresRepository) GetMessageReads(ctx context.Context, messageID int64) ([]int64, error) {
-	var userIDs []int64
-	query := `SELECT user_id FROM message_reads WHERE message_id = $1`
-	err := r.db.SelectContext(ctx, &userIDs, query, messageID)
-	return userIDs, err
+	reads, err := r.getMessageReadsByIDs(ctx, []int64{messageID})
+	if err != nil {
+		return nil, err
+	}
+	return reads[messageID], nil
+}
+
+// MarkMessagesDelivered records delivery of the given messages to userID,
+// skipping their own messages and messages of conversations they are not in.
+// Only deliveries not recorded before are returned.
+func (r *PostgresRepository) MarkMessagesDelivered(ctx context.Context, userID int64, messageIDs []int64) ([]model.MessageDelivery, error) {
+	var deliveries []model.MessageDelivery
+	query := `
+		WITH delivered AS (
+			INSERT INTO message_deliveries (message_id, user_id, delivered_at)
+			SELECT m.id, $1, NOW()
+			FROM messages m
+			JOIN participants p ON p.conversation_id = m.conversation_id AND p.user_id = $1
+			WHERE m.id = ANY($2) AND m.sender_id <> $1
+			ON CONFLICT (message_id, user_id) DO NOTHING
+			RETURNING message_id, user_id, delivered_at
+		)
+		SELECT d.message_id, d.user_id, d.delivered_at, m.sender_id
+		FROM delivered d
+		JOIN messages m ON m.id = d.message_id
+	`
+	err := r.db.SelectContext(ctx, &deliveries, query, userID, pq.Array(messageIDs))
+	return deliveries, err
+}
+
+// GetUndeliveredMessages returns the oldest messages sent to userID since the
+// given time that they have neither acknowledged nor read. Messages whose
+// file is still being scanned were never delivered and are left out.
+func (r *PostgresRepository) GetUndeliveredMessages(ctx context.Context, userID int64, since time.Time, limit int) ([]model.Message, error) {
+	var messages []model.Message
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE sender_id <> $1 AND deleted_at IS NULL AND created_at > $2
+		  AND (file_status IS NULL OR file_status <> 'pending')
+		  AND EXISTS (
+			SELECT 1 FROM participants p
+			WHERE p.conversation_id = messages.conversation_id AND p.user_id = $1 AND p.joined_at <= messages.created_at
+			  AND (messages.thread_root_id IS NOT NULL OR messages.id > p.last_read_message_id)
+		  )
+		  AND NOT EXISTS (SELECT 1 FROM message_deliveries d WHERE d.message_id = messages.id AND d.user_id = $1)
+		ORDER BY id ASC
+		LIMIT $3
+	`
+	if err := r.db.SelectContext(ctx, &messages, query, userID, since, limit); err != nil {
+		return nil, err
+	}
+
+	if err := r.attachMessageDetails(ctx, messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (r *PostgresRepository) JoinThread(ctx context.Context, rootMessageID, userID int64) error {
+	query := `
+		INSERT INTO thread_reads (root_message_id, user_id, last_read_message_id, updated_at)
+		VALUES ($1, $2, 0, NOW())
+		ON CONFLICT (root_message_id, user_id) DO NOTHING
+	`
+	_, err := r.db.ExecContext(ctx, query, rootMessageID, userID)
+	return err
+}
+
+func (r *PostgresRepository) MarkThreadRead(ctx context.Context, rootMessageID, userID, messageID int64) error {
+	query := `
+		INSERT INTO thread_reads (root_message_id, user_id, last_read_message_id, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (root_message_id, user_id) DO UPDATE
+		SET last_read_message_id = GREATEST(thread_reads.last_read_message_id, EXCLUDED.last_read_message_id),
+		    updated_at = NOW()
+	`
+	_, err := r.db.ExecContext(ctx, query, rootMessageID, userID, messageID)
+	return err
+}
+
+// GetUserThreads lists the threads a user took part in, most recently active
+// first, optionally restricted to one conversation.
+func (r *PostgresRepository) GetUserThreads(ctx context.Context, userID, conversationID int64, limit int) ([]model.ThreadSummary, error) {
+	var threads []model.ThreadSummary
+	query := `
+		SELECT
+			tr.root_message_id,
+			root.conversation_id,
+			COUNT(m.id) AS reply_count,
+			MAX(m.created_at) AS last_reply_at,
+			COUNT(m.id) FILTER (WHERE m.id > tr.last_read_message_id AND m.sender_id != $1) AS unread_count
+		FROM thread_reads tr
+		JOIN messages root ON root.id = tr.root_message_id AND root.deleted_at IS NULL
+		JOIN participants p ON p.conversation_id = root.conversation_id AND p.user_id = $1
+		LEFT JOIN messages m ON m.thread_root_id = tr.root_message_id AND m.deleted_at IS NULL
+		WHERE tr.user_id = $1 AND ($2::bigint = 0 OR root.conversation_id = $2)
+		GROUP BY tr.root_message_id, root.conversation_id, tr.last_read_message_id
+		ORDER BY last_reply_at DESC NULLS LAST
+		LIMIT $3
+	`
+	if err := r.db.SelectContext(ctx, &threads, query, userID, conversationID, limit); err != nil {
+		return nil, err
+	}
+	if len(threads) == 0 {
+		return threads, nil
+	}
+
+	rootIDs := make([]int64, len(threads))
+	for i := range threads {
+		rootIDs[i] = threads[i].RootMessageID
+	}
+
+	var roots []model.Message
+	rootsQuery := `SELECT ` + messageColumns + ` FROM messages WHERE id = ANY($1)`
+	if err := r.db.SelectContext(ctx, &roots, rootsQuery, pq.Array(rootIDs)); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]*model.Message, len(roots))
+	for i := range roots {
+		byID[roots[i].ID] = &roots[i]
+	}
+	for i := range threads {
+		threads[i].Root = byID[threads[i].RootMessageID]
+	}
+
+	return threads, nil
 }
 
 func (r *PostgresRepository) AddReaction(ctx context.Context, messageID, userID int64, reaction string) error {
@@ -220,6 +890,163 @@ func (r *PostgresRepository) GetMessageReactions(ctx context.Context, messageID
 	return reactions, err
 }
 
+// SetMessageMentions replaces the users mentioned by a message in a single
+// statement, so an edit never leaves a partially updated set behind.
+func (r *PostgresRepository) SetMessageMentions(ctx context.Context, messageID int64, userIDs []int64) error {
+	query := `
+		WITH removed AS (
+			DELETE FROM message_mentions
+			WHERE message_id = $1 AND NOT (user_id = ANY($2))
+		)
+		INSERT INTO message_mentions (message_id, user_id)
+		SELECT $1, unnest($2::bigint[])
+		ON CONFLICT (message_id, user_id) DO NOTHING
+	`
+	_, err := r.db.ExecContext(ctx, query, messageID, pq.Array(userIDs))
+	return err
+}
+
+func (r *PostgresRepository) PinMessage(ctx context.Context, pin *model.PinnedMessage) error {
+	query := `
+		INSERT INTO pinned_messages (conversation_id, message_id, pinned_by, pinned_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (conversation_id, message_id) DO NOTHING
+	`
+	_, err := r.db.ExecContext(ctx, query, pin.ConversationID, pin.MessageID, pin.PinnedBy, pin.PinnedAt)
+	return err
+}
+
+func (r *PostgresRepository) UnpinMessage(ctx context.Context, conversationID, messageID int64) error {
+	query := `DELETE FROM pinned_messages WHERE conversation_id = $1 AND message_id = $2`
+	result, err := r.db.ExecContext(ctx, query, conversationID, messageID)
+	if err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("message is not pinned")
+	}
+	return nil
+}
+
+// GetPinnedMessages returns the pins of a conversation newest first, each with
+// its full message. Pins of deleted messages are skipped.
+func (r *PostgresRepository) GetPinnedMessages(ctx context.Context, conversationID int64) ([]model.PinnedMessage, error) {
+	var pins []model.PinnedMessage
+	query := `
+		SELECT conversation_id, message_id, pinned_by, pinned_at
+		FROM pinned_messages
+		WHERE conversation_id = $1
+		ORDER BY pinned_at DESC
+	`
+	if err := r.db.SelectContext(ctx, &pins, query, conversationID); err != nil {
+		return nil, err
+	}
+	if len(pins) == 0 {
+		return pins, nil
+	}
+
+	ids := make([]int64, len(pins))
+	for i, pin := range pins {
+		ids[i] = pin.MessageID
+	}
+
+	var messages []model.Message
+	messagesQuery := `SELECT ` + messageColumns + ` FROM messages WHERE id = ANY($1) AND deleted_at IS NULL`
+	if err := r.db.SelectContext(ctx, &messages, messagesQuery, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+	if err := r.attachMessageDetails(ctx, messages); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]*model.Message, len(messages))
+	for i := range messages {
+		byID[messages[i].ID] = &messages[i]
+	}
+
+	result := make([]model.PinnedMessage, 0, len(pins))
+	for _, pin := range pins {
+		if msg, ok := byID[pin.MessageID]; ok {
+			pin.Message = msg
+			result = append(result, pin)
+		}
+	}
+	return result, nil
+}
+
+// searchHeadlineOptions marks matched terms in search snippets.
+const searchHeadlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxWords=20, MinWords=5, MaxFragments=2"
+
+// escapedContent is the message content HTML-escaped, so that the <mark> tags
+// ts_headline adds are the only markup in a snippet.
+const escapedContent = `replace(replace(replace(replace(content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;')`
+
+// SearchMessages returns up to limit non-deleted messages matching the search,
+// newest first, from the conversations userID participates in. The generated
+// search_vector column is recomputed by Postgres whenever content changes, so
+// edited messages are found by their new text only.
+func (r *PostgresRepository) SearchMessages(ctx context.Context, userID int64, search model.MessageSearch, limit int) ([]model.MessageSearchResult, error) {
+	args := []interface{}{userID, search.Query}
+	conditions := []string{
+		"search_vector @@ q",
+		"deleted_at IS NULL",
+		"conversation_id IN (SELECT conversation_id FROM participants WHERE user_id = $1)",
+		visibleTo("$1"),
+	}
+	addCondition := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if search.ConversationID > 0 {
+		addCondition("conversation_id = $%d", search.ConversationID)
+	}
+	if search.SenderID > 0 {
+		addCondition("sender_id = $%d", search.SenderID)
+	}
+	if search.MessageType != "" {
+		addCondition("message_type = $%d", search.MessageType)
+	}
+	if search.From != nil {
+		addCondition("created_at >= $%d", *search.From)
+	}
+	if search.To != nil {
+		addCondition("created_at < $%d", *search.To)
+	}
+	if search.BeforeID > 0 {
+		addCondition("id < $%d", search.BeforeID)
+	}
+	args = append(args, limit)
+
+	query := `
+		SELECT ` + messageColumns + `,
+		       ts_headline('simple', ` + escapedContent + `, q, '` + searchHeadlineOptions + `') AS snippet
+		FROM messages, websearch_to_tsquery('simple', $2) q
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY id DESC
+		LIMIT $` + strconv.Itoa(len(args))
+
+	var results []model.MessageSearchResult
+	if err := r.db.SelectContext(ctx, &results, query, args...); err != nil {
+		return nil, err
+	}
+
+	messages := make([]model.Message, len(results))
+	for i := range results {
+		messages[i] = results[i].Message
+	}
+	if err := r.attachMessageDetails(ctx, messages); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Message = messages[i]
+	}
+
+	return results, nil
+}
+
 func (r *PostgresRepository) EditMessage(ctx context.Context, messageID int64, newContent string) error {
 	query := `
 		UPDATE messages 
@@ -255,9 +1082,7 @@ func (r *PostgresRepository) DeleteMessage(ctx context.Context, messageID int64)
 func (r *PostgresRepository) GetMessageByID(ctx context.Context, messageID int64) (*model.Message, error) {
 	var msg model.Message
 	query := `
-		SELECT id, conversation_id, sender_id, content, message_type,
-		       file_url, file_name, file_size, mime_type,
-		       created_at, edited_at, deleted_at
+		SELECT ` + messageColumns + `
 		FROM messages
 		WHERE id = $1
 	`
@@ -266,8 +1091,292 @@ func (r *PostgresRepository) GetMessageByID(ctx context.Context, messageID int64
 		return nil, err
 	}
 
-	msg.ReadBy, _ = r.GetMessageReads(ctx, msg.ID)
-	msg.Reactions, _ = r.GetMessageReactions(ctx, msg.ID)
+	messages := []model.Message{msg}
+	if err := r.attachMessageDetails(ctx, messages); err != nil {
+		return nil, err
+	}
 
-	return &msg, nil
+	return &messages[0], nil
+}
+
+// GetMessageByClientID returns the sender's message carrying clientMsgID in
+// the conversation, or nil if there is none.
+func (r *PostgresRepository) GetMessageByClientID(ctx context.Context, conversationID, senderID int64, clientMsgID string) (*model.Message, error) {
+	var msg model.Message
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE conversation_id = $1 AND sender_id = $2 AND client_msg_id = $3
+	`
+	err := r.db.GetContext(ctx, &msg, query, conversationID, senderID, clientMsgID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []model.Message{msg}
+	if err := r.attachMessageDetails(ctx, messages); err != nil {
+		return nil, err
+	}
+
+	return &messages[0], nil
+}
+
+// GetContacts returns everyone who shares at least one conversation with
+// userID.
+func (r *PostgresRepository) GetContacts(ctx context.Context, userID int64) ([]int64, error) {
+	var contacts []int64
+	query := `
+		SELECT DISTINCT p2.user_id
+		FROM participants p1
+		JOIN participants p2 ON p1.conversation_id = p2.conversation_id
+		WHERE p1.user_id = $1 AND p2.user_id != $1
+	`
+	err := r.db.SelectContext(ctx, &contacts, query, userID)
+	return contacts, err
+}
+
+// GetPresenceSettings returns the stored settings of the given users. Users
+// without a row get the default visibility and no last seen time.
+func (r *PostgresRepository) GetPresenceSettings(ctx context.Context, userIDs []int64) (map[int64]model.PresenceSettings, error) {
+	var rows []model.PresenceSettings
+	query := `SELECT user_id, visibility, last_seen_at FROM user_presence WHERE user_id = ANY($1)`
+	if err := r.db.SelectContext(ctx, &rows, query, pq.Array(userIDs)); err != nil {
+		return nil, err
+	}
+
+	settings := make(map[int64]model.PresenceSettings, len(userIDs))
+	for _, id := range userIDs {
+		settings[id] = model.PresenceSettings{UserID: id, Visibility: model.VisibilityEveryone}
+	}
+	for _, row := range rows {
+		settings[row.UserID] = row
+	}
+	return settings, nil
+}
+
+func (r *PostgresRepository) SetPresenceVisibility(ctx context.Context, userID int64, visibility string) error {
+	query := `
+		INSERT INTO user_presence (user_id, visibility, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET visibility = EXCLUDED.visibility, updated_at = NOW()
+	`
+	_, err := r.db.ExecContext(ctx, query, userID, visibility)
+	return err
+}
+
+func (r *PostgresRepository) UpdateLastSeen(ctx context.Context, userID int64, lastSeenAt time.Time) error {
+	query := `
+		INSERT INTO user_presence (user_id, last_seen_at, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET last_seen_at = EXCLUDED.last_seen_at, updated_at = NOW()
+	`
+	_, err := r.db.ExecContext(ctx, query, userID, lastSeenAt)
+	return err
+}
+
+const uploadColumns = `id, user_id, bucket, object_name, multipart_id, file_name, mime_type,
+	size, upload_offset, part_count, created_at, expires_at`
+
+func (r *PostgresRepository) CreateUpload(ctx context.Context, upload *model.FileUpload) error {
+	query := `
+		INSERT INTO file_uploads (id, user_id, bucket, object_name, multipart_id, file_name, mime_type, size, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING created_at
+	`
+	return r.db.QueryRowContext(ctx, query,
+		upload.ID, upload.UserID, upload.Bucket, upload.ObjectName, upload.MultipartID,
+		upload.FileName, upload.MimeType, upload.Size, upload.ExpiresAt,
+	).Scan(&upload.CreatedAt)
+}
+
+func (r *PostgresRepository) GetUpload(ctx context.Context, id string) (*model.FileUpload, error) {
+	var upload model.FileUpload
+	query := `SELECT ` + uploadColumns + ` FROM file_uploads WHERE id = $1`
+	err := r.db.GetContext(ctx, &upload, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// AdvanceUpload records a stored chunk by moving the upload's offset from
+// fromOffset to toOffset. It reports false when the offset was not at
+// fromOffset anymore, i.e. another request stored a chunk first.
+func (r *PostgresRepository) AdvanceUpload(ctx context.Context, id string, fromOffset, toOffset int64, expiresAt time.Time) (bool, error) {
+	query := `
+		UPDATE file_uploads
+		SET upload_offset = $3, part_count = part_count + 1, expires_at = $4
+		WHERE id = $1 AND upload_offset = $2
+	`
+	result, err := r.db.ExecContext(ctx, query, id, fromOffset, toOffset, expiresAt)
+	if err != nil {
+		return false, err
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+func (r *PostgresRepository) DeleteUpload(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM file_uploads WHERE id = $1`, id)
+	return err
+}
+
+func (r *PostgresRepository) GetExpiredUploads(ctx context.Context, before time.Time, limit int) ([]model.FileUpload, error) {
+	var uploads []model.FileUpload
+	query := `
+		SELECT ` + uploadColumns + `
+		FROM file_uploads
+		WHERE expires_at < $1
+		ORDER BY expires_at
+		LIMIT $2
+	`
+	err := r.db.SelectContext(ctx, &uploads, query, before, limit)
+	return uploads, err
+}
+
+const presignedUploadColumns = `id, user_id, bucket, staging_object, file_name, mime_type,
+	finalized, created_at, expires_at`
+
+func (r *PostgresRepository) CreatePresignedUpload(ctx context.Context, upload *model.PresignedUpload) error {
+	query := `
+		INSERT INTO presigned_uploads (id, user_id, bucket, staging_object, file_name, mime_type, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at
+	`
+	return r.db.QueryRowContext(ctx, query,
+		upload.ID, upload.UserID, upload.Bucket, upload.StagingObject,
+		upload.FileName, upload.MimeType, upload.ExpiresAt,
+	).Scan(&upload.CreatedAt)
+}
+
+func (r *PostgresRepository) GetPresignedUpload(ctx context.Context, id string) (*model.PresignedUpload, error) {
+	var upload model.PresignedUpload
+	query := `SELECT ` + presignedUploadColumns + ` FROM presigned_uploads WHERE id = $1`
+	err := r.db.GetContext(ctx, &upload, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// FinalizePresignedUpload marks an upload finalized. It reports false when it
+// was finalized already or is gone.
+func (r *PostgresRepository) FinalizePresignedUpload(ctx context.Context, id string) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `UPDATE presigned_uploads SET finalized = TRUE WHERE id = $1 AND NOT finalized`, id)
+	if err != nil {
+		return false, err
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+func (r *PostgresRepository) DeletePresignedUpload(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM presigned_uploads WHERE id = $1`, id)
+	return err
+}
+
+func (r *PostgresRepository) GetExpiredPresignedUploads(ctx context.Context, before time.Time, limit int) ([]model.PresignedUpload, error) {
+	var uploads []model.PresignedUpload
+	query := `
+		SELECT ` + presignedUploadColumns + `
+		FROM presigned_uploads
+		WHERE expires_at < $1
+		ORDER BY expires_at
+		LIMIT $2
+	`
+	err := r.db.SelectContext(ctx, &uploads, query, before, limit)
+	return uploads, err
+}
+
+const fileScanColumns = `bucket, object_name, user_id, file_name, status, signature, created_at, updated_at,
+	image_width, image_height, blurhash, thumbnails`
+
+// CreateFileScan records a newly stored object. Registering an object twice
+// keeps the first record.
+func (r *PostgresRepository) CreateFileScan(ctx context.Context, scan *model.FileScan) error {
+	query := `
+		INSERT INTO file_scans (bucket, object_name, user_id, file_name, status, signature,
+		                        image_width, image_height, blurhash, thumbnails)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (bucket, object_name) DO NOTHING
+	`
+	_, err := r.db.ExecContext(ctx, query, scan.Bucket, scan.ObjectName, scan.UserID, scan.FileName, scan.Status, scan.Signature,
+		scan.ImageWidth, scan.ImageHeight, scan.Blurhash, scan.Thumbnails)
+	return err
+}
+
+func (r *PostgresRepository) GetFileScan(ctx context.Context, bucket, objectName string) (*model.FileScan, error) {
+	var scan model.FileScan
+	query := `SELECT ` + fileScanColumns + ` FROM file_scans WHERE bucket = $1 AND object_name = $2`
+	err := r.db.GetContext(ctx, &scan, query, bucket, objectName)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &scan, nil
+}
+
+func (r *PostgresRepository) DeleteFileScan(ctx context.Context, bucket, objectName string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM file_scans WHERE bucket = $1 AND object_name = $2`, bucket, objectName)
+	return err
+}
+
+// FinishFileScan stores the verdict on a pending object. It reports false
+// when the object already had one.
+func (r *PostgresRepository) FinishFileScan(ctx context.Context, bucket, objectName, status string, signature *string) (bool, error) {
+	query := `
+		UPDATE file_scans
+		SET status = $3, signature = $4, updated_at = NOW()
+		WHERE bucket = $1 AND object_name = $2 AND status = 'pending'
+	`
+	result, err := r.db.ExecContext(ctx, query, bucket, objectName, status, signature)
+	if err != nil {
+		return false, err
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// GetPendingFileScans returns objects still waiting for a verdict that were
+// registered before the given time.
+func (r *PostgresRepository) GetPendingFileScans(ctx context.Context, before time.Time, limit int) ([]model.FileScan, error) {
+	var scans []model.FileScan
+	query := `
+		SELECT ` + fileScanColumns + `
+		FROM file_scans
+		WHERE status = 'pending' AND updated_at < $1
+		ORDER BY updated_at
+		LIMIT $2
+	`
+	err := r.db.SelectContext(ctx, &scans, query, before, limit)
+	return scans, err
+}
+
+// SetMessagesFileStatus moves the messages sent with a pending object to the
+// given status and returns their IDs. Messages that already left the pending
+// status are not returned again.
+func (r *PostgresRepository) SetMessagesFileStatus(ctx context.Context, bucket, objectName, status string) ([]int64, error) {
+	var ids []int64
+	query := `
+		UPDATE messages
+		SET file_status = $3
+		WHERE file_bucket = $1 AND file_object = $2 AND file_status = 'pending'
+		RETURNING id
+	`
+	err := r.db.SelectContext(ctx, &ids, query, bucket, objectName, status)
+	return ids, err
 }
@@ -0,0 +1,400 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+)
+
+// countingDriver is a minimal database/sql driver that answers queries from
+// canned fixtures and counts every round trip, so tests can pin the number of
+// queries a repository call issues without a running Postgres.
+type countingDriver struct {
+	mu       sync.Mutex
+	backends map[string]*fakeBackend
+}
+
+type fakeBackend struct {
+	queries atomic.Int64
+	respond func(query string) ([]string, [][]driver.Value)
+}
+
+var fakeDriver = &countingDriver{backends: make(map[string]*fakeBackend)}
+
+func init() {
+	sql.Register("counting", fakeDriver)
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	backend, ok := d.backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown fake backend %q", name)
+	}
+	return &fakeConn{backend: backend}, nil
+}
+
+func newCountingRepository(t testing.TB, respond func(query string) ([]string, [][]driver.Value)) (*PostgresRepository, *fakeBackend) {
+	t.Helper()
+
+	backend := &fakeBackend{respond: respond}
+	name := t.Name()
+
+	fakeDriver.mu.Lock()
+	fakeDriver.backends[name] = backend
+	fakeDriver.mu.Unlock()
+
+	db, err := sql.Open("counting", name)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+		fakeDriver.mu.Lock()
+		delete(fakeDriver.backends, name)
+		fakeDriver.mu.Unlock()
+	})
+
+	return &PostgresRepository{db: sqlx.NewDb(db, "postgres")}, backend
+}
+
+type fakeConn struct {
+	backend *fakeBackend
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepare not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions not supported")
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.backend.queries.Add(1)
+	columns, rows := c.backend.respond(query)
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.backend.queries.Add(1)
+	return driver.RowsAffected(1), nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+	pos     int
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.pos])
+	r.pos++
+	return nil
+}
+
+var fixtureMessageColumns = []string{
+	"id", "conversation_id", "sender_id", "content", "message_type",
+	"file_url", "file_name", "file_size", "mime_type",
+	"created_at", "edited_at", "deleted_at", "reply_to_message_id", "thread_root_id",
+	"forwarded_from_message_id", "forwarded_from_sender_id", "forwarded_from_conversation_id", "client_msg_id",
+	"image_width", "image_height", "blurhash", "thumbnails",
+	"file_bucket", "file_object", "file_status",
+}
+
+// lastMessageColumns are the message columns the conversation list selects
+// for each conversation's last and pinned message: the leading twelve and
+// the file reference.
+var lastMessageColumns = append(fixtureMessageColumns[:12:12], "file_bucket", "file_object", "file_status")
+
+func lastMessageRow(id int64) []driver.Value {
+	row := messageRow(id)
+	return append(row[:12:12], row[len(row)-3:]...)
+}
+
+func messageRow(id int64) []driver.Value {
+	var replyTo driver.Value
+	if id > 1 {
+		replyTo = id - 1
+	}
+	return []driver.Value{
+		id, int64(5), id%3 + 1, fmt.Sprintf("message %d", id), "text",
+		nil, nil, nil, nil,
+		time.Unix(1700000000+id, 0), nil, nil, replyTo, nil,
+		nil, nil, nil, nil,
+		nil, nil, nil, nil,
+		nil, nil, nil,
+	}
+}
+
+// historyFixture serves a page of pageSize messages, each with one delivery,
+// one read receipt and one reaction, and each replying to the one before it.
+func historyFixture(pageSize int) func(query string) ([]string, [][]driver.Value) {
+	return func(query string) ([]string, [][]driver.Value) {
+		switch {
+		case strings.Contains(query, "p.last_read_message_id"):
+			rows := make([][]driver.Value, 0, pageSize)
+			for i := 1; i <= pageSize; i++ {
+				rows = append(rows, []driver.Value{int64(i), int64(9)})
+			}
+			return []string{"message_id", "user_id"}, rows
+		case strings.Contains(query, "FROM message_deliveries"):
+			rows := make([][]driver.Value, 0, pageSize)
+			for i := 1; i <= pageSize; i++ {
+				rows = append(rows, []driver.Value{int64(i), int64(8), time.Unix(1700000000, 0)})
+			}
+			return []string{"message_id", "user_id", "delivered_at"}, rows
+		case strings.Contains(query, "FROM message_mentions"):
+			return []string{"message_id", "user_id"}, [][]driver.Value{{int64(1), int64(9)}}
+		case strings.Contains(query, "FROM message_reactions"):
+			rows := make([][]driver.Value, 0, pageSize)
+			for i := 1; i <= pageSize; i++ {
+				rows = append(rows, []driver.Value{int64(i), int64(i), int64(9), "👍", time.Unix(1700000000, 0)})
+			}
+			return []string{"id", "message_id", "user_id", "reaction", "created_at"}, rows
+		case strings.Contains(query, "GROUP BY thread_root_id"):
+			return []string{"thread_root_id", "reply_count", "last_reply_at"}, [][]driver.Value{
+				{int64(1), int64(3), time.Unix(1700000100, 0)},
+			}
+		case strings.Contains(query, "WHERE id = ANY"):
+			rows := make([][]driver.Value, 0, pageSize)
+			for i := 1; i < pageSize; i++ {
+				rows = append(rows, []driver.Value{int64(i), int64(5), int64(1), "quoted", "text", time.Unix(1700000000, 0), nil})
+			}
+			return []string{"id", "conversation_id", "sender_id", "content", "message_type", "created_at", "deleted_at"}, rows
+		case strings.Contains(query, "FROM messages"):
+			rows := make([][]driver.Value, 0, pageSize)
+			for i := pageSize; i >= 1; i-- {
+				rows = append(rows, messageRow(int64(i)))
+			}
+			return fixtureMessageColumns, rows
+		}
+		return nil, nil
+	}
+}
+
+func conversationsFixture(pageSize int) func(query string) ([]string, [][]driver.Value) {
+	return func(query string) ([]string, [][]driver.Value) {
+		columns := append([]string{"id", "is_group", "name", "created_at", "unread_count", "unread_mentions", "participant_ids"}, lastMessageColumns...)
+		columns = append(columns, lastMessageColumns...)
+		rows := make([][]driver.Value, 0, pageSize)
+		for i := 1; i <= pageSize; i++ {
+			row := []driver.Value{int64(i), false, "", time.Unix(1700000000, 0), int64(2), int64(1), "{1,2}"}
+			if i%2 == 0 {
+				row = append(row, lastMessageRow(int64(i))...)
+			} else {
+				row = append(row, make([]driver.Value, len(lastMessageColumns))...)
+			}
+			if i%3 == 0 {
+				row = append(row, lastMessageRow(int64(i-1))...)
+			} else {
+				row = append(row, make([]driver.Value, len(lastMessageColumns))...)
+			}
+			rows = append(rows, row)
+		}
+		return columns, rows
+	}
+}
+
+// Queries issued for one history page regardless of its size: the page itself
+// plus one batch each for deliveries, read receipts, reactions, thread
+// statistics, mentions and quoted message previews.
+const historyPageQueries = 7
+
+func TestGetMessages_QueryCountIsConstant(t *testing.T) {
+	for _, pageSize := range []int{2, 10, 50} {
+		t.Run(fmt.Sprintf("page_%d", pageSize), func(t *testing.T) {
+			repo, backend := newCountingRepository(t, historyFixture(pageSize))
+
+			messages, err := repo.GetMessages(context.Background(), 5, 1, 0, 0, pageSize)
+
+			require.NoError(t, err)
+			assert.Len(t, messages, pageSize)
+			assert.Equal(t, int64(historyPageQueries), backend.queries.Load())
+			for _, msg := range messages {
+				assert.Equal(t, []int64{8}, msg.DeliveredTo)
+				assert.Equal(t, []int64{9}, msg.ReadBy)
+				assert.Len(t, msg.Reactions, 1)
+				if msg.ReplyToMessageID != nil {
+					assert.Equal(t, *msg.ReplyToMessageID, msg.ReplyTo.ID)
+				}
+				if msg.ID == 1 {
+					assert.Equal(t, []int64{9}, msg.Mentions)
+					assert.Equal(t, 3, msg.ReplyCount)
+					assert.NotNil(t, msg.LastReplyAt)
+				}
+			}
+		})
+	}
+}
+
+func TestGetUserConversations_SingleQuery(t *testing.T) {
+	repo, backend := newCountingRepository(t, conversationsFixture(20))
+
+	conversations, err := repo.GetUserConversations(context.Background(), 1, 20, 0)
+
+	require.NoError(t, err)
+	assert.Len(t, conversations, 20)
+	assert.Equal(t, int64(1), backend.queries.Load())
+	assert.Equal(t, []int64{1, 2}, conversations[0].ParticipantIDs)
+	assert.Equal(t, 1, conversations[0].UnreadMentions)
+	assert.Nil(t, conversations[0].LastMessage)
+	assert.Equal(t, int64(2), conversations[1].LastMessage.ID)
+	assert.Nil(t, conversations[1].PinnedMessage)
+	assert.Equal(t, int64(2), conversations[2].PinnedMessage.ID)
+}
+
+func TestGetUserConversations_HidesPendingFiles(t *testing.T) {
+	var conversationsQuery string
+	fixture := conversationsFixture(2)
+	repo, _ := newCountingRepository(t, func(query string) ([]string, [][]driver.Value) {
+		conversationsQuery = query
+		columns, rows := fixture(query)
+		// The last message of the second conversation is held back.
+		status := len(rows[1]) - len(lastMessageColumns) - 1
+		rows[1][status-2], rows[1][status-1], rows[1][status] = "chat-files", "1/abc.pdf", "pending"
+		return columns, rows
+	})
+
+	conversations, err := repo.GetUserConversations(context.Background(), 1, 20, 0)
+
+	require.NoError(t, err)
+	assert.Equal(t, "pending", *conversations[1].LastMessage.FileStatus)
+	assert.Equal(t, "1/abc.pdf", *conversations[1].LastMessage.FileObject)
+	assert.Equal(t, 2, strings.Count(conversationsQuery, scannedFileURL))
+	// Neither unread_count nor unread_mentions counts held back messages.
+	assert.Equal(t, 2, strings.Count(conversationsQuery, "m.file_status <> 'pending'"))
+	// Nor is a held back message the last message of anyone but its sender.
+	assert.Contains(t, conversationsQuery, visibleTo("$1"))
+}
+
+func TestGetMessages_HidesHeldBackMessagesFromOthers(t *testing.T) {
+	var historyQueries []string
+	fixture := historyFixture(3)
+	repo, _ := newCountingRepository(t, func(query string) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "FROM messages\n") && strings.Contains(query, "LIMIT $") {
+			historyQueries = append(historyQueries, query)
+		}
+		return fixture(query)
+	})
+
+	ctx := context.Background()
+	_, err := repo.GetMessages(ctx, 5, 1, 0, 0, 20)
+	require.NoError(t, err)
+	_, err = repo.GetMessages(ctx, 5, 1, 10, 0, 20)
+	require.NoError(t, err)
+	_, err = repo.GetThreadMessages(ctx, 7, 1, 0, 10, 20)
+	require.NoError(t, err)
+
+	require.Len(t, historyQueries, 3)
+	for _, query := range historyQueries {
+		assert.Contains(t, query, visibleTo("$2"))
+	}
+}
+
+func TestGetUserThreads_ConversationFilterIsBigint(t *testing.T) {
+	var threadsQuery string
+	repo, _ := newCountingRepository(t, func(query string) ([]string, [][]driver.Value) {
+		threadsQuery = query
+		return nil, nil
+	})
+
+	threads, err := repo.GetUserThreads(context.Background(), 1, 1<<40, 20)
+
+	require.NoError(t, err)
+	assert.Empty(t, threads)
+	// Comparing an untyped $2 with 0 first would make Postgres infer int4.
+	assert.Contains(t, threadsQuery, "$2::bigint = 0")
+}
+
+func BenchmarkGetMessages_Page50(b *testing.B) {
+	repo, backend := newCountingRepository(b, historyFixture(50))
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		before := backend.queries.Load()
+		if _, err := repo.GetMessages(ctx, 5, 1, 0, 0, 50); err != nil {
+			b.Fatal(err)
+		}
+		if got := backend.queries.Load() - before; got != historyPageQueries {
+			b.Fatalf("expected %d queries per page, got %d", historyPageQueries, got)
+		}
+	}
+	b.ReportMetric(float64(backend.queries.Load())/float64(b.N), "queries/op")
+}
+
+func BenchmarkGetUserConversations_Page20(b *testing.B) {
+	repo, backend := newCountingRepository(b, conversationsFixture(20))
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		before := backend.queries.Load()
+		if _, err := repo.GetUserConversations(ctx, 1, 20, 0); err != nil {
+			b.Fatal(err)
+		}
+		if got := backend.queries.Load() - before; got != 1 {
+			b.Fatalf("expected 1 query per page, got %d", got)
+		}
+	}
+	b.ReportMetric(float64(backend.queries.Load())/float64(b.N), "queries/op")
+}
+
+func TestSearchMessages_AppliesFilters(t *testing.T) {
+	var searchQuery string
+	repo, backend := newCountingRepository(t, func(query string) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "websearch_to_tsquery") {
+			searchQuery = query
+			row := append(messageRow(2), "<mark>message</mark> 2")
+			return append(append([]string{}, fixtureMessageColumns...), "snippet"), [][]driver.Value{row}
+		}
+		return nil, nil
+	})
+
+	from := time.Unix(1700000000, 0)
+	results, err := repo.SearchMessages(context.Background(), 1, model.MessageSearch{
+		Query:       "message",
+		SenderID:    2,
+		MessageType: "text",
+		From:        &from,
+		BeforeID:    100,
+	}, 21)
+
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "<mark>message</mark> 2", results[0].Snippet)
+	assert.Equal(t, int64(2), results[0].ID)
+	assert.Contains(t, searchQuery, "ts_headline('simple', "+escapedContent)
+	assert.Contains(t, searchQuery, scannedFileURL)
+	assert.Contains(t, searchQuery, scannedThumbnails)
+	assert.Contains(t, searchQuery, "deleted_at IS NULL")
+	assert.Contains(t, searchQuery, visibleTo("$1"))
+	assert.Contains(t, searchQuery, "sender_id = $3")
+	assert.Contains(t, searchQuery, "message_type = $4")
+	assert.Contains(t, searchQuery, "created_at >= $5")
+	assert.Contains(t, searchQuery, "id < $6")
+	assert.Contains(t, searchQuery, "LIMIT $7")
+	assert.NotContains(t, searchQuery, "conversation_id = $")
+	assert.Equal(t, int64(historyPageQueries), backend.queries.Load())
+}
@@ -0,0 +1,169 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+)
+
+// clamdChunkSize is how much of a file is sent per INSTREAM chunk. clamd
+// rejects streams longer than its StreamMaxLength whatever the chunking.
+const clamdChunkSize = 64 * 1024
+
+// ClamdScanner scans files with a ClamAV daemon over TCP. Files are streamed
+// with the INSTREAM command, so the daemon does not need access to storage.
+type ClamdScanner struct {
+	addr    string
+	timeout time.Duration
+}
+
+func NewClamdScanner(addr string, timeout time.Duration) *ClamdScanner {
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	return &ClamdScanner{addr: addr, timeout: timeout}
+}
+
+// Ping checks that the daemon is reachable and answering.
+func (c *ClamdScanner) Ping(ctx context.Context) error {
+	reply, err := c.command(ctx, "PING", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd: unexpected reply to PING: %q", reply)
+	}
+	return nil
+}
+
+// Scan streams r to the daemon and reports whether it found a signature.
+func (c *ClamdScanner) Scan(ctx context.Context, r io.Reader) (model.ScanResult, error) {
+	reply, err := c.command(ctx, "INSTREAM", r)
+	if err != nil {
+		return model.ScanResult{}, err
+	}
+	return parseScanReply(reply)
+}
+
+// command sends one null-terminated command on a new connection and returns
+// the daemon's reply. With body set, it is sent as the INSTREAM chunks: each
+// prefixed by its length as a 4 byte big-endian integer, ending with an empty
+// chunk.
+func (c *ClamdScanner) command(ctx context.Context, name string, body io.Reader) (string, error) {
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	w := bufio.NewWriterSize(conn, clamdChunkSize+4)
+	w.WriteString("z" + name + "\x00")
+	if body != nil {
+		readErr, writeErr := writeChunks(w, body)
+		if readErr != nil {
+			return "", readErr
+		}
+		if writeErr != nil {
+			// clamd hangs up on a stream over its size limit and says so
+			// before closing, which explains the failed write better.
+			if reply, readErr := readReply(conn); readErr == nil && reply != "" {
+				return "", fmt.Errorf("clamd: %s", reply)
+			}
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			return "", fmt.Errorf("clamd: %w", writeErr)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	return reply, nil
+}
+
+// writeChunks sends body as INSTREAM chunks. Failing to read body and failing
+// to send to the daemon are reported apart.
+func writeChunks(w *bufio.Writer, body io.Reader) (readErr, writeErr error) {
+	buf := make([]byte, clamdChunkSize)
+	var size [4]byte
+	for {
+		n, err := io.ReadFull(body, buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			w.Write(size[:])
+			if _, err := w.Write(buf[:n]); err != nil {
+				return nil, err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err, nil
+		}
+	}
+
+	binary.BigEndian.PutUint32(size[:], 0)
+	_, err := w.Write(size[:])
+	return nil, err
+}
+
+// readReply reads a reply up to its terminating null byte.
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && (err != io.EOF || len(reply) == 0) {
+		return "", err
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
+
+var errUnexpectedReply = errors.New("clamd: unexpected reply")
+
+// parseScanReply reads replies such as "stream: OK" and
+// "stream: Eicar-Test-Signature FOUND". Anything else, e.g. "INSTREAM size
+// limit exceeded. ERROR", is an error.
+func parseScanReply(reply string) (model.ScanResult, error) {
+	if strings.HasSuffix(reply, " ERROR") {
+		return model.ScanResult{}, fmt.Errorf("clamd: %s", strings.TrimSuffix(reply, " ERROR"))
+	}
+
+	_, status, ok := strings.Cut(reply, ": ")
+	if !ok {
+		return model.ScanResult{}, fmt.Errorf("%w: %q", errUnexpectedReply, reply)
+	}
+
+	switch {
+	case status == "OK":
+		return model.ScanResult{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return model.ScanResult{Infected: true, Signature: strings.TrimSuffix(status, " FOUND")}, nil
+	default:
+		return model.ScanResult{}, fmt.Errorf("%w: %q", errUnexpectedReply, reply)
+	}
+}
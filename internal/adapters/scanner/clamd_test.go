@@ -0,0 +1,225 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd speaks enough of the clamd protocol to answer PING and INSTREAM.
+// Streams containing the EICAR test string are reported infected.
+type fakeClamd struct {
+	listener  net.Listener
+	maxStream int
+	// silent makes the daemon read commands without ever replying.
+	silent bool
+
+	mu     sync.Mutex
+	chunks []int
+	stream []byte
+}
+
+func startFakeClamd(t *testing.T, options ...func(*fakeClamd)) *fakeClamd {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	d := &fakeClamd{listener: listener, maxStream: 1 << 20}
+	for _, option := range options {
+		option(d)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *fakeClamd) addr() string {
+	return d.listener.Addr().String()
+}
+
+// received returns the last stream scanned and the sizes of its chunks.
+func (d *fakeClamd) received() ([]byte, []int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stream, d.chunks
+}
+
+func (d *fakeClamd) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+
+	switch command {
+	case "zPING\x00":
+		conn.Write([]byte("PONG\x00"))
+	case "zINSTREAM\x00":
+		var stream []byte
+		var chunks []int
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if len(stream)+int(size) > d.maxStream {
+				conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+				return
+			}
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return
+			}
+			stream = append(stream, chunk...)
+			chunks = append(chunks, int(size))
+		}
+
+		d.mu.Lock()
+		d.stream, d.chunks = stream, chunks
+		d.mu.Unlock()
+
+		if d.silent {
+			io.Copy(io.Discard, r)
+			return
+		}
+		if bytes.Contains(stream, []byte(eicar)) {
+			conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		} else {
+			conn.Write([]byte("stream: OK\x00"))
+		}
+	default:
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+	}
+}
+
+func TestClamdScanner_Ping(t *testing.T) {
+	daemon := startFakeClamd(t)
+
+	err := NewClamdScanner(daemon.addr(), time.Second).Ping(context.Background())
+
+	assert.NoError(t, err)
+}
+
+func TestClamdScanner_CleanFile(t *testing.T) {
+	daemon := startFakeClamd(t)
+
+	result, err := NewClamdScanner(daemon.addr(), time.Second).Scan(context.Background(), strings.NewReader("hello"))
+
+	require.NoError(t, err)
+	assert.Equal(t, model.ScanResult{}, result)
+	stream, _ := daemon.received()
+	assert.Equal(t, []byte("hello"), stream)
+}
+
+func TestClamdScanner_InfectedFile(t *testing.T) {
+	daemon := startFakeClamd(t)
+
+	result, err := NewClamdScanner(daemon.addr(), time.Second).Scan(context.Background(), strings.NewReader(eicar))
+
+	require.NoError(t, err)
+	assert.True(t, result.Infected)
+	assert.Equal(t, "Eicar-Test-Signature", result.Signature)
+}
+
+func TestClamdScanner_StreamsLargeFilesInChunks(t *testing.T) {
+	daemon := startFakeClamd(t)
+	data := bytes.Repeat([]byte("a"), 2*clamdChunkSize+10)
+
+	_, err := NewClamdScanner(daemon.addr(), time.Second).Scan(context.Background(), bytes.NewReader(data))
+
+	require.NoError(t, err)
+	stream, chunks := daemon.received()
+	assert.Equal(t, []int{clamdChunkSize, clamdChunkSize, 10}, chunks)
+	assert.Equal(t, data, stream)
+}
+
+func TestClamdScanner_SizeLimitExceeded(t *testing.T) {
+	daemon := startFakeClamd(t, func(d *fakeClamd) { d.maxStream = clamdChunkSize })
+
+	_, err := NewClamdScanner(daemon.addr(), time.Second).Scan(context.Background(), bytes.NewReader(make([]byte, 4*clamdChunkSize)))
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "size limit exceeded")
+}
+
+func TestClamdScanner_DaemonUnavailable(t *testing.T) {
+	daemon := startFakeClamd(t)
+	daemon.listener.Close()
+
+	_, err := NewClamdScanner(daemon.addr(), time.Second).Scan(context.Background(), strings.NewReader("hello"))
+
+	assert.Error(t, err)
+}
+
+func TestClamdScanner_GivesUpOnSilentDaemon(t *testing.T) {
+	daemon := startFakeClamd(t, func(d *fakeClamd) { d.silent = true })
+
+	start := time.Now()
+	_, err := NewClamdScanner(daemon.addr(), 100*time.Millisecond).Scan(context.Background(), strings.NewReader("hello"))
+
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestClamdScanner_ContextCancelled(t *testing.T) {
+	daemon := startFakeClamd(t, func(d *fakeClamd) { d.silent = true })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := NewClamdScanner(daemon.addr(), time.Minute).Scan(ctx, strings.NewReader("hello"))
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestParseScanReply(t *testing.T) {
+	tests := []struct {
+		reply   string
+		want    model.ScanResult
+		wantErr bool
+	}{
+		{reply: "stream: OK", want: model.ScanResult{}},
+		{reply: "stream: Win.Test.EICAR_HDB-1 FOUND", want: model.ScanResult{Infected: true, Signature: "Win.Test.EICAR_HDB-1"}},
+		{reply: "INSTREAM size limit exceeded. ERROR", wantErr: true},
+		{reply: "stream: Can't allocate memory ERROR", wantErr: true},
+		{reply: "garbage", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.reply, func(t *testing.T) {
+			got, err := parseScanReply(tt.reply)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
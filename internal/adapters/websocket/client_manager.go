@@ -1,40 +1,368 @@
 package websocket
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+type Config struct {
+	// SendQueueSize is how many outbound frames a session may have queued
+	// before it is treated as a slow consumer and disconnected.
+	SendQueueSize int
+	// WriteTimeout bounds every single write to the connection.
+	WriteTimeout time.Duration
+	// PingInterval is how often the server pings the client. It must be
+	// shorter than PongTimeout.
+	PingInterval time.Duration
+	// PongTimeout is how long a connection may stay silent, pongs included,
+	// before it is considered dead and reaped.
+	PongTimeout time.Duration
+	// MaxMessageSize is the largest frame in bytes accepted from a client.
+	MaxMessageSize int64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		SendQueueSize:  256,
+		WriteTimeout:   10 * time.Second,
+		PingInterval:   54 * time.Second,
+		PongTimeout:    60 * time.Second,
+		MaxMessageSize: 64 << 10,
+	}
+}
+
+// Session is a single WebSocket connection of a user. A user holds one
+// session per connected device. All writes go through the session's queue
+// and are performed by its WritePump, the connection's only writer.
+type Session struct {
+	ID       string
+	UserID   int64
+	DeviceID string
+	Conn     *websocket.Conn
+
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	config    Config
+
+	// While holding, frames are buffered instead of queued, so missed events
+	// can be replayed ahead of live ones.
+	holdLock sync.Mutex
+	holding  bool
+	held     [][]byte
+}
+
+// NewSession creates a session with a fresh random ID. When deviceID is empty
+// the session ID doubles as the device ID.
+func NewSession(userID int64, deviceID string, conn *websocket.Conn, cfg Config) *Session {
+	id := newSessionID()
+	if deviceID == "" {
+		deviceID = id
+	}
+	return &Session{
+		ID:       id,
+		UserID:   userID,
+		DeviceID: deviceID,
+		Conn:     conn,
+		send:     make(chan []byte, cfg.SendQueueSize),
+		done:     make(chan struct{}),
+		config:   cfg,
+	}
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Send queues a text frame without blocking. It reports false when the
+// session is closed or its queue is full.
+func (s *Session) Send(data []byte) bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+
+	s.holdLock.Lock()
+	defer s.holdLock.Unlock()
+	if s.holding {
+		if len(s.held) >= cap(s.send) {
+			return false
+		}
+		s.held = append(s.held, data)
+		return true
+	}
+
+	return s.enqueue(data)
+}
+
+// Hold makes Send buffer frames until Resume is called.
+func (s *Session) Hold() {
+	s.holdLock.Lock()
+	defer s.holdLock.Unlock()
+	s.holding = true
+}
+
+// Resume queues the replayed frames, then the frames buffered since Hold
+// except those skip reports as already replayed, and returns to queueing
+// directly. The session is closed if its queue overflows.
+func (s *Session) Resume(replay [][]byte, skip func([]byte) bool) {
+	s.holdLock.Lock()
+	defer s.holdLock.Unlock()
+
+	held := s.held
+	s.holding = false
+	s.held = nil
+
+	for _, data := range replay {
+		if !s.enqueue(data) {
+			s.Close()
+			return
+		}
+	}
+	for _, data := range held {
+		if skip != nil && skip(data) {
+			continue
+		}
+		if !s.enqueue(data) {
+			s.Close()
+			return
+		}
+	}
+}
+
+func (s *Session) enqueue(data []byte) bool {
+	select {
+	case s.send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// WritePump writes queued frames and periodic pings until the session is
+// closed or a write fails, and closes the session on return.
+func (s *Session) WritePump() {
+	ticker := time.NewTicker(s.config.PingInterval)
+	defer func() {
+		ticker.Stop()
+		s.Close()
+	}()
+
+	for {
+		select {
+		case data := <-s.send:
+			s.Conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+			if err := s.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Printf("[WS] Write to session %s of user %d failed: %v", s.ID, s.UserID, err)
+				return
+			}
+		case <-ticker.C:
+			s.Conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+			if err := s.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("[WS] Ping to session %s of user %d failed: %v", s.ID, s.UserID, err)
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// PrepareRead applies the frame size limit and read deadline to the
+// connection. Every pong pushes the deadline back, so a client that stops
+// answering pings makes the next read fail once PongTimeout has passed.
+// onPong, if not nil, runs on the read goroutine after each pong.
+func (s *Session) PrepareRead(onPong func()) {
+	s.Conn.SetReadLimit(s.config.MaxMessageSize)
+	s.Conn.SetReadDeadline(time.Now().Add(s.config.PongTimeout))
+	s.Conn.SetPongHandler(func(string) error {
+		if onPong != nil {
+			onPong()
+		}
+		return s.Conn.SetReadDeadline(time.Now().Add(s.config.PongTimeout))
+	})
+}
+
+// ReadMessage reads the next frame, extending the read deadline on any
+// client traffic, not just pongs.
+func (s *Session) ReadMessage() ([]byte, error) {
+	_, data, err := s.Conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	s.Conn.SetReadDeadline(time.Now().Add(s.config.PongTimeout))
+	return data, nil
+}
+
+// Close stops the write pump and closes the connection, which also ends the
+// session's read loop. It is safe to call more than once.
+func (s *Session) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.Conn.Close()
+	})
+}
+
+// Done is closed once the session has been closed.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Session) QueueDepth() int {
+	return len(s.send)
+}
+
+// Stats is a point-in-time view of the manager's sessions and send queues.
+type Stats struct {
+	Users          int   `json:"users"`
+	Sessions       int   `json:"sessions"`
+	QueuedMessages int   `json:"queued_messages"`
+	MaxQueueDepth  int   `json:"max_queue_depth"`
+	SendQueueSize  int   `json:"send_queue_size"`
+	SlowConsumers  int64 `json:"slow_consumers_disconnected"`
+}
+
 type ClientManager struct {
-	clients map[int64]*websocket.Conn
-	lock    sync.RWMutex
+	clients       map[int64]map[string]*Session
+	lock          sync.RWMutex
+	config        Config
+	slowConsumers atomic.Int64
 }
 
-func NewClientManager() *ClientManager {
+func NewClientManager(cfg Config) *ClientManager {
+	if cfg.PingInterval <= 0 || cfg.PingInterval >= cfg.PongTimeout {
+		cfg.PingInterval = cfg.PongTimeout * 9 / 10
+	}
 	return &ClientManager{
-		clients: make(map[int64]*websocket.Conn),
+		clients: make(map[int64]map[string]*Session),
+		config:  cfg,
 	}
 }
 
-func (manager *ClientManager) AddClient(userID int64, conn *websocket.Conn) {
+// NewSession creates a session using the manager's connection settings.
+func (manager *ClientManager) NewSession(userID int64, deviceID string, conn *websocket.Conn) *Session {
+	return NewSession(userID, deviceID, conn, manager.config)
+}
+
+// AddClient registers a session. A previous session of the same device is
+// closed and replaced, so a reconnecting device never counts twice.
+func (manager *ClientManager) AddClient(session *Session) {
 	manager.lock.Lock()
 	defer manager.lock.Unlock()
-	manager.clients[userID] = conn
+
+	sessions, ok := manager.clients[session.UserID]
+	if !ok {
+		sessions = make(map[string]*Session)
+		manager.clients[session.UserID] = sessions
+	}
+
+	for id, existing := range sessions {
+		if existing.DeviceID == session.DeviceID {
+			existing.Close()
+			delete(sessions, id)
+		}
+	}
+	sessions[session.ID] = session
 }
 
-func (manager *ClientManager) RemoveClient(userID int64) {
+// RemoveClient closes and unregisters one session of a user, leaving the
+// user's other sessions connected. It returns how many sessions remain.
+func (manager *ClientManager) RemoveClient(userID int64, sessionID string) int {
 	manager.lock.Lock()
 	defer manager.lock.Unlock()
-	if conn, ok := manager.clients[userID]; ok {
-		conn.Close()
+
+	sessions, ok := manager.clients[userID]
+	if !ok {
+		return 0
+	}
+
+	if session, ok := sessions[sessionID]; ok {
+		session.Close()
+		delete(sessions, sessionID)
+	}
+
+	if len(sessions) == 0 {
 		delete(manager.clients, userID)
 	}
+	return len(sessions)
+}
+
+// GetSessions returns a snapshot of the user's connected sessions.
+func (manager *ClientManager) GetSessions(userID int64) []*Session {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
+	sessions := make([]*Session, 0, len(manager.clients[userID]))
+	for _, session := range manager.clients[userID] {
+		sessions = append(sessions, session)
+	}
+	return sessions
 }
 
-func (manager *ClientManager) GetClient(userID int64) (*websocket.Conn, bool) {
+// Users returns the IDs of all users with at least one session.
+func (manager *ClientManager) Users() []int64 {
 	manager.lock.RLock()
 	defer manager.lock.RUnlock()
-	conn, ok := manager.clients[userID]
-	return conn, ok
+
+	users := make([]int64, 0, len(manager.clients))
+	for userID := range manager.clients {
+		users = append(users, userID)
+	}
+	return users
+}
+
+func (manager *ClientManager) IsOnline(userID int64) bool {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	return len(manager.clients[userID]) > 0
+}
+
+// SendToUser queues data on every session of the user. A session whose queue
+// is full is disconnected rather than allowed to hold up delivery; its read
+// loop then unregisters it.
+func (manager *ClientManager) SendToUser(userID int64, data []byte) {
+	for _, session := range manager.GetSessions(userID) {
+		if session.Send(data) {
+			continue
+		}
+
+		select {
+		case <-session.Done():
+		default:
+			manager.slowConsumers.Add(1)
+			log.Printf("[WS] Session %s of user %d is too slow (%d queued), disconnecting", session.ID, userID, session.QueueDepth())
+			session.Close()
+		}
+	}
+}
+
+func (manager *ClientManager) Stats() Stats {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
+	stats := Stats{
+		Users:         len(manager.clients),
+		SendQueueSize: manager.config.SendQueueSize,
+		SlowConsumers: manager.slowConsumers.Load(),
+	}
+	for _, sessions := range manager.clients {
+		for _, session := range sessions {
+			depth := session.QueueDepth()
+			stats.Sessions++
+			stats.QueuedMessages += depth
+			if depth > stats.MaxQueueDepth {
+				stats.MaxQueueDepth = depth
+			}
+		}
+	}
+	return stats
 }
@@ -0,0 +1,225 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newConnPair returns the server and client ends of a real WebSocket
+// connection served by an httptest server.
+func newConnPair(t *testing.T) (server, client *websocket.Conn) {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	server = <-conns
+	t.Cleanup(func() { server.Close() })
+	return server, client
+}
+
+func TestClientManager_DeliversToEverySession(t *testing.T) {
+	manager := NewClientManager(DefaultConfig())
+
+	phoneConn, phone := newConnPair(t)
+	laptopConn, laptop := newConnPair(t)
+
+	phoneSession := manager.NewSession(1, "phone", phoneConn)
+	laptopSession := manager.NewSession(1, "laptop", laptopConn)
+	manager.AddClient(phoneSession)
+	manager.AddClient(laptopSession)
+	go phoneSession.WritePump()
+	go laptopSession.WritePump()
+
+	manager.SendToUser(1, []byte(`{"type":"message"}`))
+
+	for _, client := range []*websocket.Conn{phone, laptop} {
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		_, data, err := client.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, `{"type":"message"}`, string(data))
+	}
+
+	assert.Equal(t, 1, manager.RemoveClient(1, phoneSession.ID))
+	assert.True(t, manager.IsOnline(1))
+	assert.Equal(t, 0, manager.RemoveClient(1, laptopSession.ID))
+	assert.False(t, manager.IsOnline(1))
+}
+
+func TestClientManager_SameDeviceReplacesSession(t *testing.T) {
+	manager := NewClientManager(DefaultConfig())
+
+	oldConn, _ := newConnPair(t)
+	newConn, _ := newConnPair(t)
+
+	oldSession := manager.NewSession(1, "phone", oldConn)
+	newSession := manager.NewSession(1, "phone", newConn)
+	manager.AddClient(oldSession)
+	manager.AddClient(newSession)
+
+	sessions := manager.GetSessions(1)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, newSession.ID, sessions[0].ID)
+
+	select {
+	case <-oldSession.Done():
+	default:
+		t.Fatal("replaced session was not closed")
+	}
+}
+
+func TestClientManager_DisconnectsSlowConsumer(t *testing.T) {
+	manager := NewClientManager(Config{SendQueueSize: 2, WriteTimeout: time.Second})
+
+	slowConn, _ := newConnPair(t)
+	fastConn, _ := newConnPair(t)
+
+	// Neither write pump runs, so queued frames are never drained.
+	slow := manager.NewSession(1, "slow", slowConn)
+	fast := manager.NewSession(2, "fast", fastConn)
+	manager.AddClient(slow)
+	manager.AddClient(fast)
+
+	manager.SendToUser(1, []byte("1"))
+	manager.SendToUser(1, []byte("2"))
+
+	stats := manager.Stats()
+	assert.Equal(t, 2, stats.Sessions)
+	assert.Equal(t, 2, stats.QueuedMessages)
+	assert.Equal(t, 2, stats.MaxQueueDepth)
+
+	manager.SendToUser(1, []byte("3"))
+	manager.SendToUser(2, []byte("1"))
+
+	select {
+	case <-slow.Done():
+	default:
+		t.Fatal("slow session was not disconnected")
+	}
+	assert.Equal(t, 1, fast.QueueDepth())
+	assert.Equal(t, int64(1), manager.Stats().SlowConsumers)
+
+	manager.SendToUser(1, []byte("4"))
+	assert.Equal(t, int64(1), manager.Stats().SlowConsumers)
+}
+
+func heartbeatConfig() Config {
+	return Config{
+		SendQueueSize:  8,
+		WriteTimeout:   time.Second,
+		PingInterval:   20 * time.Millisecond,
+		PongTimeout:    100 * time.Millisecond,
+		MaxMessageSize: 16,
+	}
+}
+
+// readUntilError runs the session's read loop and reports how it ended.
+func readUntilError(session *Session) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		session.PrepareRead(nil)
+		for {
+			if _, err := session.ReadMessage(); err != nil {
+				result <- err
+				return
+			}
+		}
+	}()
+	return result
+}
+
+func TestSession_ReapsUnresponsiveClient(t *testing.T) {
+	serverConn, _ := newConnPair(t)
+
+	// The client never reads, so it never answers pings.
+	session := NewSession(1, "", serverConn, heartbeatConfig())
+	go session.WritePump()
+
+	select {
+	case err := <-readUntilError(session):
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("unresponsive connection was not reaped")
+	}
+}
+
+func TestSession_PongsKeepConnectionAlive(t *testing.T) {
+	serverConn, client := newConnPair(t)
+
+	session := NewSession(1, "", serverConn, heartbeatConfig())
+	go session.WritePump()
+	readErr := readUntilError(session)
+
+	// Reading lets the client's default ping handler answer with pongs.
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-readErr:
+		t.Fatalf("healthy connection was closed: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+	session.Close()
+}
+
+func TestSession_RejectsOversizedFrames(t *testing.T) {
+	serverConn, client := newConnPair(t)
+
+	session := NewSession(1, "", serverConn, heartbeatConfig())
+	readErr := readUntilError(session)
+
+	require.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 64))))
+
+	select {
+	case err := <-readErr:
+		assert.ErrorIs(t, err, websocket.ErrReadLimit)
+	case <-time.After(2 * time.Second):
+		t.Fatal("oversized frame was accepted")
+	}
+}
+
+func TestSession_ResumeReplaysBeforeHeldFrames(t *testing.T) {
+	serverConn, client := newConnPair(t)
+
+	session := NewSession(1, "", serverConn, DefaultConfig())
+	session.Hold()
+	go session.WritePump()
+
+	session.Send([]byte("live-duplicate"))
+	session.Send([]byte("live"))
+
+	session.Resume([][]byte{[]byte("missed-1"), []byte("missed-2")}, func(data []byte) bool {
+		return string(data) == "live-duplicate"
+	})
+
+	var received []string
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	for range 3 {
+		_, data, err := client.ReadMessage()
+		require.NoError(t, err)
+		received = append(received, string(data))
+	}
+	assert.Equal(t, []string{"missed-1", "missed-2", "live"}, received)
+}
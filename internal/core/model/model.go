@@ -1,6 +1,11 @@
 package model
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
 
 type Conversation struct {
 	ID        int64     `json:"id" db:"id"`
@@ -9,33 +14,266 @@ type Conversation struct {
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
 type Participant struct {
-	ConversationID int64     `json:"conversation_id" db:"conversation_id"`
-	UserID         int64     `json:"user_id" db:"user_id"`
-	JoinedAt       time.Time `json:"joined_at" db:"joined_at"`
+	ConversationID    int64     `json:"conversation_id" db:"conversation_id"`
+	UserID            int64     `json:"user_id" db:"user_id"`
+	Role              string    `json:"role" db:"role"`
+	JoinedAt          time.Time `json:"joined_at" db:"joined_at"`
+	LastReadMessageID int64     `json:"last_read_message_id" db:"last_read_message_id"`
+}
+
+func (p *Participant) IsAdmin() bool {
+	return p.Role == RoleOwner || p.Role == RoleAdmin
 }
 
 type Message struct {
-	ID             int64      `json:"id" db:"id"`
+	ID               int64           `json:"id" db:"id"`
+	ConversationID   int64           `json:"conversation_id" db:"conversation_id"`
+	SenderID         int64           `json:"sender_id" db:"sender_id"`
+	Content          string          `json:"content" db:"content"`
+	MessageType      string          `json:"message_type" db:"message_type"`
+	FileURL          *string         `json:"file_url,omitempty" db:"file_url"`
+	FileName         *string         `json:"file_name,omitempty" db:"file_name"`
+	FileSize         *int64          `json:"file_size,omitempty" db:"file_size"`
+	MimeType         *string         `json:"mime_type,omitempty" db:"mime_type"`
+	CreatedAt        time.Time       `json:"created_at" db:"created_at"`
+	EditedAt         *time.Time      `json:"edited_at,omitempty" db:"edited_at"`
+	DeletedAt        *time.Time      `json:"deleted_at,omitempty" db:"deleted_at"`
+	ReplyToMessageID *int64          `json:"reply_to_message_id,omitempty" db:"reply_to_message_id"`
+	ReplyTo          *MessagePreview `json:"reply_to,omitempty" db:"-"`
+	ThreadRootID     *int64          `json:"thread_root_id,omitempty" db:"thread_root_id"`
+	ClientMsgID      *string         `json:"client_msg_id,omitempty" db:"client_msg_id"`
+	Mentions         []int64         `json:"mentions,omitempty" db:"-"`
+	ReplyCount       int             `json:"reply_count,omitempty" db:"-"`
+	LastReplyAt      *time.Time      `json:"last_reply_at,omitempty" db:"-"`
+	DeliveredTo      []int64         `json:"delivered_to,omitempty" db:"-"`
+	ReadBy           []int64         `json:"read_by,omitempty" db:"-"`
+	Reactions        []Reaction      `json:"reactions,omitempty" db:"-"`
+	ForwardedFrom
+	ImageInfo
+	FileRef
+}
+
+// previewContentLength is the number of runes of content kept in a quoted
+// message preview.
+const previewContentLength = 100
+
+// ForwardedFrom records where a forwarded message originally came from. The
+// conversation and message are omitted when the forwarding user could not see
+// them, e.g. when forwarding a forward out of a conversation they never joined.
+type ForwardedFrom struct {
+	ForwardedFromMessageID      *int64 `json:"forwarded_from_message_id,omitempty" db:"forwarded_from_message_id"`
+	ForwardedFromSenderID       *int64 `json:"forwarded_from_sender_id,omitempty" db:"forwarded_from_sender_id"`
+	ForwardedFromConversationID *int64 `json:"forwarded_from_conversation_id,omitempty" db:"forwarded_from_conversation_id"`
+}
+
+// IsForwarded reports whether the message is a forwarded copy.
+func (f ForwardedFrom) IsForwarded() bool {
+	return f.ForwardedFromSenderID != nil
+}
+
+// ImageInfo describes the file of an image message: its dimensions, a
+// blurhash placeholder to show while loading and scaled down copies.
+type ImageInfo struct {
+	ImageWidth  *int       `json:"image_width,omitempty" db:"image_width"`
+	ImageHeight *int       `json:"image_height,omitempty" db:"image_height"`
+	Blurhash    *string    `json:"blurhash,omitempty" db:"blurhash"`
+	Thumbnails  Thumbnails `json:"thumbnails,omitempty" db:"thumbnails"`
+}
+
+// File scan statuses. A message whose file is pending is held back from its
+// recipients until the scan finds the file clean.
+const (
+	FileStatusPending  = "pending"
+	FileStatusClean    = "clean"
+	FileStatusInfected = "infected"
+)
+
+// FileRef points a message at the stored object behind its file and the
+// object's scan status. Messages with a file given only by URL have none.
+type FileRef struct {
+	FileBucket *string `json:"-" db:"file_bucket"`
+	FileObject *string `json:"-" db:"file_object"`
+	FileStatus *string `json:"file_status,omitempty" db:"file_status"`
+}
+
+// Attachment names an uploaded file a message is sent with, as returned by
+// the upload endpoints. Everything else about the file, such as the
+// dimensions of an image, is taken from what was recorded when it was stored.
+type Attachment struct {
+	Bucket     string `json:"bucket,omitempty"`
+	ObjectName string `json:"object_name,omitempty"`
+}
+
+// Thumbnail is a scaled down copy of an image message. Object is the stored
+// thumbnail; URL is signed for it whenever the message is read.
+type Thumbnail struct {
+	Name   string `json:"name"`
+	Object string `json:"-"`
+	URL    string `json:"url,omitempty"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// storedThumbnail is the form a thumbnail is stored in. Thumbnails stored
+// before they were kept by object name only have a URL.
+type storedThumbnail struct {
+	Name   string `json:"name"`
+	Object string `json:"object,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Thumbnails is stored as a JSON array.
+type Thumbnails []Thumbnail
+
+func (t Thumbnails) Value() (driver.Value, error) {
+	if t == nil {
+		return nil, nil
+	}
+	stored := make([]storedThumbnail, len(t))
+	for i, thumb := range t {
+		stored[i] = storedThumbnail{Name: thumb.Name, Object: thumb.Object, Width: thumb.Width, Height: thumb.Height}
+		if thumb.Object == "" {
+			stored[i].URL = thumb.URL
+		}
+	}
+	return json.Marshal(stored)
+}
+
+func (t *Thumbnails) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("unsupported type for thumbnails")
+	}
+
+	var stored []storedThumbnail
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	*t = make(Thumbnails, len(stored))
+	for i, thumb := range stored {
+		(*t)[i] = Thumbnail{Name: thumb.Name, Object: thumb.Object, URL: thumb.URL, Width: thumb.Width, Height: thumb.Height}
+	}
+	return nil
+}
+
+// MessagePreview is the compact form of a quoted message embedded in replies.
+type MessagePreview struct {
+	ID          int64  `json:"id"`
+	SenderID    int64  `json:"sender_id"`
+	Content     string `json:"content"`
+	MessageType string `json:"message_type"`
+	IsDeleted   bool   `json:"is_deleted"`
+}
+
+func (m *Message) Preview() *MessagePreview {
+	preview := &MessagePreview{
+		ID:          m.ID,
+		SenderID:    m.SenderID,
+		MessageType: m.MessageType,
+		IsDeleted:   m.DeletedAt != nil,
+	}
+	if preview.IsDeleted {
+		return preview
+	}
+
+	content := []rune(m.Content)
+	if len(content) > previewContentLength {
+		preview.Content = string(content[:previewContentLength]) + "…"
+	} else {
+		preview.Content = m.Content
+	}
+	return preview
+}
+
+// MessageCursor selects a keyset page of history. At most one field is set;
+// the zero value means the most recent page.
+type MessageCursor struct {
+	BeforeID int64 `json:"before_id,omitempty"`
+	AfterID  int64 `json:"after_id,omitempty"`
+	AroundID int64 `json:"around_id,omitempty"`
+}
+
+// MessageSearch describes a full-text search over the conversations a user
+// participates in. Zero-valued filters are ignored.
+type MessageSearch struct {
+	Query          string     `json:"query"`
+	ConversationID int64      `json:"conversation_id,omitempty"`
+	SenderID       int64      `json:"sender_id,omitempty"`
+	MessageType    string     `json:"message_type,omitempty"`
+	From           *time.Time `json:"from,omitempty"`
+	To             *time.Time `json:"to,omitempty"`
+	BeforeID       int64      `json:"before_id,omitempty"`
+}
+
+// MessageSearchResult carries an HTML snippet of the message: its content is
+// escaped and matched terms are wrapped in <mark>.
+type MessageSearchResult struct {
+	Message
+	Snippet string `json:"snippet" db:"snippet"`
+}
+
+type MessageSearchPage struct {
+	Results    []MessageSearchResult `json:"results"`
+	NextCursor *int64                `json:"next_cursor,omitempty"`
+}
+
+// MessagePage is returned newest first. NextCursor is passed back as
+// before_id to load older messages and PrevCursor as after_id to load newer.
+// Root is only set on thread pages.
+type MessagePage struct {
+	Root       *Message  `json:"root,omitempty"`
+	Messages   []Message `json:"messages"`
+	NextCursor *int64    `json:"next_cursor,omitempty"`
+	PrevCursor *int64    `json:"prev_cursor,omitempty"`
+}
+
+type ThreadSummary struct {
+	RootMessageID  int64      `json:"root_message_id" db:"root_message_id"`
 	ConversationID int64      `json:"conversation_id" db:"conversation_id"`
-	SenderID       int64      `json:"sender_id" db:"sender_id"`
-	Content        string     `json:"content" db:"content"`
-	MessageType    string     `json:"message_type" db:"message_type"`
-	FileURL        *string    `json:"file_url,omitempty" db:"file_url"`
-	FileName       *string    `json:"file_name,omitempty" db:"file_name"`
-	FileSize       *int64     `json:"file_size,omitempty" db:"file_size"`
-	MimeType       *string    `json:"mime_type,omitempty" db:"mime_type"`
-	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
-	EditedAt       *time.Time `json:"edited_at,omitempty" db:"edited_at"`
-	DeletedAt      *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
-	ReadBy         []int64    `json:"read_by,omitempty" db:"-"`
-	Reactions      []Reaction `json:"reactions,omitempty" db:"-"`
+	Root           *Message   `json:"root,omitempty" db:"-"`
+	ReplyCount     int        `json:"reply_count" db:"reply_count"`
+	LastReplyAt    *time.Time `json:"last_reply_at,omitempty" db:"last_reply_at"`
+	UnreadCount    int        `json:"unread_count" db:"unread_count"`
 }
 
+// MessageRead is a read receipt: UserID has read every message of the
+// conversation up to and including MessageID.
 type MessageRead struct {
-	MessageID int64     `json:"message_id" db:"message_id"`
-	UserID    int64     `json:"user_id" db:"user_id"`
-	ReadAt    time.Time `json:"read_at" db:"read_at"`
+	ConversationID int64     `json:"conversation_id" db:"conversation_id"`
+	MessageID      int64     `json:"message_id" db:"message_id"`
+	UserID         int64     `json:"user_id" db:"user_id"`
+	ReadAt         time.Time `json:"read_at" db:"read_at"`
+}
+
+// MessageDelivery records that a message reached at least one device of a
+// recipient.
+type MessageDelivery struct {
+	MessageID   int64     `json:"message_id" db:"message_id"`
+	UserID      int64     `json:"user_id" db:"user_id"`
+	DeliveredAt time.Time `json:"delivered_at" db:"delivered_at"`
+	SenderID    int64     `json:"-" db:"sender_id"`
+}
+
+// AckEvent is sent by clients over WebSocket for messages they received.
+type AckEvent struct {
+	MessageIDs []int64 `json:"message_ids"`
 }
 
 type Reaction struct {
@@ -53,6 +291,8 @@ type ConversationWithLastMessage struct {
 	CreatedAt      time.Time `json:"created_at" db:"created_at"`
 	LastMessage    *Message  `json:"last_message,omitempty"`
 	UnreadCount    int       `json:"unread_count"`
+	UnreadMentions int       `json:"unread_mentions"`
+	PinnedMessage  *Message  `json:"pinned_message,omitempty"`
 	ParticipantIDs []int64   `json:"participant_ids,omitempty"`
 }
 
@@ -64,12 +304,162 @@ type TypingEvent struct {
 }
 
 type OnlineStatusEvent struct {
-	UserID   int64  `json:"user_id"`
-	Username string `json:"username"`
-	Status   string `json:"status"` // online, offline, away
+	UserID     int64      `json:"user_id"`
+	Username   string     `json:"username"`
+	Status     string     `json:"status"` // online, offline, away
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+// Presence visibility settings: who may see a user's status and last seen.
+const (
+	VisibilityEveryone = "everyone"
+	VisibilityContacts = "contacts"
+	VisibilityNobody   = "nobody"
+)
+
+type PresenceSettings struct {
+	UserID     int64      `json:"user_id" db:"user_id"`
+	Visibility string     `json:"visibility" db:"visibility"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty" db:"last_seen_at"`
+}
+
+// Presence is a user's status as seen by another user. Hidden is set, and
+// status and last seen left empty, when the user's privacy setting does not
+// allow the viewer to see them.
+type Presence struct {
+	UserID     int64      `json:"user_id"`
+	Status     string     `json:"status,omitempty"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	Hidden     bool       `json:"hidden,omitempty"`
+}
+
+// SessionInfo is sent to a client right after it connects so it can tell its
+// own session apart from the user's other devices.
+type SessionInfo struct {
+	SessionID string `json:"session_id"`
+	DeviceID  string `json:"device_id"`
+}
+
+type ParticipantEvent struct {
+	ConversationID int64  `json:"conversation_id"`
+	UserID         int64  `json:"user_id"`
+	ActorID        int64  `json:"actor_id"`
+	Role           string `json:"role,omitempty"`
+}
+
+type PinnedMessage struct {
+	ConversationID int64     `json:"conversation_id" db:"conversation_id"`
+	MessageID      int64     `json:"message_id" db:"message_id"`
+	PinnedBy       int64     `json:"pinned_by" db:"pinned_by"`
+	PinnedAt       time.Time `json:"pinned_at" db:"pinned_at"`
+	Message        *Message  `json:"message,omitempty" db:"-"`
+}
+
+// UnpinnedMessage is the payload of a message_unpinned event.
+type UnpinnedMessage struct {
+	ConversationID int64     `json:"conversation_id"`
+	MessageID      int64     `json:"message_id"`
+	UnpinnedBy     int64     `json:"unpinned_by"`
+	UnpinnedAt     time.Time `json:"unpinned_at"`
+}
+
+// CatchUpResult ends the replay of missed events after a reconnect. When
+// Complete is false more events were missed than can be replayed and the
+// client should reload its conversations instead.
+type CatchUpResult struct {
+	LastEventID int64 `json:"last_event_id"`
+	Complete    bool  `json:"complete"`
 }
 
 type WSMessage struct {
-	Type    string      `json:"type"` // message, typing, status, reaction, read_receipt
+	Type    string      `json:"type"` // session, message, typing, status, reaction, read_receipt, participant_added, participant_removed, participant_role_changed, thread_reply, mention, message_pinned, message_unpinned, file_infected, caught_up, ack, delivered, send_message, edit, delete, react, unreact, mark_read, mark_read_up_to, response
 	Payload interface{} `json:"payload"`
+	// EventID is the recipient's sequence number of a durable event. A
+	// reconnecting client sends the last one it saw to catch up.
+	EventID int64 `json:"event_id,omitempty"`
+	// RequestID ties a client request to the response frame answering it.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// WSResponse is the payload of the response frame sent for every client
+// request, carrying the request's result or why it failed.
+type WSResponse struct {
+	OK    bool        `json:"ok"`
+	Error string      `json:"error,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// FileUpload is a resumable upload in progress. Its chunks are stored as the
+// parts of a multipart upload and Offset counts the bytes received so far.
+type FileUpload struct {
+	ID          string    `json:"id" db:"id"`
+	UserID      int64     `json:"user_id" db:"user_id"`
+	Bucket      string    `json:"bucket" db:"bucket"`
+	ObjectName  string    `json:"object_name" db:"object_name"`
+	MultipartID string    `json:"-" db:"multipart_id"`
+	FileName    string    `json:"file_name" db:"file_name"`
+	MimeType    string    `json:"mime_type" db:"mime_type"`
+	Size        int64     `json:"size" db:"size"`
+	Offset      int64     `json:"offset" db:"upload_offset"`
+	PartCount   int       `json:"-" db:"part_count"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// Done reports whether every byte of the file has been received.
+func (u *FileUpload) Done() bool {
+	return u.Offset == u.Size
+}
+
+// PresignedUpload is a file a client uploads straight to storage. It lands
+// under a staging key the presigned request is bound to, and is copied to a
+// key of the server's choosing once finalized, so the request cannot replace
+// the file after it has been checked.
+type PresignedUpload struct {
+	ID            string    `json:"id" db:"id"`
+	UserID        int64     `json:"user_id" db:"user_id"`
+	Bucket        string    `json:"bucket" db:"bucket"`
+	StagingObject string    `json:"-" db:"staging_object"`
+	FileName      string    `json:"file_name" db:"file_name"`
+	MimeType      string    `json:"mime_type" db:"mime_type"`
+	Finalized     bool      `json:"-" db:"finalized"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// FileScan tracks the malware scan of an uploaded object. Signature names
+// what was found in an infected file. ImageInfo describes an image as it was
+// processed when stored; messages sent with the object take it from here.
+type FileScan struct {
+	Bucket     string    `json:"bucket" db:"bucket"`
+	ObjectName string    `json:"object_name" db:"object_name"`
+	UserID     int64     `json:"user_id" db:"user_id"`
+	FileName   string    `json:"file_name" db:"file_name"`
+	Status     string    `json:"status" db:"status"`
+	Signature  *string   `json:"signature,omitempty" db:"signature"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+	ImageInfo
+}
+
+// ScanResult is a scanner's verdict on a file.
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
+
+// FileInfectedEvent tells the uploader of an infected file that it was
+// deleted, together with the messages that were sent with it.
+type FileInfectedEvent struct {
+	Bucket     string  `json:"bucket"`
+	ObjectName string  `json:"object_name"`
+	FileName   string  `json:"file_name"`
+	Signature  string  `json:"signature"`
+	MessageIDs []int64 `json:"message_ids,omitempty"`
 }
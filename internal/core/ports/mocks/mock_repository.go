@@ -2,6 +2,7 @@ package mocks
 
 import (
 	"context"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
@@ -38,11 +39,21 @@ func (m *MockChatRepository) GetUserConversations(ctx context.Context, userID in
 	return args.Get(0).([]model.ConversationWithLastMessage), args.Error(1)
 }
 
+func (m *MockChatRepository) UpdateConversationName(ctx context.Context, id int64, name string) error {
+	args := m.Called(ctx, id, name)
+	return args.Error(0)
+}
+
 func (m *MockChatRepository) AddParticipant(ctx context.Context, part *model.Participant) error {
 	args := m.Called(ctx, part)
 	return args.Error(0)
 }
 
+func (m *MockChatRepository) RemoveParticipant(ctx context.Context, convID, userID int64) error {
+	args := m.Called(ctx, convID, userID)
+	return args.Error(0)
+}
+
 func (m *MockChatRepository) GetParticipants(ctx context.Context, conversationID int64) ([]int64, error) {
 	args := m.Called(ctx, conversationID)
 	return args.Get(0).([]int64), args.Error(1)
@@ -53,14 +64,47 @@ func (m *MockChatRepository) IsParticipant(ctx context.Context, convID, userID i
 	return args.Bool(0), args.Error(1)
 }
 
+func (m *MockChatRepository) GetParticipant(ctx context.Context, convID, userID int64) (*model.Participant, error) {
+	args := m.Called(ctx, convID, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Participant), args.Error(1)
+}
+
+func (m *MockChatRepository) ListParticipants(ctx context.Context, convID int64) ([]model.Participant, error) {
+	args := m.Called(ctx, convID)
+	return args.Get(0).([]model.Participant), args.Error(1)
+}
+
+func (m *MockChatRepository) UpdateParticipantRole(ctx context.Context, convID, userID int64, role string) error {
+	args := m.Called(ctx, convID, userID, role)
+	return args.Error(0)
+}
+
+func (m *MockChatRepository) TransferOwnership(ctx context.Context, convID, ownerID, newOwnerID int64) error {
+	args := m.Called(ctx, convID, ownerID, newOwnerID)
+	return args.Error(0)
+}
+
+func (m *MockChatRepository) ClaimOwnership(ctx context.Context, convID, userID int64) (bool, error) {
+	args := m.Called(ctx, convID, userID)
+	return args.Bool(0), args.Error(1)
+}
+
 func (m *MockChatRepository) SaveMessage(ctx context.Context, msg *model.Message) error {
 	args := m.Called(ctx, msg)
 	msg.ID = 42
 	return args.Error(0)
 }
 
-func (m *MockChatRepository) GetMessages(ctx context.Context, conversationID int64, limit, offset int) ([]model.Message, error) {
-	args := m.Called(ctx, conversationID, limit, offset)
+func (m *MockChatRepository) GetMessages(ctx context.Context, conversationID, userID, beforeID, afterID int64, limit int) ([]model.Message, error) {
+	args := m.Called(ctx, conversationID, userID, beforeID, afterID, limit)
+	return args.Get(0).([]model.Message), args.Error(1)
+}
+
+func (m *MockChatRepository) GetThreadMessages(ctx context.Context, rootMessageID, userID, beforeID, afterID int64, limit int) ([]model.Message, error) {
+	args := m.Called(ctx, rootMessageID, userID, beforeID, afterID, limit)
 	return args.Get(0).([]model.Message), args.Error(1)
 }
 
@@ -72,6 +116,14 @@ func (m *MockChatRepository) GetMessageByID(ctx context.Context, messageID int64
 	return args.Get(0).(*model.Message), args.Error(1)
 }
 
+func (m *MockChatRepository) GetMessageByClientID(ctx context.Context, conversationID, senderID int64, clientMsgID string) (*model.Message, error) {
+	args := m.Called(ctx, conversationID, senderID, clientMsgID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Message), args.Error(1)
+}
+
 func (m *MockChatRepository) GetLastMessage(ctx context.Context, conversationID int64) (*model.Message, error) {
 	args := m.Called(ctx, conversationID)
 	if args.Get(0) == nil {
@@ -90,9 +142,9 @@ func (m *MockChatRepository) DeleteMessage(ctx context.Context, messageID int64)
 	return args.Error(0)
 }
 
-func (m *MockChatRepository) MarkMessageAsRead(ctx context.Context, messageID, userID int64) error {
-	args := m.Called(ctx, messageID, userID)
-	return args.Error(0)
+func (m *MockChatRepository) MarkReadUpTo(ctx context.Context, conversationID, userID, messageID int64) (bool, error) {
+	args := m.Called(ctx, conversationID, userID, messageID)
+	return args.Bool(0), args.Error(1)
 }
 
 func (m *MockChatRepository) GetMessageReads(ctx context.Context, messageID int64) ([]int64, error) {
@@ -100,6 +152,37 @@ func (m *MockChatRepository) GetMessageReads(ctx context.Context, messageID int6
 	return args.Get(0).([]int64), args.Error(1)
 }
 
+func (m *MockChatRepository) MarkMessagesDelivered(ctx context.Context, userID int64, messageIDs []int64) ([]model.MessageDelivery, error) {
+	args := m.Called(ctx, userID, messageIDs)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.MessageDelivery), args.Error(1)
+}
+
+func (m *MockChatRepository) GetUndeliveredMessages(ctx context.Context, userID int64, since time.Time, limit int) ([]model.Message, error) {
+	args := m.Called(ctx, userID, since, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.Message), args.Error(1)
+}
+
+func (m *MockChatRepository) JoinThread(ctx context.Context, rootMessageID, userID int64) error {
+	args := m.Called(ctx, rootMessageID, userID)
+	return args.Error(0)
+}
+
+func (m *MockChatRepository) MarkThreadRead(ctx context.Context, rootMessageID, userID, messageID int64) error {
+	args := m.Called(ctx, rootMessageID, userID, messageID)
+	return args.Error(0)
+}
+
+func (m *MockChatRepository) GetUserThreads(ctx context.Context, userID, conversationID int64, limit int) ([]model.ThreadSummary, error) {
+	args := m.Called(ctx, userID, conversationID, limit)
+	return args.Get(0).([]model.ThreadSummary), args.Error(1)
+}
+
 func (m *MockChatRepository) AddReaction(ctx context.Context, messageID, userID int64, reaction string) error {
 	args := m.Called(ctx, messageID, userID, reaction)
 	return args.Error(0)
@@ -114,3 +197,161 @@ func (m *MockChatRepository) GetMessageReactions(ctx context.Context, messageID
 	args := m.Called(ctx, messageID)
 	return args.Get(0).([]model.Reaction), args.Error(1)
 }
+
+func (m *MockChatRepository) SetMessageMentions(ctx context.Context, messageID int64, userIDs []int64) error {
+	args := m.Called(ctx, messageID, userIDs)
+	return args.Error(0)
+}
+
+func (m *MockChatRepository) PinMessage(ctx context.Context, pin *model.PinnedMessage) error {
+	args := m.Called(ctx, pin)
+	return args.Error(0)
+}
+
+func (m *MockChatRepository) UnpinMessage(ctx context.Context, conversationID, messageID int64) error {
+	args := m.Called(ctx, conversationID, messageID)
+	return args.Error(0)
+}
+
+func (m *MockChatRepository) GetPinnedMessages(ctx context.Context, conversationID int64) ([]model.PinnedMessage, error) {
+	args := m.Called(ctx, conversationID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.PinnedMessage), args.Error(1)
+}
+
+func (m *MockChatRepository) SearchMessages(ctx context.Context, userID int64, search model.MessageSearch, limit int) ([]model.MessageSearchResult, error) {
+	args := m.Called(ctx, userID, search, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.MessageSearchResult), args.Error(1)
+}
+
+func (m *MockChatRepository) GetContacts(ctx context.Context, userID int64) ([]int64, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]int64), args.Error(1)
+}
+
+func (m *MockChatRepository) GetPresenceSettings(ctx context.Context, userIDs []int64) (map[int64]model.PresenceSettings, error) {
+	args := m.Called(ctx, userIDs)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[int64]model.PresenceSettings), args.Error(1)
+}
+
+func (m *MockChatRepository) SetPresenceVisibility(ctx context.Context, userID int64, visibility string) error {
+	args := m.Called(ctx, userID, visibility)
+	return args.Error(0)
+}
+
+func (m *MockChatRepository) UpdateLastSeen(ctx context.Context, userID int64, lastSeenAt time.Time) error {
+	args := m.Called(ctx, userID, lastSeenAt)
+	return args.Error(0)
+}
+
+func (m *MockChatRepository) CreateUpload(ctx context.Context, upload *model.FileUpload) error {
+	args := m.Called(ctx, upload)
+	return args.Error(0)
+}
+
+func (m *MockChatRepository) GetUpload(ctx context.Context, id string) (*model.FileUpload, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.FileUpload), args.Error(1)
+}
+
+func (m *MockChatRepository) AdvanceUpload(ctx context.Context, id string, fromOffset, toOffset int64, expiresAt time.Time) (bool, error) {
+	args := m.Called(ctx, id, fromOffset, toOffset, expiresAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockChatRepository) DeleteUpload(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockChatRepository) GetExpiredUploads(ctx context.Context, before time.Time, limit int) ([]model.FileUpload, error) {
+	args := m.Called(ctx, before, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.FileUpload), args.Error(1)
+}
+
+func (m *MockChatRepository) CreatePresignedUpload(ctx context.Context, upload *model.PresignedUpload) error {
+	args := m.Called(ctx, upload)
+	return args.Error(0)
+}
+
+func (m *MockChatRepository) GetPresignedUpload(ctx context.Context, id string) (*model.PresignedUpload, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.PresignedUpload), args.Error(1)
+}
+
+func (m *MockChatRepository) FinalizePresignedUpload(ctx context.Context, id string) (bool, error) {
+	args := m.Called(ctx, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockChatRepository) DeletePresignedUpload(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockChatRepository) GetExpiredPresignedUploads(ctx context.Context, before time.Time, limit int) ([]model.PresignedUpload, error) {
+	args := m.Called(ctx, before, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.PresignedUpload), args.Error(1)
+}
+
+func (m *MockChatRepository) CreateFileScan(ctx context.Context, scan *model.FileScan) error {
+	args := m.Called(ctx, scan)
+	return args.Error(0)
+}
+
+func (m *MockChatRepository) GetFileScan(ctx context.Context, bucket, objectName string) (*model.FileScan, error) {
+	args := m.Called(ctx, bucket, objectName)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.FileScan), args.Error(1)
+}
+
+func (m *MockChatRepository) DeleteFileScan(ctx context.Context, bucket, objectName string) error {
+	args := m.Called(ctx, bucket, objectName)
+	return args.Error(0)
+}
+
+func (m *MockChatRepository) FinishFileScan(ctx context.Context, bucket, objectName, status string, signature *string) (bool, error) {
+	args := m.Called(ctx, bucket, objectName, status, signature)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockChatRepository) GetPendingFileScans(ctx context.Context, before time.Time, limit int) ([]model.FileScan, error) {
+	args := m.Called(ctx, before, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.FileScan), args.Error(1)
+}
+
+func (m *MockChatRepository) SetMessagesFileStatus(ctx context.Context, bucket, objectName, status string) ([]int64, error) {
+	args := m.Called(ctx, bucket, objectName, status)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]int64), args.Error(1)
+}
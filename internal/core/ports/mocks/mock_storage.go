@@ -0,0 +1,105 @@
+package mocks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+)
+
+type MockMultipartStorage struct {
+	mock.Mock
+}
+
+func (m *MockMultipartStorage) NewMultipartUpload(ctx context.Context, bucket, objectName, contentType string) (string, error) {
+	args := m.Called(ctx, bucket, objectName, contentType)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockMultipartStorage) UploadPart(ctx context.Context, bucket, objectName, uploadID string, partNumber int, reader io.Reader, size int64) error {
+	args := m.Called(ctx, bucket, objectName, uploadID, partNumber, reader, size)
+	return args.Error(0)
+}
+
+func (m *MockMultipartStorage) CompleteMultipartUpload(ctx context.Context, bucket, objectName, uploadID string) error {
+	args := m.Called(ctx, bucket, objectName, uploadID)
+	return args.Error(0)
+}
+
+func (m *MockMultipartStorage) AbortMultipartUpload(ctx context.Context, bucket, objectName, uploadID string) error {
+	args := m.Called(ctx, bucket, objectName, uploadID)
+	return args.Error(0)
+}
+
+type MockDirectUploadStorage struct {
+	mock.Mock
+}
+
+func (m *MockDirectUploadStorage) PresignedPutURL(ctx context.Context, bucket, objectName, contentType string, size int64, expires time.Duration) (string, http.Header, error) {
+	args := m.Called(ctx, bucket, objectName, contentType, size, expires)
+	if args.Get(1) == nil {
+		return args.String(0), nil, args.Error(2)
+	}
+	return args.String(0), args.Get(1).(http.Header), args.Error(2)
+}
+
+func (m *MockDirectUploadStorage) PresignedPostPolicy(ctx context.Context, bucket, objectName, contentType string, maxSize int64, expires time.Duration) (string, map[string]string, error) {
+	args := m.Called(ctx, bucket, objectName, contentType, maxSize, expires)
+	if args.Get(1) == nil {
+		return args.String(0), nil, args.Error(2)
+	}
+	return args.String(0), args.Get(1).(map[string]string), args.Error(2)
+}
+
+func (m *MockDirectUploadStorage) FileSize(ctx context.Context, bucket, objectName string) (int64, error) {
+	args := m.Called(ctx, bucket, objectName)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDirectUploadStorage) CopyFile(ctx context.Context, bucket, srcObject, dstObject string) error {
+	args := m.Called(ctx, bucket, srcObject, dstObject)
+	return args.Error(0)
+}
+
+func (m *MockDirectUploadStorage) DeleteFile(ctx context.Context, bucket, objectName string) error {
+	args := m.Called(ctx, bucket, objectName)
+	return args.Error(0)
+}
+
+type MockObjectStorage struct {
+	mock.Mock
+}
+
+func (m *MockObjectStorage) GetFile(ctx context.Context, bucket, objectName string) (io.ReadCloser, error) {
+	args := m.Called(ctx, bucket, objectName)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(io.ReadCloser), args.Error(1)
+}
+
+func (m *MockObjectStorage) DeleteFile(ctx context.Context, bucket, objectName string) error {
+	args := m.Called(ctx, bucket, objectName)
+	return args.Error(0)
+}
+
+type MockFileURLSigner struct {
+	mock.Mock
+}
+
+func (m *MockFileURLSigner) GetFileURL(ctx context.Context, bucket, objectName string, expires time.Duration) (string, error) {
+	args := m.Called(ctx, bucket, objectName, expires)
+	return args.String(0), args.Error(1)
+}
+
+type MockFileScanner struct {
+	mock.Mock
+}
+
+func (m *MockFileScanner) Scan(ctx context.Context, r io.Reader) (model.ScanResult, error) {
+	args := m.Called(ctx, r)
+	return args.Get(0).(model.ScanResult), args.Error(1)
+}
@@ -2,33 +2,92 @@ package ports
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
 )
 
+// ErrDuplicateMessage is returned by SaveMessage when the sender already
+// stored a message with the same client_msg_id in the conversation.
+var ErrDuplicateMessage = errors.New("message with this client_msg_id already exists")
+
 type ChatRepository interface {
 	// Conversations
 	CreateConversation(ctx context.Context, conv *model.Conversation) error
 	GetConversationByID(ctx context.Context, id int64) (*model.Conversation, error)
 	FindOneToOneConversation(ctx context.Context, user1, user2 int64) (*model.Conversation, error)
 	GetUserConversations(ctx context.Context, userID int64, limit, offset int) ([]model.ConversationWithLastMessage, error)
+	UpdateConversationName(ctx context.Context, id int64, name string) error
 
 	// Participants
 	AddParticipant(ctx context.Context, part *model.Participant) error
+	RemoveParticipant(ctx context.Context, convID, userID int64) error
 	GetParticipants(ctx context.Context, conversationID int64) ([]int64, error)
 	IsParticipant(ctx context.Context, convID, userID int64) (bool, error)
+	GetParticipant(ctx context.Context, convID, userID int64) (*model.Participant, error)
+	ListParticipants(ctx context.Context, convID int64) ([]model.Participant, error)
+	UpdateParticipantRole(ctx context.Context, convID, userID int64, role string) error
+	TransferOwnership(ctx context.Context, convID, ownerID, newOwnerID int64) error
+	ClaimOwnership(ctx context.Context, convID, userID int64) (bool, error)
+	GetContacts(ctx context.Context, userID int64) ([]int64, error)
 
 	// Messages
 	SaveMessage(ctx context.Context, msg *model.Message) error
-	GetMessages(ctx context.Context, conversationID int64, limit, offset int) ([]model.Message, error)
+	GetMessages(ctx context.Context, conversationID, userID, beforeID, afterID int64, limit int) ([]model.Message, error)
+	GetThreadMessages(ctx context.Context, rootMessageID, userID, beforeID, afterID int64, limit int) ([]model.Message, error)
 	GetMessageByID(ctx context.Context, messageID int64) (*model.Message, error)
+	GetMessageByClientID(ctx context.Context, conversationID, senderID int64, clientMsgID string) (*model.Message, error)
 	GetLastMessage(ctx context.Context, conversationID int64) (*model.Message, error)
 	EditMessage(ctx context.Context, messageID int64, newContent string) error
 	DeleteMessage(ctx context.Context, messageID int64) error
+	SearchMessages(ctx context.Context, userID int64, search model.MessageSearch, limit int) ([]model.MessageSearchResult, error)
+
+	// Mentions
+	SetMessageMentions(ctx context.Context, messageID int64, userIDs []int64) error
+
+	// Pins
+	PinMessage(ctx context.Context, pin *model.PinnedMessage) error
+	UnpinMessage(ctx context.Context, conversationID, messageID int64) error
+	GetPinnedMessages(ctx context.Context, conversationID int64) ([]model.PinnedMessage, error)
 
 	// Read Receipts
-	MarkMessageAsRead(ctx context.Context, messageID, userID int64) error
+	MarkReadUpTo(ctx context.Context, conversationID, userID, messageID int64) (bool, error)
 	GetMessageReads(ctx context.Context, messageID int64) ([]int64, error)
+	MarkMessagesDelivered(ctx context.Context, userID int64, messageIDs []int64) ([]model.MessageDelivery, error)
+	GetUndeliveredMessages(ctx context.Context, userID int64, since time.Time, limit int) ([]model.Message, error)
+
+	// Threads
+	JoinThread(ctx context.Context, rootMessageID, userID int64) error
+	MarkThreadRead(ctx context.Context, rootMessageID, userID, messageID int64) error
+	GetUserThreads(ctx context.Context, userID, conversationID int64, limit int) ([]model.ThreadSummary, error)
+
+	// Presence
+	GetPresenceSettings(ctx context.Context, userIDs []int64) (map[int64]model.PresenceSettings, error)
+	SetPresenceVisibility(ctx context.Context, userID int64, visibility string) error
+	UpdateLastSeen(ctx context.Context, userID int64, lastSeenAt time.Time) error
+
+	// Uploads
+	CreateUpload(ctx context.Context, upload *model.FileUpload) error
+	GetUpload(ctx context.Context, id string) (*model.FileUpload, error)
+	AdvanceUpload(ctx context.Context, id string, fromOffset, toOffset int64, expiresAt time.Time) (bool, error)
+	DeleteUpload(ctx context.Context, id string) error
+	GetExpiredUploads(ctx context.Context, before time.Time, limit int) ([]model.FileUpload, error)
+
+	// Presigned uploads
+	CreatePresignedUpload(ctx context.Context, upload *model.PresignedUpload) error
+	GetPresignedUpload(ctx context.Context, id string) (*model.PresignedUpload, error)
+	FinalizePresignedUpload(ctx context.Context, id string) (bool, error)
+	DeletePresignedUpload(ctx context.Context, id string) error
+	GetExpiredPresignedUploads(ctx context.Context, before time.Time, limit int) ([]model.PresignedUpload, error)
+
+	// File scans
+	CreateFileScan(ctx context.Context, scan *model.FileScan) error
+	GetFileScan(ctx context.Context, bucket, objectName string) (*model.FileScan, error)
+	DeleteFileScan(ctx context.Context, bucket, objectName string) error
+	FinishFileScan(ctx context.Context, bucket, objectName, status string, signature *string) (bool, error)
+	GetPendingFileScans(ctx context.Context, before time.Time, limit int) ([]model.FileScan, error)
+	SetMessagesFileStatus(ctx context.Context, bucket, objectName, status string) ([]int64, error)
 
 	// Reactions
 	AddReaction(ctx context.Context, messageID, userID int64, reaction string) error
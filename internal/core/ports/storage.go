@@ -0,0 +1,45 @@
+package ports
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+)
+
+// MultipartStorage stores a file in parts that are joined into one object
+// once the last part has been uploaded.
+type MultipartStorage interface {
+	NewMultipartUpload(ctx context.Context, bucket, objectName, contentType string) (string, error)
+	UploadPart(ctx context.Context, bucket, objectName, uploadID string, partNumber int, reader io.Reader, size int64) error
+	CompleteMultipartUpload(ctx context.Context, bucket, objectName, uploadID string) error
+	AbortMultipartUpload(ctx context.Context, bucket, objectName, uploadID string) error
+}
+
+// DirectUploadStorage lets clients upload objects straight to storage with
+// presigned requests.
+type DirectUploadStorage interface {
+	PresignedPutURL(ctx context.Context, bucket, objectName, contentType string, size int64, expires time.Duration) (string, http.Header, error)
+	PresignedPostPolicy(ctx context.Context, bucket, objectName, contentType string, maxSize int64, expires time.Duration) (string, map[string]string, error)
+	FileSize(ctx context.Context, bucket, objectName string) (int64, error)
+	CopyFile(ctx context.Context, bucket, srcObject, dstObject string) error
+	DeleteFile(ctx context.Context, bucket, objectName string) error
+}
+
+// ObjectStorage reads and removes stored objects.
+type ObjectStorage interface {
+	GetFile(ctx context.Context, bucket, objectName string) (io.ReadCloser, error)
+	DeleteFile(ctx context.Context, bucket, objectName string) error
+}
+
+// FileURLSigner signs temporary download URLs for stored objects.
+type FileURLSigner interface {
+	GetFileURL(ctx context.Context, bucket, objectName string, expires time.Duration) (string, error)
+}
+
+// FileScanner inspects the contents of a file for malware.
+type FileScanner interface {
+	Scan(ctx context.Context, r io.Reader) (model.ScanResult, error)
+}
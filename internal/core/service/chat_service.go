@@ -3,7 +3,12 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/zhanserikAmangeldi/chat-service/internal/adapters/grpc"
@@ -16,13 +21,18 @@ type ChatService struct {
 	repo       ports.ChatRepository
 	redis      redisAdapter.IRedisClient
 	userClient grpc.IUserClient
+	files      ports.FileURLSigner
 }
 
-func NewChatService(repo ports.ChatRepository, redis redisAdapter.IRedisClient, userClient grpc.IUserClient) *ChatService {
+// NewChatService creates a ChatService. Download URLs for stored files are
+// signed with files whenever messages are handed out; without it messages
+// carry none.
+func NewChatService(repo ports.ChatRepository, redis redisAdapter.IRedisClient, userClient grpc.IUserClient, files ports.FileURLSigner) *ChatService {
 	return &ChatService{
 		repo:       repo,
 		redis:      redis,
 		userClient: userClient,
+		files:      files,
 	}
 }
 
@@ -48,69 +58,1007 @@ func (s *ChatService) CreateGroup(ctx context.Context, name string, creatorID in
 	}
 
 	for _, uid := range allMembers {
+		role := model.RoleMember
+		if uid == creatorID {
+			role = model.RoleOwner
+		}
 		p := &model.Participant{
 			ConversationID: conv.ID,
 			UserID:         uid,
+			Role:           role,
 			JoinedAt:       time.Now(),
 		}
 		s.repo.AddParticipant(ctx, p)
 	}
 
-	return conv, nil
+	return conv, nil
+}
+
+func (s *ChatService) RenameGroup(ctx context.Context, conversationID, actorID int64, name string) error {
+	if _, err := s.getGroupConversation(ctx, conversationID); err != nil {
+		return err
+	}
+
+	if _, err := s.requireGroupAdmin(ctx, conversationID, actorID); err != nil {
+		return err
+	}
+
+	if err := s.repo.UpdateConversationName(ctx, conversationID, name); err != nil {
+		return err
+	}
+
+	participants, _ := s.repo.GetParticipants(ctx, conversationID)
+	s.sendSystemMessage(ctx, conversationID, actorID, fmt.Sprintf("User %d renamed the group to %q", actorID, name), participants)
+
+	return nil
+}
+
+func (s *ChatService) GetGroupMembers(ctx context.Context, conversationID, userID int64) ([]model.Participant, error) {
+	if _, err := s.getGroupConversation(ctx, conversationID); err != nil {
+		return nil, err
+	}
+
+	isParticipant, err := s.repo.IsParticipant(ctx, conversationID, userID)
+	if err != nil || !isParticipant {
+		return nil, errors.New("user is not a participant of this conversation")
+	}
+
+	return s.repo.ListParticipants(ctx, conversationID)
+}
+
+func (s *ChatService) AddGroupMember(ctx context.Context, conversationID, actorID, userID int64) error {
+	if _, err := s.getGroupConversation(ctx, conversationID); err != nil {
+		return err
+	}
+
+	if _, err := s.requireGroupAdmin(ctx, conversationID, actorID); err != nil {
+		return err
+	}
+
+	alreadyMember, err := s.repo.IsParticipant(ctx, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if alreadyMember {
+		return errors.New("user is already a member of this group")
+	}
+
+	exists, err := s.userClient.ValidateUserExists(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("user does not exist")
+	}
+
+	err = s.repo.AddParticipant(ctx, &model.Participant{
+		ConversationID: conversationID,
+		UserID:         userID,
+		Role:           model.RoleMember,
+		JoinedAt:       time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	participants, _ := s.repo.GetParticipants(ctx, conversationID)
+	event := model.ParticipantEvent{
+		ConversationID: conversationID,
+		UserID:         userID,
+		ActorID:        actorID,
+	}
+	_ = s.redis.PublishParticipantAdded(ctx, event, participants)
+
+	s.sendSystemMessage(ctx, conversationID, actorID, fmt.Sprintf("User %d added user %d to the group", actorID, userID), participants)
+
+	return nil
+}
+
+func (s *ChatService) RemoveGroupMember(ctx context.Context, conversationID, actorID, userID int64) error {
+	if actorID == userID {
+		return s.LeaveGroup(ctx, conversationID, userID)
+	}
+
+	if _, err := s.getGroupConversation(ctx, conversationID); err != nil {
+		return err
+	}
+
+	actor, err := s.requireGroupAdmin(ctx, conversationID, actorID)
+	if err != nil {
+		return err
+	}
+
+	target, err := s.repo.GetParticipant(ctx, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return errors.New("user is not a member of this group")
+	}
+	if target.Role == model.RoleOwner {
+		return errors.New("the group owner cannot be removed")
+	}
+	if target.Role == model.RoleAdmin && actor.Role != model.RoleOwner {
+		return errors.New("only the group owner can remove admins")
+	}
+
+	return s.removeMember(ctx, conversationID, actorID, userID, fmt.Sprintf("User %d removed user %d from the group", actorID, userID))
+}
+
+func (s *ChatService) LeaveGroup(ctx context.Context, conversationID, userID int64) error {
+	if _, err := s.getGroupConversation(ctx, conversationID); err != nil {
+		return err
+	}
+
+	member, err := s.repo.GetParticipant(ctx, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if member == nil {
+		return errors.New("user is not a member of this group")
+	}
+
+	if err := s.removeMember(ctx, conversationID, userID, userID, fmt.Sprintf("User %d left the group", userID)); err != nil {
+		return err
+	}
+
+	if member.Role == model.RoleOwner {
+		s.transferOwnership(ctx, conversationID, userID)
+	}
+
+	return nil
+}
+
+func (s *ChatService) UpdateMemberRole(ctx context.Context, conversationID, actorID, userID int64, role string) error {
+	if role != model.RoleOwner && role != model.RoleAdmin && role != model.RoleMember {
+		return errors.New("invalid role")
+	}
+
+	if _, err := s.getGroupConversation(ctx, conversationID); err != nil {
+		return err
+	}
+
+	actor, err := s.repo.GetParticipant(ctx, conversationID, actorID)
+	if err != nil {
+		return err
+	}
+	if actor == nil || actor.Role != model.RoleOwner {
+		return errors.New("only the group owner can change member roles")
+	}
+
+	if actorID == userID {
+		return errors.New("the group owner cannot change their own role")
+	}
+
+	target, err := s.repo.GetParticipant(ctx, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return errors.New("user is not a member of this group")
+	}
+
+	// Handing over ownership demotes the previous owner to admin, in the
+	// same transaction so the group never has two owners.
+	if role == model.RoleOwner {
+		if err := s.repo.TransferOwnership(ctx, conversationID, actorID, userID); err != nil {
+			return err
+		}
+	} else if err := s.repo.UpdateParticipantRole(ctx, conversationID, userID, role); err != nil {
+		return err
+	}
+
+	participants, _ := s.repo.GetParticipants(ctx, conversationID)
+	_ = s.redis.PublishParticipantRoleChanged(ctx, model.ParticipantEvent{
+		ConversationID: conversationID,
+		UserID:         userID,
+		ActorID:        actorID,
+		Role:           role,
+	}, participants)
+
+	if role == model.RoleOwner {
+		_ = s.redis.PublishParticipantRoleChanged(ctx, model.ParticipantEvent{
+			ConversationID: conversationID,
+			UserID:         actorID,
+			ActorID:        actorID,
+			Role:           model.RoleAdmin,
+		}, participants)
+	}
+
+	return nil
+}
+
+func (s *ChatService) removeMember(ctx context.Context, conversationID, actorID, userID int64, notice string) error {
+	if err := s.repo.RemoveParticipant(ctx, conversationID, userID); err != nil {
+		return err
+	}
+
+	participants, _ := s.repo.GetParticipants(ctx, conversationID)
+	event := model.ParticipantEvent{
+		ConversationID: conversationID,
+		UserID:         userID,
+		ActorID:        actorID,
+	}
+	// The removed user is no longer a participant but still needs the event
+	// to drop the conversation from their list.
+	_ = s.redis.PublishParticipantRemoved(ctx, event, append(participants, userID))
+
+	s.sendSystemMessage(ctx, conversationID, actorID, notice, participants)
+
+	return nil
+}
+
+// transferOwnership promotes the longest-standing admin, or failing that the
+// longest-standing member, after the owner has left the group, unless the
+// group got a new owner in the meantime.
+func (s *ChatService) transferOwnership(ctx context.Context, conversationID, previousOwnerID int64) {
+	members, err := s.repo.ListParticipants(ctx, conversationID)
+	if err != nil || len(members) == 0 {
+		return
+	}
+
+	next := members[0]
+	for _, m := range members {
+		if m.Role == model.RoleAdmin {
+			next = m
+			break
+		}
+	}
+
+	claimed, err := s.repo.ClaimOwnership(ctx, conversationID, next.UserID)
+	if err != nil {
+		log.Printf("Failed to transfer ownership of conversation %d: %v", conversationID, err)
+		return
+	}
+	// The owner handed the group over before leaving.
+	if !claimed {
+		return
+	}
+
+	participants := make([]int64, 0, len(members))
+	for _, m := range members {
+		participants = append(participants, m.UserID)
+	}
+
+	_ = s.redis.PublishParticipantRoleChanged(ctx, model.ParticipantEvent{
+		ConversationID: conversationID,
+		UserID:         next.UserID,
+		ActorID:        previousOwnerID,
+		Role:           model.RoleOwner,
+	}, participants)
+
+	s.sendSystemMessage(ctx, conversationID, previousOwnerID, fmt.Sprintf("User %d is now the group owner", next.UserID), participants)
+}
+
+func (s *ChatService) requireGroupAdmin(ctx context.Context, conversationID, userID int64) (*model.Participant, error) {
+	member, err := s.repo.GetParticipant(ctx, conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if member == nil {
+		return nil, errors.New("user is not a participant of this conversation")
+	}
+	if !member.IsAdmin() {
+		return nil, errors.New("only group admins can perform this action")
+	}
+	return member, nil
+}
+
+func (s *ChatService) getGroupConversation(ctx context.Context, conversationID int64) (*model.Conversation, error) {
+	conv, err := s.repo.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conv == nil {
+		return nil, errors.New("conversation not found")
+	}
+	if !conv.IsGroup {
+		return nil, errors.New("conversation is not a group")
+	}
+	return conv, nil
+}
+
+func (s *ChatService) sendSystemMessage(ctx context.Context, conversationID, actorID int64, content string, recipients []int64) {
+	msg := &model.Message{
+		ConversationID: conversationID,
+		SenderID:       actorID,
+		Content:        content,
+		MessageType:    "system",
+		CreatedAt:      time.Now(),
+	}
+
+	if err := s.repo.SaveMessage(ctx, msg); err != nil {
+		log.Printf("Failed to save system message for conversation %d: %v", conversationID, err)
+		return
+	}
+
+	if len(recipients) > 0 {
+		_ = s.redis.Publish(ctx, *msg, recipients)
+	}
+}
+
+// SendMessage sends a message to a conversation, or to the one-to-one
+// conversation with recipientID. Every message other than a text message
+// must be sent with an attachment uploaded by the sender; its URL is signed
+// by the server once the file is found clean.
+func (s *ChatService) SendMessage(ctx context.Context, senderID, recipientID int64, content string, conversationID int64, messageType string, fileName, mimeType *string, fileSize *int64, replyToMessageID *int64, clientMsgID *string, attachment model.Attachment) (*model.Message, error) {
+	if messageType == "system" {
+		return nil, errors.New("system messages cannot be sent by users")
+	}
+	if messageType != "" && messageType != "text" && attachment.ObjectName == "" {
+		return nil, errors.New("file messages must be sent with an uploaded attachment")
+	}
+
+	if clientMsgID != nil {
+		if *clientMsgID == "" {
+			clientMsgID = nil
+		} else if len(*clientMsgID) > maxClientMsgIDLength {
+			return nil, fmt.Errorf("client_msg_id cannot be longer than %d characters", maxClientMsgIDLength)
+		}
+	}
+
+	var replyTo *model.Message
+	if replyToMessageID != nil {
+		target, err := s.repo.GetMessageByID(ctx, *replyToMessageID)
+		if err != nil || target == nil {
+			return nil, errors.New("reply target message not found")
+		}
+		if target.DeletedAt != nil {
+			return nil, errors.New("cannot reply to a deleted message")
+		}
+		replyTo = target
+	}
+
+	// A reply can never start a new conversation, so only look one up.
+	conv, err := s.resolveConversation(ctx, senderID, recipientID, conversationID, replyTo == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if replyTo != nil && replyTo.ConversationID != conv.ID {
+		return nil, errors.New("reply target belongs to a different conversation")
+	}
+
+	// A retried send returns the message stored by the first attempt.
+	if clientMsgID != nil {
+		existing, err := s.repo.GetMessageByClientID(ctx, conv.ID, senderID, *clientMsgID)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			s.signFiles(ctx, existing)
+			return existing, nil
+		}
+	}
+
+	if messageType == "" {
+		messageType = "text"
+	}
+
+	msg := &model.Message{
+		ConversationID:   conv.ID,
+		SenderID:         senderID,
+		Content:          content,
+		MessageType:      messageType,
+		FileName:         fileName,
+		FileSize:         fileSize,
+		MimeType:         mimeType,
+		ReplyToMessageID: replyToMessageID,
+		ClientMsgID:      clientMsgID,
+		CreatedAt:        time.Now(),
+	}
+	if attachment.ObjectName != "" {
+		if err := s.attachFile(ctx, msg, attachment); err != nil {
+			return nil, err
+		}
+	}
+	if replyTo != nil {
+		msg.ReplyTo = replyTo.Preview()
+	}
+	msg.Mentions = s.resolveMentions(ctx, conv.ID, content)
+
+	if err := s.deliverMessage(ctx, msg); err != nil {
+		// A concurrent retry stored the message first.
+		if errors.Is(err, ports.ErrDuplicateMessage) {
+			existing, err := s.repo.GetMessageByClientID(ctx, conv.ID, senderID, *clientMsgID)
+			if existing != nil {
+				s.signFiles(ctx, existing)
+			}
+			return existing, err
+		}
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+const maxClientMsgIDLength = 64
+
+// attachFile links msg to the uploaded object behind its file, which must
+// have been uploaded by the sender and not be infected. An image message
+// gets the image info recorded when the object was stored.
+func (s *ChatService) attachFile(ctx context.Context, msg *model.Message, attachment model.Attachment) error {
+	scan, err := s.repo.GetFileScan(ctx, attachment.Bucket, attachment.ObjectName)
+	if err != nil {
+		return err
+	}
+	if scan == nil || scan.UserID != msg.SenderID {
+		return errors.New("attached file not found")
+	}
+	if scan.Status == model.FileStatusInfected {
+		return errors.New("attached file is infected")
+	}
+
+	msg.FileBucket = &scan.Bucket
+	msg.FileObject = &scan.ObjectName
+	msg.FileStatus = &scan.Status
+	if msg.FileName == nil {
+		msg.FileName = &scan.FileName
+	}
+	if msg.MessageType == "image" {
+		msg.ImageInfo = scan.ImageInfo
+	}
+	return nil
+}
+
+const maxForwardMessages = 50
+
+// ForwardMessages copies messages, attachments included, into a conversation
+// the user participates in. The user must also participate in every source
+// conversation. Copies are delivered oldest first like any other new message.
+func (s *ChatService) ForwardMessages(ctx context.Context, userID int64, messageIDs []int64, targetConversationID int64) ([]model.Message, error) {
+	if len(messageIDs) == 0 {
+		return nil, errors.New("no messages to forward")
+	}
+	if len(messageIDs) > maxForwardMessages {
+		return nil, fmt.Errorf("cannot forward more than %d messages at once", maxForwardMessages)
+	}
+
+	target, err := s.resolveConversation(ctx, userID, 0, targetConversationID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Membership is checked once per source conversation, and also answers
+	// whether the origin of an already forwarded message may be revealed.
+	visible := map[int64]bool{target.ID: true}
+	canSee := func(conversationID int64) bool {
+		if ok, checked := visible[conversationID]; checked {
+			return ok
+		}
+		ok, err := s.repo.IsParticipant(ctx, conversationID, userID)
+		visible[conversationID] = err == nil && ok
+		return visible[conversationID]
+	}
+
+	sources := make([]*model.Message, 0, len(messageIDs))
+	seen := make(map[int64]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		src, err := s.repo.GetMessageByID(ctx, id)
+		if err != nil || src == nil {
+			return nil, fmt.Errorf("message %d not found", id)
+		}
+		if !canSee(src.ConversationID) {
+			return nil, fmt.Errorf("user is not a participant of the conversation of message %d", id)
+		}
+		if src.DeletedAt != nil {
+			return nil, fmt.Errorf("message %d has been deleted", id)
+		}
+		if src.MessageType == "system" {
+			return nil, errors.New("system messages cannot be forwarded")
+		}
+		if src.FileStatus != nil && *src.FileStatus != model.FileStatusClean {
+			return nil, fmt.Errorf("the file of message %d has not been scanned yet", id)
+		}
+		// Copies get their file URL signed from the stored object, which
+		// messages sent with a bare URL do not have.
+		if src.FileURL != nil && src.FileObject == nil {
+			return nil, fmt.Errorf("the file of message %d cannot be forwarded", id)
+		}
+		sources = append(sources, src)
+	}
+
+	sort.Slice(sources, func(i, j int) bool { return sources[i].ID < sources[j].ID })
+
+	forwarded := make([]model.Message, 0, len(sources))
+	for _, src := range sources {
+		msg := &model.Message{
+			ConversationID: target.ID,
+			SenderID:       userID,
+			Content:        src.Content,
+			MessageType:    src.MessageType,
+			FileName:       src.FileName,
+			FileSize:       src.FileSize,
+			MimeType:       src.MimeType,
+			ForwardedFrom:  forwardOrigin(src, canSee),
+			ImageInfo:      src.ImageInfo,
+			FileRef:        src.FileRef,
+			CreatedAt:      time.Now(),
+		}
+
+		if err := s.deliverMessage(ctx, msg); err != nil {
+			return forwarded, err
+		}
+		forwarded = append(forwarded, *msg)
+	}
+
+	return forwarded, nil
+}
+
+// forwardOrigin points a forwarded copy at the message's original author.
+// Forwarding a forward keeps the first origin, but only names its
+// conversation and message when the forwarding user can see that conversation.
+func forwardOrigin(src *model.Message, canSee func(conversationID int64) bool) model.ForwardedFrom {
+	if !src.IsForwarded() {
+		return model.ForwardedFrom{
+			ForwardedFromMessageID:      &src.ID,
+			ForwardedFromSenderID:       &src.SenderID,
+			ForwardedFromConversationID: &src.ConversationID,
+		}
+	}
+
+	origin := model.ForwardedFrom{ForwardedFromSenderID: src.ForwardedFromSenderID}
+	if src.ForwardedFromConversationID != nil && canSee(*src.ForwardedFromConversationID) {
+		origin.ForwardedFromConversationID = src.ForwardedFromConversationID
+		origin.ForwardedFromMessageID = src.ForwardedFromMessageID
+	}
+	return origin
+}
+
+// resolveConversation returns the conversation a message is sent to: the
+// given conversation if the sender participates in it, otherwise the one-to-one
+// conversation with recipientID, which is created on first contact if allowed.
+func (s *ChatService) resolveConversation(ctx context.Context, senderID, recipientID, conversationID int64, allowCreate bool) (*model.Conversation, error) {
+	if conversationID > 0 {
+		conv, err := s.repo.GetConversationByID(ctx, conversationID)
+		if err != nil {
+			return nil, err
+		}
+		if conv == nil {
+			return nil, errors.New("conversation not found")
+		}
+
+		isParticipant, err := s.repo.IsParticipant(ctx, conv.ID, senderID)
+		if err != nil || !isParticipant {
+			return nil, errors.New("user is not a participant of this conversation")
+		}
+		return conv, nil
+	}
+
+	conv, err := s.repo.FindOneToOneConversation(ctx, senderID, recipientID)
+	if err != nil {
+		return nil, err
+	}
+	if conv != nil {
+		return conv, nil
+	}
+	if !allowCreate {
+		return nil, errors.New("conversation not found")
+	}
+
+	exists, _ := s.userClient.ValidateUserExists(ctx, recipientID)
+	if !exists {
+		return nil, errors.New("recipient user does not exist")
+	}
+
+	newConv := &model.Conversation{
+		IsGroup:   false,
+		CreatedAt: time.Now(),
+	}
+
+	if err := s.repo.CreateConversation(ctx, newConv); err != nil {
+		return nil, err
+	}
+
+	s.repo.AddParticipant(ctx, &model.Participant{ConversationID: newConv.ID, UserID: senderID, JoinedAt: time.Now()})
+	s.repo.AddParticipant(ctx, &model.Participant{ConversationID: newConv.ID, UserID: recipientID, JoinedAt: time.Now()})
+
+	return newConv, nil
+}
+
+// deliverMessage stores msg and fans it out to every other participant.
+// Mentioned users additionally get a mention event. A message whose file is
+// still being scanned is only stored; ResolveFileScan delivers it later.
+func (s *ChatService) deliverMessage(ctx context.Context, msg *model.Message) error {
+	if err := s.repo.SaveMessage(ctx, msg); err != nil {
+		return err
+	}
+	s.signFiles(ctx, msg)
+
+	if len(msg.Mentions) > 0 {
+		if err := s.repo.SetMessageMentions(ctx, msg.ID, msg.Mentions); err != nil {
+			log.Printf("Failed to save mentions of message %d: %v", msg.ID, err)
+		}
+	}
+
+	if msg.FileStatus != nil && *msg.FileStatus == model.FileStatusPending {
+		// The scan may have finished after the file was attached, in which
+		// case nothing else is going to release the message.
+		scan, err := s.repo.GetFileScan(ctx, *msg.FileBucket, *msg.FileObject)
+		if err == nil && scan != nil && scan.Status != model.FileStatusPending {
+			if err := s.ResolveFileScan(ctx, scan); err != nil {
+				log.Printf("Failed to release message %d: %v", msg.ID, err)
+			}
+		}
+		return nil
+	}
+
+	s.fanOut(ctx, msg)
+	return nil
+}
+
+// fanOut publishes a stored message to the participants of its conversation.
+func (s *ChatService) fanOut(ctx context.Context, msg *model.Message) {
+	participantIDs, err := s.repo.GetParticipants(ctx, msg.ConversationID)
+	if err != nil {
+		return
+	}
+
+	// A message carrying a client_msg_id is echoed to the sender too, so
+	// their devices can reconcile it with their optimistic copy.
+	recipients := make([]int64, 0)
+	for _, pid := range participantIDs {
+		if pid != msg.SenderID || msg.ClientMsgID != nil {
+			recipients = append(recipients, pid)
+		}
+	}
+
+	if len(recipients) > 0 {
+		_ = s.redis.Publish(ctx, *msg, recipients)
+	}
+
+	s.notifyMentioned(ctx, msg, msg.Mentions)
+}
+
+// ResolveFileScan applies the verdict on a scanned file to the messages held
+// back for it. They are delivered when the file is clean. When it is
+// infected they are deleted and the uploader is told about it.
+func (s *ChatService) ResolveFileScan(ctx context.Context, scan *model.FileScan) error {
+	messageIDs, err := s.repo.SetMessagesFileStatus(ctx, scan.Bucket, scan.ObjectName, scan.Status)
+	if err != nil {
+		return err
+	}
+
+	switch scan.Status {
+	case model.FileStatusClean:
+		for _, id := range messageIDs {
+			msg, err := s.repo.GetMessageByID(ctx, id)
+			if err != nil {
+				log.Printf("Failed to load released message %d: %v", id, err)
+				continue
+			}
+			if msg.DeletedAt == nil {
+				s.signFiles(ctx, msg)
+				s.fanOut(ctx, msg)
+			}
+		}
+
+	case model.FileStatusInfected:
+		for _, id := range messageIDs {
+			if err := s.repo.DeleteMessage(ctx, id); err != nil {
+				log.Printf("Failed to delete message %d with an infected file: %v", id, err)
+			}
+		}
+
+		event := model.FileInfectedEvent{
+			Bucket:     scan.Bucket,
+			ObjectName: scan.ObjectName,
+			FileName:   scan.FileName,
+			MessageIDs: messageIDs,
+		}
+		if scan.Signature != nil {
+			event.Signature = *scan.Signature
+		}
+		_ = s.redis.PublishFileInfected(ctx, event, []int64{scan.UserID})
+	}
+
+	return nil
+}
+
+// mentionPattern matches @username and @user_id tokens that are not part of a
+// longer word such as an e-mail address.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@(\w[\w.]*)`)
+
+// parseMentions extracts the numeric user IDs and usernames mentioned in
+// content, in order of appearance and without duplicates.
+func parseMentions(content string) (ids []int64, usernames []string) {
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		token := strings.TrimRight(match[1], ".")
+		key := strings.ToLower(token)
+		if token == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if id, err := strconv.ParseInt(token, 10, 64); err == nil {
+			ids = append(ids, id)
+		} else {
+			usernames = append(usernames, key)
+		}
+	}
+	return ids, usernames
+}
+
+// resolveMentions returns the participants of the conversation mentioned in
+// content. Mentions of anyone outside the conversation are ignored.
+func (s *ChatService) resolveMentions(ctx context.Context, conversationID int64, content string) []int64 {
+	ids, usernames := parseMentions(content)
+	if len(ids) == 0 && len(usernames) == 0 {
+		return nil
+	}
+
+	participants, err := s.repo.GetParticipants(ctx, conversationID)
+	if err != nil {
+		return nil
+	}
+
+	isParticipant := make(map[int64]bool, len(participants))
+	for _, pid := range participants {
+		isParticipant[pid] = true
+	}
+
+	mentioned := make(map[int64]bool)
+	for _, id := range ids {
+		if isParticipant[id] {
+			mentioned[id] = true
+		}
+	}
+
+	if len(usernames) > 0 {
+		names, err := s.userClient.GetUsernames(ctx, participants)
+		if err != nil {
+			log.Printf("Failed to resolve usernames for mentions: %v", err)
+		}
+		byName := make(map[string]int64, len(names))
+		for id, name := range names {
+			byName[strings.ToLower(name)] = id
+		}
+		for _, name := range usernames {
+			if id, ok := byName[name]; ok {
+				mentioned[id] = true
+			}
+		}
+	}
+
+	result := make([]int64, 0, len(mentioned))
+	for _, pid := range participants {
+		if mentioned[pid] {
+			result = append(result, pid)
+		}
+	}
+	return result
+}
+
+// notifyMentioned sends a mention event to the given users other than the
+// sender. It is separate from the message event so clients can alert users
+// regardless of how they treat ordinary messages in the conversation.
+func (s *ChatService) notifyMentioned(ctx context.Context, msg *model.Message, userIDs []int64) {
+	recipients := make([]int64, 0, len(userIDs))
+	for _, id := range userIDs {
+		if id != msg.SenderID {
+			recipients = append(recipients, id)
+		}
+	}
+
+	if len(recipients) > 0 {
+		_ = s.redis.PublishMention(ctx, *msg, recipients)
+	}
 }
 
-func (s *ChatService) SendMessage(ctx context.Context, senderID, recipientID int64, content string, conversationID int64, messageType string, fileURL, fileName, mimeType *string, fileSize *int64) (*model.Message, error) {
-	var conv *model.Conversation
-	var err error
+const maxHistoryLimit = 100
 
-	if conversationID > 0 {
-		conv, err = s.repo.GetConversationByID(ctx, conversationID)
+// messagePageFetcher loads up to limit messages newest first relative to the
+// given keyset bounds, as ChatRepository.GetMessages does.
+type messagePageFetcher func(beforeID, afterID int64, limit int) ([]model.Message, error)
+
+func (s *ChatService) GetHistory(ctx context.Context, userID, conversationID int64, cursor model.MessageCursor, limit int) (*model.MessagePage, error) {
+	limit, err := normalizePageRequest(cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	isParticipant, err := s.repo.IsParticipant(ctx, conversationID, userID)
+	if err != nil || !isParticipant {
+		return nil, errors.New("user is not a participant of this conversation")
+	}
+
+	page, err := buildMessagePage(func(beforeID, afterID int64, limit int) ([]model.Message, error) {
+		return s.repo.GetMessages(ctx, conversationID, userID, beforeID, afterID, limit)
+	}, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	s.signMessages(ctx, page.Messages)
+
+	return page, nil
+}
+
+// signFiles signs the download URLs of the stored file of a message that is
+// about to be handed out. A file that has not been found clean gets none, and
+// nothing about it but its name, size and status is handed out.
+func (s *ChatService) signFiles(ctx context.Context, msg *model.Message) {
+	if msg == nil {
+		return
+	}
+	if msg.FileStatus != nil && *msg.FileStatus != model.FileStatusClean {
+		msg.FileURL = nil
+		msg.ImageInfo = model.ImageInfo{}
+		return
+	}
+	if s.files == nil {
+		return
+	}
+
+	if msg.FileBucket != nil && msg.FileObject != nil {
+		url, err := s.files.GetFileURL(ctx, *msg.FileBucket, *msg.FileObject, fileURLExpiry)
 		if err != nil {
-			return nil, err
+			log.Printf("Failed to sign file %s/%s of message %d: %v", *msg.FileBucket, *msg.FileObject, msg.ID, err)
+		} else {
+			msg.FileURL = &url
 		}
-		if conv == nil {
-			return nil, errors.New("conversation not found")
+	}
+	msg.Thumbnails = SignThumbnails(ctx, s.files, msg.Thumbnails)
+}
+
+func (s *ChatService) signMessages(ctx context.Context, messages []model.Message) {
+	for i := range messages {
+		s.signFiles(ctx, &messages[i])
+	}
+}
+
+func normalizePageRequest(cursor model.MessageCursor, limit int) (int, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	set := 0
+	for _, id := range []int64{cursor.BeforeID, cursor.AfterID, cursor.AroundID} {
+		if id > 0 {
+			set++
+		}
+	}
+	if set > 1 {
+		return 0, errors.New("only one of before_id, after_id and around_id may be set")
+	}
+
+	return limit, nil
+}
+
+func buildMessagePage(fetch messagePageFetcher, cursor model.MessageCursor, limit int) (*model.MessagePage, error) {
+	if cursor.AroundID > 0 {
+		return buildPageAround(fetch, cursor.AroundID, limit)
+	}
+
+	// One extra row tells us whether another page exists without a COUNT.
+	messages, err := fetch(cursor.BeforeID, cursor.AfterID, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	hasMore := len(messages) > limit
+
+	page := &model.MessagePage{}
+	if cursor.AfterID > 0 {
+		if hasMore {
+			messages = messages[1:]
+		}
+		if len(messages) > 0 {
+			page.NextCursor = &messages[len(messages)-1].ID
+			if hasMore {
+				page.PrevCursor = &messages[0].ID
+			}
 		}
 	} else {
-		conv, err = s.repo.FindOneToOneConversation(ctx, senderID, recipientID)
+		if hasMore {
+			messages = messages[:limit]
+		}
+		if len(messages) > 0 {
+			if hasMore {
+				page.NextCursor = &messages[len(messages)-1].ID
+			}
+			if cursor.BeforeID > 0 {
+				page.PrevCursor = &messages[0].ID
+			}
+		}
+	}
+
+	if messages == nil {
+		messages = []model.Message{}
+	}
+	page.Messages = messages
+
+	return page, nil
+}
+
+// buildPageAround returns a page centred on messageID, which is included in
+// the older half, so clients can jump straight to a search hit or reply target.
+func buildPageAround(fetch messagePageFetcher, messageID int64, limit int) (*model.MessagePage, error) {
+	newerLimit := limit / 2
+	olderLimit := limit - newerLimit
+
+	older, err := fetch(messageID+1, 0, olderLimit+1)
+	if err != nil {
+		return nil, err
+	}
+	olderHasMore := len(older) > olderLimit
+	if olderHasMore {
+		older = older[:olderLimit]
+	}
+
+	var newer []model.Message
+	if newerLimit > 0 {
+		newer, err = fetch(0, messageID, newerLimit+1)
 		if err != nil {
 			return nil, err
 		}
+	}
+	newerHasMore := len(newer) > newerLimit
+	if newerHasMore {
+		newer = newer[1:]
+	}
 
-		if conv == nil {
-			exists, _ := s.userClient.ValidateUserExists(ctx, recipientID)
-			if !exists {
-				return nil, errors.New("recipient user does not exist")
-			}
+	messages := make([]model.Message, 0, len(newer)+len(older))
+	messages = append(messages, newer...)
+	messages = append(messages, older...)
 
-			newConv := &model.Conversation{
-				IsGroup:   false,
-				CreatedAt: time.Now(),
-			}
+	page := &model.MessagePage{Messages: messages}
+	if olderHasMore {
+		page.NextCursor = &messages[len(messages)-1].ID
+	}
+	if newerHasMore {
+		page.PrevCursor = &messages[0].ID
+	}
 
-			if err := s.repo.CreateConversation(ctx, newConv); err != nil {
-				return nil, err
-			}
-			conv = newConv
+	return page, nil
+}
 
-			s.repo.AddParticipant(ctx, &model.Participant{ConversationID: conv.ID, UserID: senderID, JoinedAt: time.Now()})
-			s.repo.AddParticipant(ctx, &model.Participant{ConversationID: conv.ID, UserID: recipientID, JoinedAt: time.Now()})
-		}
+func (s *ChatService) GetThread(ctx context.Context, userID, rootMessageID int64, cursor model.MessageCursor, limit int) (*model.MessagePage, error) {
+	limit, err := normalizePageRequest(cursor, limit)
+	if err != nil {
+		return nil, err
 	}
 
-	if messageType == "" {
-		messageType = "text"
+	root, err := s.getThreadRoot(ctx, userID, rootMessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := buildMessagePage(func(beforeID, afterID int64, limit int) ([]model.Message, error) {
+		return s.repo.GetThreadMessages(ctx, rootMessageID, userID, beforeID, afterID, limit)
+	}, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	s.signMessages(ctx, page.Messages)
+	s.signFiles(ctx, root)
+	page.Root = root
+
+	return page, nil
+}
+
+func (s *ChatService) SendThreadReply(ctx context.Context, senderID, rootMessageID int64, content string) (*model.Message, error) {
+	root, err := s.getThreadRoot(ctx, senderID, rootMessageID)
+	if err != nil {
+		return nil, err
+	}
+	if root.DeletedAt != nil {
+		return nil, errors.New("cannot reply to a deleted message")
 	}
 
 	msg := &model.Message{
-		ConversationID: conv.ID,
+		ConversationID: root.ConversationID,
 		SenderID:       senderID,
 		Content:        content,
-		MessageType:    messageType,
-		FileURL:        fileURL,
-		FileName:       fileName,
-		FileSize:       fileSize,
-		MimeType:       mimeType,
+		MessageType:    "text",
+		ThreadRootID:   &root.ID,
 		CreatedAt:      time.Now(),
 	}
 
@@ -118,39 +1066,246 @@ func (s *ChatService) SendMessage(ctx context.Context, senderID, recipientID int
 		return nil, err
 	}
 
-	participantIDs, err := s.repo.GetParticipants(ctx, conv.ID)
+	// The root author follows the thread from its first reply; the replier
+	// has read everything up to their own message.
+	_ = s.repo.JoinThread(ctx, root.ID, root.SenderID)
+	_ = s.repo.MarkThreadRead(ctx, root.ID, senderID, msg.ID)
+
+	participants, err := s.repo.GetParticipants(ctx, root.ConversationID)
 	if err != nil {
 		return msg, nil
 	}
 
 	recipients := make([]int64, 0)
-	for _, pid := range participantIDs {
+	for _, pid := range participants {
 		if pid != senderID {
 			recipients = append(recipients, pid)
 		}
 	}
 
 	if len(recipients) > 0 {
-		_ = s.redis.Publish(ctx, *msg, recipients)
+		_ = s.redis.PublishThreadReply(ctx, *msg, recipients)
 	}
 
 	return msg, nil
 }
 
-func (s *ChatService) GetHistory(ctx context.Context, conversationID int64, limit, offset int) ([]model.Message, error) {
-	if limit == 0 {
-		limit = 50
+func (s *ChatService) MarkThreadRead(ctx context.Context, userID, rootMessageID, messageID int64) error {
+	if _, err := s.getThreadRoot(ctx, userID, rootMessageID); err != nil {
+		return err
+	}
+
+	msg, err := s.repo.GetMessageByID(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if msg.ThreadRootID == nil || *msg.ThreadRootID != rootMessageID {
+		return errors.New("message does not belong to this thread")
+	}
+
+	return s.repo.MarkThreadRead(ctx, rootMessageID, userID, messageID)
+}
+
+func (s *ChatService) GetUserThreads(ctx context.Context, userID, conversationID int64, limit int) ([]model.ThreadSummary, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+	return s.repo.GetUserThreads(ctx, userID, conversationID, limit)
+}
+
+// getThreadRoot loads a thread's root message after checking that userID can
+// see it. Replies cannot start threads of their own.
+func (s *ChatService) getThreadRoot(ctx context.Context, userID, rootMessageID int64) (*model.Message, error) {
+	root, err := s.repo.GetMessageByID(ctx, rootMessageID)
+	if err != nil || root == nil {
+		return nil, errors.New("thread root message not found")
+	}
+	if root.ThreadRootID != nil {
+		return nil, errors.New("thread replies cannot have threads")
+	}
+	if root.MessageType == "system" {
+		return nil, errors.New("system messages cannot have threads")
+	}
+
+	isParticipant, err := s.repo.IsParticipant(ctx, root.ConversationID, userID)
+	if err != nil || !isParticipant {
+		return nil, errors.New("user is not a participant of this conversation")
+	}
+
+	return root, nil
+}
+
+func (s *ChatService) SearchMessages(ctx context.Context, userID int64, search model.MessageSearch, limit int) (*model.MessageSearchPage, error) {
+	search.Query = strings.TrimSpace(search.Query)
+	if search.Query == "" {
+		return nil, errors.New("search query is required")
+	}
+	if search.From != nil && search.To != nil && !search.From.Before(*search.To) {
+		return nil, errors.New("from must be before to")
+	}
+
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	if search.ConversationID > 0 {
+		isParticipant, err := s.repo.IsParticipant(ctx, search.ConversationID, userID)
+		if err != nil || !isParticipant {
+			return nil, errors.New("user is not a participant of this conversation")
+		}
+	}
+
+	results, err := s.repo.SearchMessages(ctx, userID, search, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &model.MessageSearchPage{Results: results}
+	if len(results) > limit {
+		page.Results = results[:limit]
+		next := page.Results[limit-1].ID
+		page.NextCursor = &next
+	}
+	if page.Results == nil {
+		page.Results = []model.MessageSearchResult{}
+	}
+	for i := range page.Results {
+		s.signFiles(ctx, &page.Results[i].Message)
+	}
+
+	return page, nil
+}
+
+func (s *ChatService) PinMessage(ctx context.Context, userID, messageID int64) error {
+	msg, err := s.getPinnableMessage(ctx, userID, messageID)
+	if err != nil {
+		return err
+	}
+	if msg.DeletedAt != nil {
+		return errors.New("cannot pin a deleted message")
+	}
+	if msg.FileStatus != nil && *msg.FileStatus == model.FileStatusPending {
+		return errors.New("cannot pin a message whose file has not been scanned yet")
+	}
+
+	pin := &model.PinnedMessage{
+		ConversationID: msg.ConversationID,
+		MessageID:      msg.ID,
+		PinnedBy:       userID,
+		PinnedAt:       time.Now(),
+		Message:        msg,
+	}
+
+	if err := s.repo.PinMessage(ctx, pin); err != nil {
+		return err
+	}
+	s.signFiles(ctx, msg)
+
+	participants, _ := s.repo.GetParticipants(ctx, msg.ConversationID)
+	_ = s.redis.PublishMessagePinned(ctx, *pin, participants)
+
+	return nil
+}
+
+func (s *ChatService) UnpinMessage(ctx context.Context, userID, messageID int64) error {
+	msg, err := s.getPinnableMessage(ctx, userID, messageID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.UnpinMessage(ctx, msg.ConversationID, msg.ID); err != nil {
+		return err
+	}
+
+	unpin := model.UnpinnedMessage{
+		ConversationID: msg.ConversationID,
+		MessageID:      msg.ID,
+		UnpinnedBy:     userID,
+		UnpinnedAt:     time.Now(),
+	}
+
+	participants, _ := s.repo.GetParticipants(ctx, msg.ConversationID)
+	_ = s.redis.PublishMessageUnpinned(ctx, unpin, participants)
+
+	return nil
+}
+
+func (s *ChatService) GetPinnedMessages(ctx context.Context, userID, conversationID int64) ([]model.PinnedMessage, error) {
+	isParticipant, err := s.repo.IsParticipant(ctx, conversationID, userID)
+	if err != nil || !isParticipant {
+		return nil, errors.New("user is not a participant of this conversation")
+	}
+
+	pins, err := s.repo.GetPinnedMessages(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pins {
+		s.signFiles(ctx, pins[i].Message)
+	}
+	return pins, nil
+}
+
+// getPinnableMessage loads a message userID may pin or unpin: any participant
+// can in a one-to-one conversation, only admins can in a group. Deleted
+// messages are returned too so that they can still be unpinned.
+func (s *ChatService) getPinnableMessage(ctx context.Context, userID, messageID int64) (*model.Message, error) {
+	msg, err := s.repo.GetMessageByID(ctx, messageID)
+	if err != nil || msg == nil {
+		return nil, errors.New("message not found")
+	}
+	if msg.ThreadRootID != nil {
+		return nil, errors.New("thread replies cannot be pinned")
+	}
+	if msg.MessageType == "system" {
+		return nil, errors.New("system messages cannot be pinned")
+	}
+
+	conv, err := s.repo.GetConversationByID(ctx, msg.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conv == nil {
+		return nil, errors.New("conversation not found")
+	}
+
+	if conv.IsGroup {
+		if _, err := s.requireGroupAdmin(ctx, conv.ID, userID); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+
+	isParticipant, err := s.repo.IsParticipant(ctx, conv.ID, userID)
+	if err != nil || !isParticipant {
+		return nil, errors.New("user is not a participant of this conversation")
 	}
-	return s.repo.GetMessages(ctx, conversationID, limit, offset)
+	return msg, nil
 }
 
 func (s *ChatService) GetUserConversations(ctx context.Context, userID int64, limit, offset int) ([]model.ConversationWithLastMessage, error) {
 	if limit == 0 {
 		limit = 20
 	}
-	return s.repo.GetUserConversations(ctx, userID, limit, offset)
+	convs, err := s.repo.GetUserConversations(ctx, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	for i := range convs {
+		s.signFiles(ctx, convs[i].LastMessage)
+		s.signFiles(ctx, convs[i].PinnedMessage)
+	}
+	return convs, nil
 }
 
+// MarkMessageAsRead marks everything up to the message as read, in its thread
+// for replies and in the conversation otherwise.
 func (s *ChatService) MarkMessageAsRead(ctx context.Context, messageID, userID int64) error {
 	msg, err := s.repo.GetMessageByID(ctx, messageID)
 	if err != nil {
@@ -161,32 +1316,104 @@ func (s *ChatService) MarkMessageAsRead(ctx context.Context, messageID, userID i
 		return nil
 	}
 
+	return s.markRead(ctx, userID, msg)
+}
+
+// MarkReadUpTo moves the user's read cursor in the conversation forward to
+// messageID. The cursor never moves back, so stale requests are ignored.
+func (s *ChatService) MarkReadUpTo(ctx context.Context, userID, conversationID, messageID int64) error {
+	msg, err := s.repo.GetMessageByID(ctx, messageID)
+	if err != nil || msg == nil {
+		return errors.New("message not found")
+	}
+	if msg.ConversationID != conversationID {
+		return errors.New("message does not belong to this conversation")
+	}
+
+	return s.markRead(ctx, userID, msg)
+}
+
+func (s *ChatService) markRead(ctx context.Context, userID int64, msg *model.Message) error {
+	if msg.ThreadRootID != nil {
+		return s.MarkThreadRead(ctx, userID, *msg.ThreadRootID, msg.ID)
+	}
+
 	isParticipant, err := s.repo.IsParticipant(ctx, msg.ConversationID, userID)
 	if err != nil || !isParticipant {
 		return errors.New("user is not a participant of this conversation")
 	}
 
-	err = s.repo.MarkMessageAsRead(ctx, messageID, userID)
+	advanced, err := s.repo.MarkReadUpTo(ctx, msg.ConversationID, userID, msg.ID)
+	if err != nil {
+		return err
+	}
+	if !advanced {
+		return nil
+	}
+
+	participants, err := s.repo.GetParticipants(ctx, msg.ConversationID)
 	if err != nil {
 		return err
 	}
 
 	readReceipt := model.MessageRead{
-		MessageID: messageID,
-		UserID:    userID,
-		ReadAt:    time.Now(),
+		ConversationID: msg.ConversationID,
+		MessageID:      msg.ID,
+		UserID:         userID,
+		ReadAt:         time.Now(),
 	}
 
-	_, _ = s.repo.GetParticipants(ctx, msg.ConversationID)
+	_ = s.redis.PublishReadReceipt(ctx, readReceipt, participants)
+
+	return nil
+}
+
+const (
+	maxAckBatch = 100
+	// Messages older than the redelivery window are not redelivered even if
+	// never acknowledged, so clients predating acks are not flooded.
+	redeliveryWindow  = 7 * 24 * time.Hour
+	maxRedeliveryPage = 100
+)
+
+// AcknowledgeMessages records that messages reached one of the user's devices
+// and sends each sender a delivered receipt the first time.
+func (s *ChatService) AcknowledgeMessages(ctx context.Context, userID int64, messageIDs []int64) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	if len(messageIDs) > maxAckBatch {
+		return fmt.Errorf("cannot acknowledge more than %d messages at once", maxAckBatch)
+	}
 
-	recipients := []int64{msg.SenderID}
+	deliveries, err := s.repo.MarkMessagesDelivered(ctx, userID, messageIDs)
+	if err != nil {
+		return err
+	}
 
-	_ = s.redis.PublishReadReceipt(ctx, readReceipt, recipients)
+	for _, delivery := range deliveries {
+		_ = s.redis.PublishDeliveryReceipt(ctx, delivery, []int64{delivery.SenderID})
+	}
 
 	return nil
 }
 
+// GetUndeliveredMessages returns the messages the user has not acknowledged
+// yet, oldest first, for redelivery when they reconnect.
+func (s *ChatService) GetUndeliveredMessages(ctx context.Context, userID int64) ([]model.Message, error) {
+	messages, err := s.repo.GetUndeliveredMessages(ctx, userID, time.Now().Add(-redeliveryWindow), maxRedeliveryPage)
+	if err != nil {
+		return nil, err
+	}
+	s.signMessages(ctx, messages)
+	return messages, nil
+}
+
 func (s *ChatService) AddReaction(ctx context.Context, messageID, userID int64, reaction string) error {
+	if reaction == "" {
+		return errors.New("reaction is required")
+	}
+
 	msg, err := s.repo.GetMessageByID(ctx, messageID)
 	if err != nil {
 		return err
@@ -216,6 +1443,10 @@ func (s *ChatService) AddReaction(ctx context.Context, messageID, userID int64,
 }
 
 func (s *ChatService) RemoveReaction(ctx context.Context, messageID, userID int64, reaction string) error {
+	if reaction == "" {
+		return errors.New("reaction is required")
+	}
+
 	msg, err := s.repo.GetMessageByID(ctx, messageID)
 	if err != nil {
 		return err
@@ -239,6 +1470,10 @@ func (s *ChatService) RemoveReaction(ctx context.Context, messageID, userID int6
 }
 
 func (s *ChatService) EditMessage(ctx context.Context, messageID, userID int64, newContent string) error {
+	if newContent == "" {
+		return errors.New("new content is required")
+	}
+
 	msg, err := s.repo.GetMessageByID(ctx, messageID)
 	if err != nil {
 		return err
@@ -257,10 +1492,31 @@ func (s *ChatService) EditMessage(ctx context.Context, messageID, userID int64,
 		return err
 	}
 
+	mentions := s.resolveMentions(ctx, msg.ConversationID, newContent)
+	if len(mentions) > 0 || len(msg.Mentions) > 0 {
+		if err := s.repo.SetMessageMentions(ctx, messageID, mentions); err != nil {
+			log.Printf("Failed to update mentions of message %d: %v", messageID, err)
+		}
+	}
+
 	updatedMsg, _ := s.repo.GetMessageByID(ctx, messageID)
 	if updatedMsg != nil {
+		s.signFiles(ctx, updatedMsg)
 		participants, _ := s.repo.GetParticipants(ctx, msg.ConversationID)
 		_ = s.redis.PublishMessageEdit(ctx, *updatedMsg, participants)
+
+		// Only users newly mentioned by the edit are notified again.
+		previous := make(map[int64]bool, len(msg.Mentions))
+		for _, id := range msg.Mentions {
+			previous[id] = true
+		}
+		added := make([]int64, 0, len(mentions))
+		for _, id := range mentions {
+			if !previous[id] {
+				added = append(added, id)
+			}
+		}
+		s.notifyMentioned(ctx, updatedMsg, added)
 	}
 
 	return nil
@@ -273,7 +1529,16 @@ func (s *ChatService) DeleteMessage(ctx context.Context, messageID, userID int64
 	}
 
 	if msg.SenderID != userID {
-		return errors.New("only message sender can delete the message")
+		conv, err := s.repo.GetConversationByID(ctx, msg.ConversationID)
+		if err != nil {
+			return err
+		}
+		if conv == nil || !conv.IsGroup {
+			return errors.New("only message sender can delete the message")
+		}
+		if _, err := s.requireGroupAdmin(ctx, msg.ConversationID, userID); err != nil {
+			return errors.New("only message sender or group admins can delete the message")
+		}
 	}
 
 	err = s.repo.DeleteMessage(ctx, messageID)
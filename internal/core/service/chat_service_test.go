@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,6 +12,7 @@ import (
 
 	grpcMocks "github.com/zhanserikAmangeldi/chat-service/internal/adapters/grpc/mocks"
 	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/ports"
 	repoMocks "github.com/zhanserikAmangeldi/chat-service/internal/core/ports/mocks"
 	redisMocks "github.com/zhanserikAmangeldi/chat-service/internal/redis/mocks"
 )
@@ -19,7 +22,7 @@ func TestSendMessage_NewConversation(t *testing.T) {
 	mockRedis := new(redisMocks.MockRedisClient)
 	mockUserClient := new(grpcMocks.MockUserClient)
 
-	service := NewChatService(mockRepo, mockRedis, mockUserClient)
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
 
 	ctx := context.Background()
 	senderID := int64(1)
@@ -47,7 +50,7 @@ func TestSendMessage_NewConversation(t *testing.T) {
 	mockRedis.On("Publish", ctx, mock.AnythingOfType("model.Message"), mock.AnythingOfType("[]int64")).
 		Return(nil)
 
-	message, err := service.SendMessage(ctx, senderID, recipientID, content, 0, "text", nil, nil, nil, nil)
+	message, err := service.SendMessage(ctx, senderID, recipientID, content, 0, "text", nil, nil, nil, nil, nil, model.Attachment{})
 
 	assert.NoError(t, err)
 	assert.NotNil(t, message)
@@ -66,7 +69,7 @@ func TestSendMessage_ExistingConversation(t *testing.T) {
 	mockRedis := new(redisMocks.MockRedisClient)
 	mockUserClient := new(grpcMocks.MockUserClient)
 
-	service := NewChatService(mockRepo, mockRedis, mockUserClient)
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
 
 	ctx := context.Background()
 	senderID := int64(1)
@@ -82,6 +85,9 @@ func TestSendMessage_ExistingConversation(t *testing.T) {
 	mockRepo.On("GetConversationByID", ctx, conversationID).
 		Return(existingConv, nil)
 
+	mockRepo.On("IsParticipant", ctx, conversationID, senderID).
+		Return(true, nil)
+
 	mockRepo.On("SaveMessage", ctx, mock.AnythingOfType("*model.Message")).
 		Return(nil)
 
@@ -91,7 +97,7 @@ func TestSendMessage_ExistingConversation(t *testing.T) {
 	mockRedis.On("Publish", ctx, mock.AnythingOfType("model.Message"), mock.AnythingOfType("[]int64")).
 		Return(nil)
 
-	message, err := service.SendMessage(ctx, senderID, 0, content, conversationID, "text", nil, nil, nil, nil)
+	message, err := service.SendMessage(ctx, senderID, 0, content, conversationID, "text", nil, nil, nil, nil, nil, model.Attachment{})
 
 	assert.NoError(t, err)
 	assert.NotNil(t, message)
@@ -107,7 +113,7 @@ func TestCreateGroup_Success(t *testing.T) {
 	mockRedis := new(redisMocks.MockRedisClient)
 	mockUserClient := new(grpcMocks.MockUserClient)
 
-	service := NewChatService(mockRepo, mockRedis, mockUserClient)
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
 
 	ctx := context.Background()
 	creatorID := int64(1)
@@ -120,8 +126,13 @@ func TestCreateGroup_Success(t *testing.T) {
 	mockRepo.On("CreateConversation", ctx, mock.AnythingOfType("*model.Conversation")).
 		Return(nil)
 
-	mockRepo.On("AddParticipant", ctx, mock.AnythingOfType("*model.Participant")).
-		Return(nil).Times(4)
+	mockRepo.On("AddParticipant", ctx, mock.MatchedBy(func(p *model.Participant) bool {
+		return p.UserID == creatorID && p.Role == model.RoleOwner
+	})).Return(nil).Once()
+
+	mockRepo.On("AddParticipant", ctx, mock.MatchedBy(func(p *model.Participant) bool {
+		return p.UserID != creatorID && p.Role == model.RoleMember
+	})).Return(nil).Times(3)
 
 	conversation, err := service.CreateGroup(ctx, groupName, creatorID, memberIDs)
 
@@ -139,7 +150,7 @@ func TestCreateGroup_UserNotExist(t *testing.T) {
 	mockRedis := new(redisMocks.MockRedisClient)
 	mockUserClient := new(grpcMocks.MockUserClient)
 
-	service := NewChatService(mockRepo, mockRedis, mockUserClient)
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
 
 	ctx := context.Background()
 	creatorID := int64(1)
@@ -163,7 +174,7 @@ func TestMarkMessageAsRead_Success(t *testing.T) {
 	mockRedis := new(redisMocks.MockRedisClient)
 	mockUserClient := new(grpcMocks.MockUserClient)
 
-	service := NewChatService(mockRepo, mockRedis, mockUserClient)
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
 
 	ctx := context.Background()
 	messageID := int64(42)
@@ -183,13 +194,15 @@ func TestMarkMessageAsRead_Success(t *testing.T) {
 	mockRepo.On("IsParticipant", ctx, int64(5), userID).
 		Return(true, nil)
 
-	mockRepo.On("MarkMessageAsRead", ctx, messageID, userID).
-		Return(nil)
+	mockRepo.On("MarkReadUpTo", ctx, int64(5), userID, messageID).
+		Return(true, nil)
 
 	mockRepo.On("GetParticipants", ctx, int64(5)).
-		Return([]int64{senderID, userID}, nil)
+		Return([]int64{senderID, userID, 3}, nil)
 
-	mockRedis.On("PublishReadReceipt", ctx, mock.AnythingOfType("model.MessageRead"), []int64{senderID}).
+	mockRedis.On("PublishReadReceipt", ctx, mock.MatchedBy(func(r model.MessageRead) bool {
+		return r.ConversationID == 5 && r.MessageID == messageID && r.UserID == userID
+	}), []int64{senderID, userID, 3}).
 		Return(nil)
 
 	err := service.MarkMessageAsRead(ctx, messageID, userID)
@@ -205,7 +218,7 @@ func TestMarkMessageAsRead_OwnMessage(t *testing.T) {
 	mockRedis := new(redisMocks.MockRedisClient)
 	mockUserClient := new(grpcMocks.MockUserClient)
 
-	service := NewChatService(mockRepo, mockRedis, mockUserClient)
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
 
 	ctx := context.Background()
 	messageID := int64(42)
@@ -229,12 +242,81 @@ func TestMarkMessageAsRead_OwnMessage(t *testing.T) {
 	mockRedis.AssertNotCalled(t, "PublishReadReceipt")
 }
 
+func TestMarkReadUpTo_CursorAlreadyAhead(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	userID := int64(2)
+
+	mockRepo.On("GetMessageByID", ctx, int64(42)).
+		Return(&model.Message{ID: 42, ConversationID: 5, SenderID: 1}, nil)
+	mockRepo.On("IsParticipant", ctx, int64(5), userID).
+		Return(true, nil)
+	mockRepo.On("MarkReadUpTo", ctx, int64(5), userID, int64(42)).
+		Return(false, nil)
+
+	err := service.MarkReadUpTo(ctx, userID, 5, 42)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertNotCalled(t, "PublishReadReceipt")
+}
+
+func TestMarkReadUpTo_WrongConversation(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+
+	mockRepo.On("GetMessageByID", ctx, int64(42)).
+		Return(&model.Message{ID: 42, ConversationID: 6, SenderID: 1}, nil)
+
+	err := service.MarkReadUpTo(ctx, 2, 5, 42)
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "MarkReadUpTo")
+}
+
+func TestMarkReadUpTo_ThreadReplyMovesThreadCursor(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	userID := int64(2)
+	rootID := int64(40)
+
+	mockRepo.On("GetMessageByID", ctx, int64(42)).
+		Return(&model.Message{ID: 42, ConversationID: 5, SenderID: 1, ThreadRootID: &rootID}, nil)
+	mockRepo.On("GetMessageByID", ctx, rootID).
+		Return(&model.Message{ID: rootID, ConversationID: 5, SenderID: 1, MessageType: "text"}, nil)
+	mockRepo.On("IsParticipant", ctx, int64(5), userID).
+		Return(true, nil)
+	mockRepo.On("MarkThreadRead", ctx, rootID, userID, int64(42)).
+		Return(nil)
+
+	err := service.MarkReadUpTo(ctx, userID, 5, 42)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "MarkReadUpTo")
+}
+
 func TestAddReaction_Success(t *testing.T) {
 	mockRepo := new(repoMocks.MockChatRepository)
 	mockRedis := new(redisMocks.MockRedisClient)
 	mockUserClient := new(grpcMocks.MockUserClient)
 
-	service := NewChatService(mockRepo, mockRedis, mockUserClient)
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
 
 	ctx := context.Background()
 	messageID := int64(42)
@@ -275,7 +357,7 @@ func TestEditMessage_Success(t *testing.T) {
 	mockRedis := new(redisMocks.MockRedisClient)
 	mockUserClient := new(grpcMocks.MockUserClient)
 
-	service := NewChatService(mockRepo, mockRedis, mockUserClient)
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
 
 	ctx := context.Background()
 	messageID := int64(42)
@@ -326,7 +408,7 @@ func TestEditMessage_NotOwner(t *testing.T) {
 	mockRedis := new(redisMocks.MockRedisClient)
 	mockUserClient := new(grpcMocks.MockUserClient)
 
-	service := NewChatService(mockRepo, mockRedis, mockUserClient)
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
 
 	ctx := context.Background()
 	messageID := int64(42)
@@ -356,7 +438,7 @@ func TestDeleteMessage_Success(t *testing.T) {
 	mockRedis := new(redisMocks.MockRedisClient)
 	mockUserClient := new(grpcMocks.MockUserClient)
 
-	service := NewChatService(mockRepo, mockRedis, mockUserClient)
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
 
 	ctx := context.Background()
 	messageID := int64(42)
@@ -387,3 +469,1368 @@ func TestDeleteMessage_Success(t *testing.T) {
 	mockRepo.AssertExpectations(t)
 	mockRedis.AssertExpectations(t)
 }
+
+func TestAddGroupMember_Success(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	conversationID := int64(5)
+	actorID := int64(1)
+	newMemberID := int64(3)
+
+	group := &model.Conversation{ID: conversationID, IsGroup: true, Name: "Team"}
+
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(group, nil)
+
+	mockRepo.On("GetParticipant", ctx, conversationID, actorID).
+		Return(&model.Participant{ConversationID: conversationID, UserID: actorID, Role: model.RoleAdmin}, nil)
+
+	mockRepo.On("IsParticipant", ctx, conversationID, newMemberID).
+		Return(false, nil)
+
+	mockUserClient.On("ValidateUserExists", ctx, newMemberID).
+		Return(true, nil)
+
+	mockRepo.On("AddParticipant", ctx, mock.AnythingOfType("*model.Participant")).
+		Return(nil)
+
+	mockRepo.On("GetParticipants", ctx, conversationID).
+		Return([]int64{actorID, int64(2), newMemberID}, nil)
+
+	mockRedis.On("PublishParticipantAdded", ctx, model.ParticipantEvent{
+		ConversationID: conversationID,
+		UserID:         newMemberID,
+		ActorID:        actorID,
+	}, []int64{actorID, int64(2), newMemberID}).
+		Return(nil)
+
+	mockRepo.On("SaveMessage", ctx, mock.MatchedBy(func(msg *model.Message) bool {
+		return msg.MessageType == "system" && msg.ConversationID == conversationID
+	})).Return(nil)
+
+	mockRedis.On("Publish", ctx, mock.AnythingOfType("model.Message"), []int64{actorID, int64(2), newMemberID}).
+		Return(nil)
+
+	err := service.AddGroupMember(ctx, conversationID, actorID, newMemberID)
+
+	assert.NoError(t, err)
+
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+	mockUserClient.AssertExpectations(t)
+}
+
+func TestAddGroupMember_AlreadyMember(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	conversationID := int64(5)
+
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID, IsGroup: true}, nil)
+
+	mockRepo.On("GetParticipant", ctx, conversationID, int64(1)).
+		Return(&model.Participant{ConversationID: conversationID, UserID: 1, Role: model.RoleOwner}, nil)
+
+	mockRepo.On("IsParticipant", ctx, conversationID, int64(2)).
+		Return(true, nil)
+
+	err := service.AddGroupMember(ctx, conversationID, 1, 2)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "already a member")
+
+	mockRepo.AssertNotCalled(t, "AddParticipant", mock.Anything, mock.Anything)
+	mockRedis.AssertNotCalled(t, "PublishParticipantAdded", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAddGroupMember_NotGroup(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+
+	mockRepo.On("GetConversationByID", ctx, int64(5)).
+		Return(&model.Conversation{ID: 5, IsGroup: false}, nil)
+
+	err := service.AddGroupMember(ctx, 5, 1, 3)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not a group")
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestLeaveGroup_Success(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	conversationID := int64(5)
+	userID := int64(3)
+
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID, IsGroup: true}, nil)
+
+	mockRepo.On("GetParticipant", ctx, conversationID, userID).
+		Return(&model.Participant{ConversationID: conversationID, UserID: userID, Role: model.RoleMember}, nil)
+
+	mockRepo.On("RemoveParticipant", ctx, conversationID, userID).
+		Return(nil)
+
+	mockRepo.On("GetParticipants", ctx, conversationID).
+		Return([]int64{int64(1), int64(2)}, nil)
+
+	mockRedis.On("PublishParticipantRemoved", ctx, model.ParticipantEvent{
+		ConversationID: conversationID,
+		UserID:         userID,
+		ActorID:        userID,
+	}, []int64{int64(1), int64(2), userID}).
+		Return(nil)
+
+	mockRepo.On("SaveMessage", ctx, mock.AnythingOfType("*model.Message")).
+		Return(nil)
+
+	mockRedis.On("Publish", ctx, mock.AnythingOfType("model.Message"), []int64{int64(1), int64(2)}).
+		Return(nil)
+
+	err := service.LeaveGroup(ctx, conversationID, userID)
+
+	assert.NoError(t, err)
+
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestSendMessage_NotParticipant(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	conversationID := int64(5)
+	senderID := int64(9)
+
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID, IsGroup: true}, nil)
+
+	mockRepo.On("IsParticipant", ctx, conversationID, senderID).
+		Return(false, nil)
+
+	message, err := service.SendMessage(ctx, senderID, 0, "hi", conversationID, "text", nil, nil, nil, nil, nil, model.Attachment{})
+
+	assert.Error(t, err)
+	assert.Nil(t, message)
+
+	mockRepo.AssertNotCalled(t, "SaveMessage", mock.Anything, mock.Anything)
+}
+
+func TestAddGroupMember_NotAdmin(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	conversationID := int64(5)
+
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID, IsGroup: true}, nil)
+
+	mockRepo.On("GetParticipant", ctx, conversationID, int64(2)).
+		Return(&model.Participant{ConversationID: conversationID, UserID: 2, Role: model.RoleMember}, nil)
+
+	err := service.AddGroupMember(ctx, conversationID, 2, 3)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "only group admins")
+
+	mockRepo.AssertNotCalled(t, "AddParticipant", mock.Anything, mock.Anything)
+}
+
+func TestRemoveGroupMember_AdminCannotRemoveAdmin(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	conversationID := int64(5)
+
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID, IsGroup: true}, nil)
+
+	mockRepo.On("GetParticipant", ctx, conversationID, int64(2)).
+		Return(&model.Participant{ConversationID: conversationID, UserID: 2, Role: model.RoleAdmin}, nil)
+
+	mockRepo.On("GetParticipant", ctx, conversationID, int64(3)).
+		Return(&model.Participant{ConversationID: conversationID, UserID: 3, Role: model.RoleAdmin}, nil)
+
+	err := service.RemoveGroupMember(ctx, conversationID, 2, 3)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "only the group owner")
+
+	mockRepo.AssertNotCalled(t, "RemoveParticipant", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLeaveGroup_OwnerTransfersOwnership(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	conversationID := int64(5)
+	ownerID := int64(1)
+
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID, IsGroup: true}, nil)
+
+	mockRepo.On("GetParticipant", ctx, conversationID, ownerID).
+		Return(&model.Participant{ConversationID: conversationID, UserID: ownerID, Role: model.RoleOwner}, nil)
+
+	mockRepo.On("RemoveParticipant", ctx, conversationID, ownerID).
+		Return(nil)
+
+	mockRepo.On("GetParticipants", ctx, conversationID).
+		Return([]int64{int64(2), int64(3)}, nil)
+
+	mockRepo.On("ListParticipants", ctx, conversationID).
+		Return([]model.Participant{
+			{ConversationID: conversationID, UserID: 2, Role: model.RoleMember},
+			{ConversationID: conversationID, UserID: 3, Role: model.RoleAdmin},
+		}, nil)
+
+	// The admin is preferred over the longer-standing plain member.
+	mockRepo.On("ClaimOwnership", ctx, conversationID, int64(3)).
+		Return(true, nil)
+
+	mockRedis.On("PublishParticipantRemoved", ctx, mock.AnythingOfType("model.ParticipantEvent"), mock.AnythingOfType("[]int64")).
+		Return(nil)
+
+	mockRedis.On("PublishParticipantRoleChanged", ctx, model.ParticipantEvent{
+		ConversationID: conversationID,
+		UserID:         3,
+		ActorID:        ownerID,
+		Role:           model.RoleOwner,
+	}, []int64{int64(2), int64(3)}).
+		Return(nil)
+
+	mockRepo.On("SaveMessage", ctx, mock.AnythingOfType("*model.Message")).
+		Return(nil)
+
+	mockRedis.On("Publish", ctx, mock.AnythingOfType("model.Message"), mock.AnythingOfType("[]int64")).
+		Return(nil)
+
+	err := service.LeaveGroup(ctx, conversationID, ownerID)
+
+	assert.NoError(t, err)
+
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestLeaveGroup_OwnershipAlreadyHandedOver(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	conversationID := int64(5)
+	ownerID := int64(1)
+
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID, IsGroup: true}, nil)
+	mockRepo.On("GetParticipant", ctx, conversationID, ownerID).
+		Return(&model.Participant{ConversationID: conversationID, UserID: ownerID, Role: model.RoleOwner}, nil)
+	mockRepo.On("RemoveParticipant", ctx, conversationID, ownerID).
+		Return(nil)
+	mockRepo.On("GetParticipants", ctx, conversationID).
+		Return([]int64{int64(2)}, nil)
+	mockRepo.On("ListParticipants", ctx, conversationID).
+		Return([]model.Participant{{ConversationID: conversationID, UserID: 2, Role: model.RoleOwner}}, nil)
+	mockRepo.On("ClaimOwnership", ctx, conversationID, int64(2)).
+		Return(false, nil)
+	mockRepo.On("SaveMessage", ctx, mock.AnythingOfType("*model.Message")).
+		Return(nil)
+	mockRedis.On("PublishParticipantRemoved", ctx, mock.AnythingOfType("model.ParticipantEvent"), mock.AnythingOfType("[]int64")).
+		Return(nil)
+	mockRedis.On("Publish", ctx, mock.AnythingOfType("model.Message"), mock.AnythingOfType("[]int64")).
+		Return(nil)
+
+	err := service.LeaveGroup(ctx, conversationID, ownerID)
+
+	assert.NoError(t, err)
+	mockRedis.AssertNotCalled(t, "PublishParticipantRoleChanged", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUpdateMemberRole_HandOverOwnership(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	conversationID := int64(5)
+
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID, IsGroup: true}, nil)
+	mockRepo.On("GetParticipant", ctx, conversationID, int64(1)).
+		Return(&model.Participant{ConversationID: conversationID, UserID: 1, Role: model.RoleOwner}, nil)
+	mockRepo.On("GetParticipant", ctx, conversationID, int64(2)).
+		Return(&model.Participant{ConversationID: conversationID, UserID: 2, Role: model.RoleMember}, nil)
+	mockRepo.On("TransferOwnership", ctx, conversationID, int64(1), int64(2)).
+		Return(nil)
+	mockRepo.On("GetParticipants", ctx, conversationID).
+		Return([]int64{1, 2}, nil)
+	mockRedis.On("PublishParticipantRoleChanged", ctx, mock.AnythingOfType("model.ParticipantEvent"), []int64{1, 2}).
+		Return(nil).Twice()
+
+	err := service.UpdateMemberRole(ctx, conversationID, 1, 2, model.RoleOwner)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "UpdateParticipantRole", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUpdateMemberRole_FailedHandOverPublishesNothing(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	conversationID := int64(5)
+
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID, IsGroup: true}, nil)
+	mockRepo.On("GetParticipant", ctx, conversationID, int64(1)).
+		Return(&model.Participant{ConversationID: conversationID, UserID: 1, Role: model.RoleOwner}, nil)
+	mockRepo.On("GetParticipant", ctx, conversationID, int64(2)).
+		Return(&model.Participant{ConversationID: conversationID, UserID: 2, Role: model.RoleMember}, nil)
+	mockRepo.On("TransferOwnership", ctx, conversationID, int64(1), int64(2)).
+		Return(errors.New("user is no longer the group owner"))
+
+	err := service.UpdateMemberRole(ctx, conversationID, 1, 2, model.RoleOwner)
+
+	assert.Error(t, err)
+	mockRedis.AssertNotCalled(t, "PublishParticipantRoleChanged", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeleteMessage_GroupAdminDeletesOthersMessage(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	messageID := int64(42)
+	adminID := int64(1)
+
+	message := &model.Message{
+		ID:             messageID,
+		ConversationID: 5,
+		SenderID:       int64(2),
+	}
+
+	mockRepo.On("GetMessageByID", ctx, messageID).
+		Return(message, nil)
+
+	mockRepo.On("GetConversationByID", ctx, int64(5)).
+		Return(&model.Conversation{ID: 5, IsGroup: true}, nil)
+
+	mockRepo.On("GetParticipant", ctx, int64(5), adminID).
+		Return(&model.Participant{ConversationID: 5, UserID: adminID, Role: model.RoleAdmin}, nil)
+
+	mockRepo.On("DeleteMessage", ctx, messageID).
+		Return(nil)
+
+	mockRepo.On("GetParticipants", ctx, int64(5)).
+		Return([]int64{adminID, int64(2)}, nil)
+
+	mockRedis.On("PublishMessageDeletion", ctx, messageID, mock.AnythingOfType("[]int64")).
+		Return(nil)
+
+	err := service.DeleteMessage(ctx, messageID, adminID)
+
+	assert.NoError(t, err)
+
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestDeleteMessage_MemberCannotDeleteOthersMessage(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	messageID := int64(42)
+
+	mockRepo.On("GetMessageByID", ctx, messageID).
+		Return(&model.Message{ID: messageID, ConversationID: 5, SenderID: 2}, nil)
+
+	mockRepo.On("GetConversationByID", ctx, int64(5)).
+		Return(&model.Conversation{ID: 5, IsGroup: true}, nil)
+
+	mockRepo.On("GetParticipant", ctx, int64(5), int64(3)).
+		Return(&model.Participant{ConversationID: 5, UserID: 3, Role: model.RoleMember}, nil)
+
+	err := service.DeleteMessage(ctx, messageID, 3)
+
+	assert.Error(t, err)
+
+	mockRepo.AssertNotCalled(t, "DeleteMessage", mock.Anything, mock.Anything)
+}
+
+func historyMessages(ids ...int64) []model.Message {
+	messages := make([]model.Message, 0, len(ids))
+	for _, id := range ids {
+		messages = append(messages, model.Message{ID: id, ConversationID: 5})
+	}
+	return messages
+}
+
+func TestGetHistory_BeforeCursor(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+
+	mockRepo.On("IsParticipant", ctx, int64(5), int64(1)).
+		Return(true, nil)
+
+	mockRepo.On("GetMessages", ctx, int64(5), int64(1), int64(100), int64(0), 4).
+		Return(historyMessages(99, 98, 97, 96), nil)
+
+	page, err := service.GetHistory(ctx, 1, 5, model.MessageCursor{BeforeID: 100}, 3)
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Messages, 3)
+	assert.Equal(t, int64(97), *page.NextCursor)
+	assert.Equal(t, int64(99), *page.PrevCursor)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetHistory_LastPage(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+
+	mockRepo.On("IsParticipant", ctx, int64(5), int64(1)).
+		Return(true, nil)
+
+	mockRepo.On("GetMessages", ctx, int64(5), int64(1), int64(0), int64(0), 51).
+		Return(historyMessages(3, 2, 1), nil)
+
+	page, err := service.GetHistory(ctx, 1, 5, model.MessageCursor{}, 0)
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Messages, 3)
+	assert.Nil(t, page.NextCursor)
+	assert.Nil(t, page.PrevCursor)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetHistory_AfterCursor(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+
+	mockRepo.On("IsParticipant", ctx, int64(5), int64(1)).
+		Return(true, nil)
+
+	mockRepo.On("GetMessages", ctx, int64(5), int64(1), int64(0), int64(10), 3).
+		Return(historyMessages(13, 12, 11), nil)
+
+	page, err := service.GetHistory(ctx, 1, 5, model.MessageCursor{AfterID: 10}, 2)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{12, 11}, []int64{page.Messages[0].ID, page.Messages[1].ID})
+	assert.Equal(t, int64(11), *page.NextCursor)
+	assert.Equal(t, int64(12), *page.PrevCursor)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetHistory_AroundMessage(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+
+	mockRepo.On("IsParticipant", ctx, int64(5), int64(1)).
+		Return(true, nil)
+
+	mockRepo.On("GetMessages", ctx, int64(5), int64(1), int64(51), int64(0), 3).
+		Return(historyMessages(50, 49, 48), nil)
+
+	mockRepo.On("GetMessages", ctx, int64(5), int64(1), int64(0), int64(50), 3).
+		Return(historyMessages(53, 52, 51), nil)
+
+	page, err := service.GetHistory(ctx, 1, 5, model.MessageCursor{AroundID: 50}, 4)
+
+	assert.NoError(t, err)
+	ids := make([]int64, 0, len(page.Messages))
+	for _, m := range page.Messages {
+		ids = append(ids, m.ID)
+	}
+	assert.Equal(t, []int64{52, 51, 50, 49}, ids)
+	assert.Equal(t, int64(49), *page.NextCursor)
+	assert.Equal(t, int64(52), *page.PrevCursor)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetHistory_NotParticipant(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+
+	mockRepo.On("IsParticipant", ctx, int64(5), int64(9)).
+		Return(false, nil)
+
+	page, err := service.GetHistory(ctx, 9, 5, model.MessageCursor{}, 20)
+
+	assert.Error(t, err)
+	assert.Nil(t, page)
+}
+
+func TestSendMessage_ReplyEmbedsPreview(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	senderID := int64(1)
+	conversationID := int64(5)
+	replyToID := int64(30)
+
+	mockRepo.On("GetMessageByID", ctx, replyToID).
+		Return(&model.Message{
+			ID:             replyToID,
+			ConversationID: conversationID,
+			SenderID:       2,
+			Content:        strings.Repeat("a", 150),
+			MessageType:    "text",
+		}, nil)
+
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID}, nil)
+
+	mockRepo.On("IsParticipant", ctx, conversationID, senderID).
+		Return(true, nil)
+
+	mockRepo.On("SaveMessage", ctx, mock.MatchedBy(func(msg *model.Message) bool {
+		return msg.ReplyToMessageID != nil && *msg.ReplyToMessageID == replyToID
+	})).Return(nil)
+
+	mockRepo.On("GetParticipants", ctx, conversationID).
+		Return([]int64{senderID, int64(2)}, nil)
+
+	mockRedis.On("Publish", ctx, mock.MatchedBy(func(msg model.Message) bool {
+		return msg.ReplyTo != nil && msg.ReplyTo.ID == replyToID
+	}), []int64{int64(2)}).Return(nil)
+
+	message, err := service.SendMessage(ctx, senderID, 0, "reply", conversationID, "text", nil, nil, nil, &replyToID, nil, model.Attachment{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), message.ReplyTo.SenderID)
+	assert.Equal(t, strings.Repeat("a", 100)+"…", message.ReplyTo.Content)
+	assert.False(t, message.ReplyTo.IsDeleted)
+
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestSendMessage_ReplyToOtherConversation(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	senderID := int64(1)
+	replyToID := int64(30)
+
+	mockRepo.On("GetMessageByID", ctx, replyToID).
+		Return(&model.Message{ID: replyToID, ConversationID: 99, SenderID: 2}, nil)
+
+	mockRepo.On("GetConversationByID", ctx, int64(5)).
+		Return(&model.Conversation{ID: 5}, nil)
+
+	mockRepo.On("IsParticipant", ctx, int64(5), senderID).
+		Return(true, nil)
+
+	message, err := service.SendMessage(ctx, senderID, 0, "reply", 5, "text", nil, nil, nil, &replyToID, nil, model.Attachment{})
+
+	assert.Error(t, err)
+	assert.Nil(t, message)
+	assert.Contains(t, err.Error(), "different conversation")
+
+	mockRepo.AssertNotCalled(t, "SaveMessage", mock.Anything, mock.Anything)
+}
+
+func TestSendMessage_ReplyNeverCreatesConversation(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	replyToID := int64(30)
+
+	mockRepo.On("GetMessageByID", ctx, replyToID).
+		Return(&model.Message{ID: replyToID, ConversationID: 99, SenderID: 3}, nil)
+
+	mockRepo.On("FindOneToOneConversation", ctx, int64(1), int64(2)).
+		Return(nil, nil)
+
+	_, err := service.SendMessage(ctx, 1, 2, "reply", 0, "text", nil, nil, nil, &replyToID, nil, model.Attachment{})
+
+	assert.Error(t, err)
+
+	mockRepo.AssertNotCalled(t, "CreateConversation", mock.Anything, mock.Anything)
+}
+
+func TestSendThreadReply_Success(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	senderID := int64(1)
+	rootID := int64(30)
+	conversationID := int64(5)
+
+	mockRepo.On("GetMessageByID", ctx, rootID).
+		Return(&model.Message{ID: rootID, ConversationID: conversationID, SenderID: 2, MessageType: "text"}, nil)
+
+	mockRepo.On("IsParticipant", ctx, conversationID, senderID).
+		Return(true, nil)
+
+	mockRepo.On("SaveMessage", ctx, mock.MatchedBy(func(msg *model.Message) bool {
+		return msg.ThreadRootID != nil && *msg.ThreadRootID == rootID && msg.ConversationID == conversationID
+	})).Return(nil)
+
+	mockRepo.On("JoinThread", ctx, rootID, int64(2)).Return(nil)
+	mockRepo.On("MarkThreadRead", ctx, rootID, senderID, int64(42)).Return(nil)
+
+	mockRepo.On("GetParticipants", ctx, conversationID).
+		Return([]int64{senderID, 2, 3}, nil)
+
+	mockRedis.On("PublishThreadReply", ctx, mock.AnythingOfType("model.Message"), []int64{2, 3}).
+		Return(nil)
+
+	message, err := service.SendThreadReply(ctx, senderID, rootID, "in thread")
+
+	assert.NoError(t, err)
+	assert.Equal(t, rootID, *message.ThreadRootID)
+
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestSendThreadReply_ReplyCannotBeRoot(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	parentRootID := int64(30)
+
+	mockRepo.On("GetMessageByID", ctx, int64(31)).
+		Return(&model.Message{ID: 31, ConversationID: 5, SenderID: 2, ThreadRootID: &parentRootID}, nil)
+
+	message, err := service.SendThreadReply(ctx, 1, 31, "nested")
+
+	assert.Error(t, err)
+	assert.Nil(t, message)
+
+	mockRepo.AssertNotCalled(t, "SaveMessage", mock.Anything, mock.Anything)
+}
+
+func TestGetThread_IncludesRoot(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	rootID := int64(30)
+
+	mockRepo.On("GetMessageByID", ctx, rootID).
+		Return(&model.Message{ID: rootID, ConversationID: 5, SenderID: 2, MessageType: "text"}, nil)
+
+	mockRepo.On("IsParticipant", ctx, int64(5), int64(1)).
+		Return(true, nil)
+
+	mockRepo.On("GetThreadMessages", ctx, rootID, int64(1), int64(0), int64(0), 3).
+		Return(historyMessages(33, 32), nil)
+
+	page, err := service.GetThread(ctx, 1, rootID, model.MessageCursor{}, 2)
+
+	assert.NoError(t, err)
+	assert.Equal(t, rootID, page.Root.ID)
+	assert.Len(t, page.Messages, 2)
+	assert.Nil(t, page.NextCursor)
+}
+
+func TestMarkThreadRead_MessageOutsideThread(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	rootID := int64(30)
+
+	mockRepo.On("GetMessageByID", ctx, rootID).
+		Return(&model.Message{ID: rootID, ConversationID: 5, SenderID: 2, MessageType: "text"}, nil)
+
+	mockRepo.On("IsParticipant", ctx, int64(5), int64(1)).
+		Return(true, nil)
+
+	mockRepo.On("GetMessageByID", ctx, int64(40)).
+		Return(&model.Message{ID: 40, ConversationID: 5}, nil)
+
+	err := service.MarkThreadRead(ctx, 1, rootID, 40)
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "MarkThreadRead", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestForwardMessages_CopiesAttachmentAndOrigin(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	mockFiles := new(repoMocks.MockFileURLSigner)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, mockFiles)
+
+	ctx := context.Background()
+	userID := int64(1)
+	sourceConvID := int64(5)
+	targetConvID := int64(6)
+	bucket, object, clean := "chat-images", "2/cat.png", model.FileStatusClean
+	fileName := "cat.png"
+	mimeType := "image/png"
+	fileSize := int64(2048)
+
+	mockRepo.On("GetConversationByID", ctx, targetConvID).
+		Return(&model.Conversation{ID: targetConvID}, nil)
+	mockRepo.On("IsParticipant", ctx, targetConvID, userID).Return(true, nil)
+	mockRepo.On("IsParticipant", ctx, sourceConvID, userID).Return(true, nil).Once()
+
+	mockRepo.On("GetMessageByID", ctx, int64(30)).
+		Return(&model.Message{
+			ID: 30, ConversationID: sourceConvID, SenderID: 2, MessageType: "image",
+			FileName: &fileName, MimeType: &mimeType, FileSize: &fileSize,
+			FileRef: model.FileRef{FileBucket: &bucket, FileObject: &object, FileStatus: &clean},
+		}, nil)
+
+	// The copy points at the same object; its URL is only signed on the way out.
+	mockRepo.On("SaveMessage", ctx, mock.MatchedBy(func(msg *model.Message) bool {
+		return msg.ConversationID == targetConvID &&
+			msg.SenderID == userID &&
+			msg.FileURL == nil &&
+			*msg.FileObject == object &&
+			*msg.ForwardedFromSenderID == 2 &&
+			*msg.ForwardedFromConversationID == sourceConvID &&
+			*msg.ForwardedFromMessageID == 30
+	})).Return(nil)
+
+	mockRepo.On("GetParticipants", ctx, targetConvID).Return([]int64{userID, 3}, nil)
+	mockRedis.On("Publish", ctx, mock.AnythingOfType("model.Message"), []int64{3}).Return(nil)
+	mockFiles.On("GetFileURL", ctx, bucket, object, fileURLExpiry).Return("https://files/2/cat.png", nil)
+
+	messages, err := service.ForwardMessages(ctx, userID, []int64{30}, targetConvID)
+
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "image", messages[0].MessageType)
+	assert.Equal(t, "https://files/2/cat.png", *messages[0].FileURL)
+
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestForwardMessages_RejectsFileWithoutStoredObject(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	service := NewChatService(mockRepo, new(redisMocks.MockRedisClient), new(grpcMocks.MockUserClient), nil)
+
+	ctx := context.Background()
+	fileURL := "http://minio/chat-files/report.pdf"
+
+	mockRepo.On("GetConversationByID", ctx, int64(6)).Return(&model.Conversation{ID: 6}, nil)
+	mockRepo.On("IsParticipant", ctx, int64(6), int64(1)).Return(true, nil)
+	mockRepo.On("IsParticipant", ctx, int64(5), int64(1)).Return(true, nil)
+	mockRepo.On("GetMessageByID", ctx, int64(30)).
+		Return(&model.Message{ID: 30, ConversationID: 5, SenderID: 2, MessageType: "file", FileURL: &fileURL}, nil)
+
+	_, err := service.ForwardMessages(ctx, 1, []int64{30}, 6)
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "SaveMessage", mock.Anything, mock.Anything)
+}
+
+func TestForwardMessages_HidesInvisibleOrigin(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	userID := int64(1)
+	originSender := int64(7)
+	originConv := int64(99)
+	originMsg := int64(10)
+
+	mockRepo.On("GetConversationByID", ctx, int64(6)).
+		Return(&model.Conversation{ID: 6}, nil)
+	mockRepo.On("IsParticipant", ctx, int64(6), userID).Return(true, nil)
+	mockRepo.On("IsParticipant", ctx, int64(5), userID).Return(true, nil)
+	mockRepo.On("IsParticipant", ctx, originConv, userID).Return(false, nil)
+
+	mockRepo.On("GetMessageByID", ctx, int64(30)).
+		Return(&model.Message{
+			ID: 30, ConversationID: 5, SenderID: 2, Content: "fwd", MessageType: "text",
+			ForwardedFrom: model.ForwardedFrom{
+				ForwardedFromMessageID:      &originMsg,
+				ForwardedFromSenderID:       &originSender,
+				ForwardedFromConversationID: &originConv,
+			},
+		}, nil)
+
+	mockRepo.On("SaveMessage", ctx, mock.MatchedBy(func(msg *model.Message) bool {
+		return *msg.ForwardedFromSenderID == originSender &&
+			msg.ForwardedFromConversationID == nil &&
+			msg.ForwardedFromMessageID == nil
+	})).Return(nil)
+
+	mockRepo.On("GetParticipants", ctx, int64(6)).Return([]int64{userID}, nil)
+
+	_, err := service.ForwardMessages(ctx, userID, []int64{30}, 6)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestForwardMessages_NotParticipantOfSource(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+
+	mockRepo.On("GetConversationByID", ctx, int64(6)).
+		Return(&model.Conversation{ID: 6}, nil)
+	mockRepo.On("IsParticipant", ctx, int64(6), int64(1)).Return(true, nil)
+	mockRepo.On("IsParticipant", ctx, int64(5), int64(1)).Return(false, nil)
+
+	mockRepo.On("GetMessageByID", ctx, int64(30)).
+		Return(&model.Message{ID: 30, ConversationID: 5, SenderID: 2, MessageType: "text"}, nil)
+
+	messages, err := service.ForwardMessages(ctx, 1, []int64{30}, 6)
+
+	assert.Error(t, err)
+	assert.Nil(t, messages)
+	mockRepo.AssertNotCalled(t, "SaveMessage", mock.Anything, mock.Anything)
+}
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		ids       []int64
+		usernames []string
+	}{
+		{"none", "hello world", nil, nil},
+		{"username", "hi @Alice!", nil, []string{"alice"}},
+		{"user id", "@42 please check", []int64{42}, nil},
+		{"trailing dot", "thanks @bob.", nil, []string{"bob"}},
+		{"email ignored", "mail me at carol@example.com", nil, nil},
+		{"duplicates", "@bob @BOB @7 @7", []int64{7}, []string{"bob"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, usernames := parseMentions(tt.content)
+			assert.Equal(t, tt.ids, ids)
+			assert.Equal(t, tt.usernames, usernames)
+		})
+	}
+}
+
+func TestSendMessage_MentionsParticipants(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	senderID := int64(1)
+	conversationID := int64(5)
+	participants := []int64{1, 2, 3}
+
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID, IsGroup: true}, nil)
+	mockRepo.On("IsParticipant", ctx, conversationID, senderID).Return(true, nil)
+	mockRepo.On("GetParticipants", ctx, conversationID).Return(participants, nil)
+
+	mockUserClient.On("GetUsernames", ctx, participants).
+		Return(map[int64]string{1: "me", 2: "alice", 3: "bob"}, nil)
+
+	mockRepo.On("SaveMessage", ctx, mock.AnythingOfType("*model.Message")).Return(nil)
+	mockRepo.On("SetMessageMentions", ctx, int64(42), []int64{2, 3}).Return(nil)
+
+	mockRedis.On("Publish", ctx, mock.AnythingOfType("model.Message"), []int64{2, 3}).Return(nil)
+	mockRedis.On("PublishMention", ctx, mock.AnythingOfType("model.Message"), []int64{2, 3}).Return(nil)
+
+	message, err := service.SendMessage(ctx, senderID, 0, "@alice and @3, not @999 or @stranger", conversationID, "text", nil, nil, nil, nil, nil, model.Attachment{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 3}, message.Mentions)
+
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestEditMessage_NotifiesOnlyNewMentions(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	messageID := int64(100)
+	userID := int64(1)
+	conversationID := int64(5)
+
+	original := &model.Message{ID: messageID, ConversationID: conversationID, SenderID: userID, Content: "@2", Mentions: []int64{2}}
+	edited := &model.Message{ID: messageID, ConversationID: conversationID, SenderID: userID, Content: "@2 @3", Mentions: []int64{2, 3}}
+
+	mockRepo.On("GetMessageByID", ctx, messageID).Return(original, nil).Once()
+	mockRepo.On("GetMessageByID", ctx, messageID).Return(edited, nil).Once()
+	mockRepo.On("EditMessage", ctx, messageID, "@2 @3").Return(nil)
+	mockRepo.On("GetParticipants", ctx, conversationID).Return([]int64{1, 2, 3}, nil)
+	mockRepo.On("SetMessageMentions", ctx, messageID, []int64{2, 3}).Return(nil)
+
+	mockRedis.On("PublishMessageEdit", ctx, *edited, []int64{1, 2, 3}).Return(nil)
+	mockRedis.On("PublishMention", ctx, *edited, []int64{3}).Return(nil)
+
+	err := service.EditMessage(ctx, messageID, userID, "@2 @3")
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+	mockUserClient.AssertNotCalled(t, "GetUsernames", mock.Anything, mock.Anything)
+}
+
+func TestPinMessage_OneToOneParticipant(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	userID := int64(1)
+	messageID := int64(30)
+	conversationID := int64(5)
+
+	mockRepo.On("GetMessageByID", ctx, messageID).
+		Return(&model.Message{ID: messageID, ConversationID: conversationID, SenderID: 2, MessageType: "text"}, nil)
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID}, nil)
+	mockRepo.On("IsParticipant", ctx, conversationID, userID).Return(true, nil)
+
+	mockRepo.On("PinMessage", ctx, mock.MatchedBy(func(pin *model.PinnedMessage) bool {
+		return pin.ConversationID == conversationID && pin.MessageID == messageID && pin.PinnedBy == userID
+	})).Return(nil)
+
+	mockRepo.On("GetParticipants", ctx, conversationID).Return([]int64{1, 2}, nil)
+	mockRedis.On("PublishMessagePinned", ctx, mock.MatchedBy(func(pin model.PinnedMessage) bool {
+		return pin.Message != nil && pin.Message.ID == messageID
+	}), []int64{1, 2}).Return(nil)
+
+	err := service.PinMessage(ctx, userID, messageID)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestPinMessage_GroupMemberNotAllowed(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	userID := int64(1)
+	conversationID := int64(5)
+
+	mockRepo.On("GetMessageByID", ctx, int64(30)).
+		Return(&model.Message{ID: 30, ConversationID: conversationID, SenderID: 2, MessageType: "text"}, nil)
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID, IsGroup: true}, nil)
+	mockRepo.On("GetParticipant", ctx, conversationID, userID).
+		Return(&model.Participant{ConversationID: conversationID, UserID: userID, Role: model.RoleMember}, nil)
+
+	err := service.PinMessage(ctx, userID, 30)
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "PinMessage", mock.Anything, mock.Anything)
+	mockRedis.AssertNotCalled(t, "PublishMessagePinned", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUnpinMessage_GroupAdmin(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	userID := int64(1)
+	conversationID := int64(5)
+
+	mockRepo.On("GetMessageByID", ctx, int64(30)).
+		Return(&model.Message{ID: 30, ConversationID: conversationID, SenderID: 2, MessageType: "text"}, nil)
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID, IsGroup: true}, nil)
+	mockRepo.On("GetParticipant", ctx, conversationID, userID).
+		Return(&model.Participant{ConversationID: conversationID, UserID: userID, Role: model.RoleAdmin}, nil)
+	mockRepo.On("UnpinMessage", ctx, conversationID, int64(30)).Return(nil)
+	mockRepo.On("GetParticipants", ctx, conversationID).Return([]int64{1, 2, 3}, nil)
+	mockRedis.On("PublishMessageUnpinned", ctx, mock.MatchedBy(func(unpin model.UnpinnedMessage) bool {
+		return unpin.MessageID == 30 && unpin.UnpinnedBy == userID
+	}), []int64{1, 2, 3}).Return(nil)
+
+	err := service.UnpinMessage(ctx, userID, 30)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestUnpinMessage_DeletedMessage(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	userID := int64(1)
+	conversationID := int64(5)
+	deletedAt := time.Now()
+
+	mockRepo.On("GetMessageByID", ctx, int64(30)).
+		Return(&model.Message{ID: 30, ConversationID: conversationID, SenderID: 2, MessageType: "text", DeletedAt: &deletedAt}, nil)
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID}, nil)
+	mockRepo.On("IsParticipant", ctx, conversationID, userID).Return(true, nil)
+	mockRepo.On("UnpinMessage", ctx, conversationID, int64(30)).Return(nil)
+	mockRepo.On("GetParticipants", ctx, conversationID).Return([]int64{1, 2}, nil)
+	mockRedis.On("PublishMessageUnpinned", ctx, mock.AnythingOfType("model.UnpinnedMessage"), []int64{1, 2}).Return(nil)
+
+	err := service.UnpinMessage(ctx, userID, 30)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestPinMessage_DeletedMessage(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	userID := int64(1)
+	conversationID := int64(5)
+	deletedAt := time.Now()
+
+	mockRepo.On("GetMessageByID", ctx, int64(30)).
+		Return(&model.Message{ID: 30, ConversationID: conversationID, SenderID: 2, MessageType: "text", DeletedAt: &deletedAt}, nil)
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID}, nil)
+	mockRepo.On("IsParticipant", ctx, conversationID, userID).Return(true, nil)
+
+	err := service.PinMessage(ctx, userID, 30)
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "PinMessage", mock.Anything, mock.Anything)
+}
+
+func TestSearchMessages_NextCursor(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	userID := int64(1)
+	search := model.MessageSearch{Query: "invoice", ConversationID: 5}
+
+	mockRepo.On("IsParticipant", ctx, int64(5), userID).Return(true, nil)
+
+	results := []model.MessageSearchResult{
+		{Message: model.Message{ID: 90}, Snippet: "<mark>invoice</mark> sent"},
+		{Message: model.Message{ID: 70}, Snippet: "the <mark>invoice</mark>"},
+		{Message: model.Message{ID: 40}, Snippet: "old <mark>invoice</mark>"},
+	}
+	mockRepo.On("SearchMessages", ctx, userID, search, 3).Return(results, nil)
+
+	page, err := service.SearchMessages(ctx, userID, model.MessageSearch{Query: "  invoice ", ConversationID: 5}, 2)
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Results, 2)
+	assert.Equal(t, int64(70), *page.NextCursor)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestSearchMessages_NotParticipant(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+
+	mockRepo.On("IsParticipant", ctx, int64(5), int64(1)).Return(false, nil)
+
+	page, err := service.SearchMessages(ctx, 1, model.MessageSearch{Query: "invoice", ConversationID: 5}, 20)
+
+	assert.Error(t, err)
+	assert.Nil(t, page)
+	mockRepo.AssertNotCalled(t, "SearchMessages", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSearchMessages_EmptyQuery(t *testing.T) {
+	service := NewChatService(new(repoMocks.MockChatRepository), new(redisMocks.MockRedisClient), new(grpcMocks.MockUserClient), nil)
+
+	page, err := service.SearchMessages(context.Background(), 1, model.MessageSearch{Query: "   "}, 20)
+
+	assert.Error(t, err)
+	assert.Nil(t, page)
+}
+
+func TestAcknowledgeMessages_PublishesNewDeliveriesToSenders(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	userID := int64(2)
+	deliveredAt := time.Now()
+
+	// Message 11 was acknowledged before, so only 10 and 12 come back.
+	deliveries := []model.MessageDelivery{
+		{MessageID: 10, UserID: userID, DeliveredAt: deliveredAt, SenderID: 1},
+		{MessageID: 12, UserID: userID, DeliveredAt: deliveredAt, SenderID: 3},
+	}
+	mockRepo.On("MarkMessagesDelivered", ctx, userID, []int64{10, 11, 12}).Return(deliveries, nil)
+	mockRedis.On("PublishDeliveryReceipt", ctx, deliveries[0], []int64{1}).Return(nil)
+	mockRedis.On("PublishDeliveryReceipt", ctx, deliveries[1], []int64{3}).Return(nil)
+
+	err := service.AcknowledgeMessages(ctx, userID, []int64{10, 11, 12})
+
+	assert.NoError(t, err)
+	mockRedis.AssertExpectations(t)
+	mockRedis.AssertNumberOfCalls(t, "PublishDeliveryReceipt", 2)
+}
+
+func TestAcknowledgeMessages_RejectsOversizedBatch(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	service := NewChatService(mockRepo, new(redisMocks.MockRedisClient), new(grpcMocks.MockUserClient), nil)
+
+	err := service.AcknowledgeMessages(context.Background(), 2, make([]int64, maxAckBatch+1))
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "MarkMessagesDelivered", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSendMessage_ClientMsgIDEchoedToSender(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	senderID := int64(1)
+	conversationID := int64(5)
+	clientMsgID := "c-123"
+
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID}, nil)
+	mockRepo.On("IsParticipant", ctx, conversationID, senderID).Return(true, nil)
+	mockRepo.On("GetMessageByClientID", ctx, conversationID, senderID, clientMsgID).Return(nil, nil)
+	mockRepo.On("SaveMessage", ctx, mock.AnythingOfType("*model.Message")).Return(nil)
+	mockRepo.On("GetParticipants", ctx, conversationID).Return([]int64{senderID, 2}, nil)
+	mockRedis.On("Publish", ctx, mock.MatchedBy(func(msg model.Message) bool {
+		return msg.ClientMsgID != nil && *msg.ClientMsgID == clientMsgID
+	}), []int64{senderID, 2}).Return(nil)
+
+	message, err := service.SendMessage(ctx, senderID, 0, "hi", conversationID, "text", nil, nil, nil, nil, &clientMsgID, model.Attachment{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, clientMsgID, *message.ClientMsgID)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestSendMessage_RetryReturnsStoredMessage(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	senderID := int64(1)
+	conversationID := int64(5)
+	clientMsgID := "c-123"
+	stored := &model.Message{ID: 7, ConversationID: conversationID, SenderID: senderID, Content: "hi", ClientMsgID: &clientMsgID}
+
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID}, nil)
+	mockRepo.On("IsParticipant", ctx, conversationID, senderID).Return(true, nil)
+	mockRepo.On("GetMessageByClientID", ctx, conversationID, senderID, clientMsgID).Return(stored, nil)
+
+	message, err := service.SendMessage(ctx, senderID, 0, "hi", conversationID, "text", nil, nil, nil, nil, &clientMsgID, model.Attachment{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, stored, message)
+	mockRepo.AssertNotCalled(t, "SaveMessage", mock.Anything, mock.Anything)
+	mockRedis.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSendMessage_ConcurrentRetryReturnsStoredMessage(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+	senderID := int64(1)
+	conversationID := int64(5)
+	clientMsgID := "c-123"
+	stored := &model.Message{ID: 7, ConversationID: conversationID, SenderID: senderID, ClientMsgID: &clientMsgID}
+
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID}, nil)
+	mockRepo.On("IsParticipant", ctx, conversationID, senderID).Return(true, nil)
+	mockRepo.On("GetMessageByClientID", ctx, conversationID, senderID, clientMsgID).Return(nil, nil).Once()
+	mockRepo.On("SaveMessage", ctx, mock.AnythingOfType("*model.Message")).Return(ports.ErrDuplicateMessage)
+	mockRepo.On("GetMessageByClientID", ctx, conversationID, senderID, clientMsgID).Return(stored, nil).Once()
+
+	message, err := service.SendMessage(ctx, senderID, 0, "hi", conversationID, "text", nil, nil, nil, nil, &clientMsgID, model.Attachment{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, stored, message)
+	mockRedis.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestEditMessage_EmptyContent(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	err := service.EditMessage(context.Background(), 100, 1, "")
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "EditMessage", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestReaction_EmptyReaction(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockUserClient := new(grpcMocks.MockUserClient)
+
+	service := NewChatService(mockRepo, mockRedis, mockUserClient, nil)
+
+	ctx := context.Background()
+
+	assert.Error(t, service.AddReaction(ctx, 100, 1, ""))
+	assert.Error(t, service.RemoveReaction(ctx, 100, 1, ""))
+	mockRepo.AssertNotCalled(t, "AddReaction", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "RemoveReaction", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// SniffLength is how many leading bytes of a file DetectContentType looks at.
+const SniffLength = 512
+
+var ErrContentTypeMismatch = errors.New("file content does not match its type")
+
+const (
+	contentTypeUnknown = "application/octet-stream"
+	// contentTypeOLE is the compound file container of legacy Office
+	// documents, which only parsing the container can tell apart.
+	contentTypeOLE = "application/x-ole-storage"
+)
+
+type magicSignature struct {
+	Offset      int
+	Magic       []byte
+	ContentType string
+	// Container, when set, must also start the file, e.g. RIFF for formats
+	// identified by their RIFF form type.
+	Container []byte
+}
+
+func (sig magicSignature) matches(header []byte) bool {
+	if !bytes.HasPrefix(header, sig.Container) {
+		return false
+	}
+	end := sig.Offset + len(sig.Magic)
+	return len(header) >= end && bytes.Equal(header[sig.Offset:end], sig.Magic)
+}
+
+var riff = []byte("RIFF")
+
+var magicSignatures = []magicSignature{
+	{0, []byte("\xFF\xD8\xFF"), "image/jpeg", nil},
+	{0, []byte("\x89PNG\r\n\x1A\n"), "image/png", nil},
+	{0, []byte("GIF87a"), "image/gif", nil},
+	{0, []byte("GIF89a"), "image/gif", nil},
+	{8, []byte("WEBP"), "image/webp", riff},
+	{8, []byte("WAVE"), "audio/wav", riff},
+	{8, []byte("AVI "), "video/x-msvideo", riff},
+	{0, []byte("%PDF-"), "application/pdf", nil},
+	{0, []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"), contentTypeOLE, nil},
+	{0, []byte("PK\x03\x04"), "application/zip", nil},
+	{0, []byte("PK\x05\x06"), "application/zip", nil},
+	{0, []byte("Rar!\x1A\x07"), "application/x-rar-compressed", nil},
+	{0, []byte("ID3"), "audio/mpeg", nil},
+	{0, []byte("OggS"), "audio/ogg", nil},
+	{0, []byte("\x1A\x45\xDF\xA3"), "video/webm", nil},
+	{4, []byte("ftypqt  "), "video/quicktime", nil},
+	{4, []byte("ftypM4A "), "audio/m4a", nil},
+	{4, []byte("ftyp"), "video/mp4", nil},
+	{0, []byte("\x00\x00\x01\xBA"), "video/mpeg", nil},
+	{0, []byte("\x00\x00\x01\xB3"), "video/mpeg", nil},
+	{0, []byte("MZ"), "application/x-msdownload", nil},
+	{0, []byte("\x7FELF"), "application/x-executable", nil},
+}
+
+// contentTypeAliases maps other names clients use for a format to the one
+// DetectContentType reports.
+var contentTypeAliases = map[string]string{
+	"image/jpg":   "image/jpeg",
+	"audio/mp3":   "audio/mpeg",
+	"audio/wave":  "audio/wav",
+	"audio/x-wav": "audio/wav",
+}
+
+// contentTypeRefinements lists the more specific types a detected container
+// format may hold. A claimed type from this list is kept as is.
+var contentTypeRefinements = map[string][]string{
+	"application/zip": {
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	},
+	contentTypeOLE: {"application/msword", "application/vnd.ms-excel"},
+	"text/plain":   {"text/csv"},
+	"audio/ogg":    {"video/ogg"},
+	"video/webm":   {"audio/webm"},
+	"video/mp4":    {"audio/m4a"},
+}
+
+// DetectContentType identifies a file by the magic number in its leading
+// bytes. Files without one are text/plain if they are UTF-8 text and
+// application/octet-stream otherwise.
+func DetectContentType(header []byte) string {
+	for _, sig := range magicSignatures {
+		if sig.matches(header) {
+			return sig.ContentType
+		}
+	}
+
+	// MPEG audio without an ID3 tag starts with a frame sync. Layer bits of
+	// zero mark AAC in an ADTS stream instead.
+	if len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0 {
+		if header[1]&0x06 == 0 {
+			return "audio/aac"
+		}
+		return "audio/mpeg"
+	}
+
+	if len(header) > 0 && isText(header) {
+		return "text/plain"
+	}
+	return contentTypeUnknown
+}
+
+// ResolveContentType checks a client's claimed type against the file's
+// leading bytes and returns the type to store for it. Without a claim the
+// detected type is used.
+func ResolveContentType(header []byte, claimed string) (string, error) {
+	detected := DetectContentType(header)
+	if claimed == "" || claimed == contentTypeUnknown {
+		return detected, nil
+	}
+
+	canonical := claimed
+	if alias, ok := contentTypeAliases[claimed]; ok {
+		canonical = alias
+	}
+	if canonical == detected {
+		return detected, nil
+	}
+
+	for _, refinement := range contentTypeRefinements[detected] {
+		if canonical == refinement {
+			return canonical, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: claimed %s, detected %s", ErrContentTypeMismatch, claimed, detected)
+}
+
+func isText(header []byte) bool {
+	if bytes.IndexByte(header, 0) >= 0 {
+		return false
+	}
+	// The header may cut a multi-byte character short.
+	for i := 0; i < utf8.UTFMax && len(header) > 0; i++ {
+		if utf8.Valid(header) {
+			return true
+		}
+		header = header[:len(header)-1]
+	}
+	return false
+}
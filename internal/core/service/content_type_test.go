@@ -0,0 +1,128 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fileHeaders are the leading bytes of real files of every supported type,
+// plus a few that must never pass as one.
+var fileHeaders = map[string][]byte{
+	"jpeg":      []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"),
+	"jpeg_exif": []byte("\xFF\xD8\xFF\xE1\x2F\xFEExif\x00\x00MM\x00\x2A"),
+	"png":       []byte("\x89PNG\r\n\x1A\n\x00\x00\x00\rIHDR\x00\x00\x03\x20\x00\x00\x02\x58\x08\x06\x00\x00\x00"),
+	"gif87":     []byte("GIF87a\x40\x01\xF0\x00\xF7\x00\x00"),
+	"gif89":     []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\xFF\xFF\xFF"),
+	"webp":      []byte("RIFF\x24\x1B\x00\x00WEBPVP8 \x18\x1B\x00\x00"),
+	"wav":       []byte("RIFF\x24\x08\x00\x00WAVEfmt \x10\x00\x00\x00\x01\x00\x02\x00"),
+	"avi":       []byte("RIFF\xF8\x2B\x0B\x00AVI LIST\xEC\x11\x00\x00hdrl"),
+	"pdf":       []byte("%PDF-1.7\n%\xE2\xE3\xCF\xD3\n1 0 obj\n"),
+	"docx":      []byte("PK\x03\x04\x14\x00\x06\x00\x08\x00\x00\x00!\x00\xDF\xA4\xD2\x6C[Content_Types].xml"),
+	"zip_empty": []byte("PK\x05\x06\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"),
+	"doc":       []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x3E\x00\x03\x00\xFE\xFF"),
+	"rar":       []byte("Rar!\x1A\x07\x00\xCF\x90\x73\x00\x00\x0D\x00\x00\x00"),
+	"rar5":      []byte("Rar!\x1A\x07\x01\x00\x33\x92\xB5\xE5\x0A\x01\x05\x06\x00"),
+	"mp3_id3":   []byte("ID3\x04\x00\x00\x00\x00\x00\x23TSSE\x00\x00\x00\x0F\x00\x00"),
+	"mp3_frame": []byte("\xFF\xFB\x90\x64\x00\x0F\xF0\x00\x00\x69\x00\x00\x00\x08\x00\x00"),
+	"aac_adts":  []byte("\xFF\xF1\x50\x80\x2E\x7F\xFC\x21\x1A\xD4\x52\x2A\x30\x18\x9B\x20"),
+	"ogg":       []byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x3E\x5D\x00\x00\x00\x00\x00\x00"),
+	"webm":      []byte("\x1A\x45\xDF\xA3\x9F\x42\x86\x81\x01\x42\xF7\x81\x01\x42\xF2\x81\x04webm"),
+	"mp4":       []byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00isomiso2avc1mp41"),
+	"mp4_mp42":  []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom"),
+	"mov":       []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00qt  \x00\x00\x00\x08wide"),
+	"m4a":       []byte("\x00\x00\x00\x20ftypM4A \x00\x00\x02\x00M4A mp42isom\x00\x00"),
+	"mpeg":      []byte("\x00\x00\x01\xBA\x44\x00\x04\x00\x04\x01\x01\x89\xC3\xF8"),
+	"mpeg_es":   []byte("\x00\x00\x01\xB3\x16\x00\xF0\x15\xFF\xFF\xE0\x18"),
+	"exe":       []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xFF\xFF\x00\x00\xB8\x00"),
+	"elf":       []byte("\x7FELF\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x03\x00\x3E\x00"),
+	"text":      []byte("Meeting notes\n- ship the release\n"),
+	"csv":       []byte("id,name,email\n1,Alice,alice@example.com\n"),
+	"utf8_cut":  []byte("Привет, мир! \xD0"),
+	"binary":    []byte("\x00\x01\x02\x03\xFE\xFD\xFC"),
+	"empty":     {},
+}
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"jpeg", "image/jpeg"},
+		{"jpeg_exif", "image/jpeg"},
+		{"png", "image/png"},
+		{"gif87", "image/gif"},
+		{"gif89", "image/gif"},
+		{"webp", "image/webp"},
+		{"wav", "audio/wav"},
+		{"avi", "video/x-msvideo"},
+		{"pdf", "application/pdf"},
+		{"docx", "application/zip"},
+		{"zip_empty", "application/zip"},
+		{"doc", contentTypeOLE},
+		{"rar", "application/x-rar-compressed"},
+		{"rar5", "application/x-rar-compressed"},
+		{"mp3_id3", "audio/mpeg"},
+		{"mp3_frame", "audio/mpeg"},
+		{"aac_adts", "audio/aac"},
+		{"ogg", "audio/ogg"},
+		{"webm", "video/webm"},
+		{"mp4", "video/mp4"},
+		{"mp4_mp42", "video/mp4"},
+		{"mov", "video/quicktime"},
+		{"m4a", "audio/m4a"},
+		{"mpeg", "video/mpeg"},
+		{"mpeg_es", "video/mpeg"},
+		{"exe", "application/x-msdownload"},
+		{"elf", "application/x-executable"},
+		{"text", "text/plain"},
+		{"csv", "text/plain"},
+		{"utf8_cut", "text/plain"},
+		{"binary", contentTypeUnknown},
+		{"empty", contentTypeUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			assert.Equal(t, tt.want, DetectContentType(fileHeaders[tt.file]))
+		})
+	}
+}
+
+func TestResolveContentType(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		claimed string
+		want    string
+		wantErr bool
+	}{
+		{"matching type", "png", "image/png", "image/png", false},
+		{"alias is normalized", "jpeg", "image/jpg", "image/jpeg", false},
+		{"mp3 alias", "mp3_frame", "audio/mp3", "audio/mpeg", false},
+		{"docx inside zip", "docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", false},
+		{"xls inside ole", "doc", "application/vnd.ms-excel", "application/vnd.ms-excel", false},
+		{"csv is text", "csv", "text/csv", "text/csv", false},
+		{"audio only webm", "webm", "audio/webm", "audio/webm", false},
+		{"no claim uses detected", "pdf", "", "application/pdf", false},
+		{"octet stream uses detected", "gif89", "application/octet-stream", "image/gif", false},
+		{"executable renamed to png", "exe", "image/png", "", true},
+		{"elf claimed as pdf", "elf", "application/pdf", "", true},
+		{"png claimed as jpeg", "png", "image/jpeg", "", true},
+		{"binary claimed as text", "binary", "text/plain", "", true},
+		{"zip claimed as word document", "zip_empty", "application/msword", "", true},
+		{"mov claimed as mp4", "mov", "video/mp4", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveContentType(fileHeaders[tt.file], tt.claimed)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrContentTypeMismatch)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/ports"
+)
+
+// fileURLExpiry is how long the download URLs signed for files and
+// thumbnails stay valid. They are signed anew whenever a message is read.
+const fileURLExpiry = 24 * time.Hour
+
+// ImageService derives what clients need to render an image message without
+// downloading the full file: its dimensions, a blurhash and thumbnails.
+type ImageService struct {
+	minio *MinioService
+}
+
+func NewImageService(minio *MinioService) *ImageService {
+	return &ImageService{minio: minio}
+}
+
+// Process decodes an image stored in the images bucket and stores its
+// thumbnails next to it. The thumbnails are returned without URLs; see
+// SignThumbnails. ErrUnsupportedImage is returned for formats the
+// standard library cannot decode.
+func (s *ImageService) Process(ctx context.Context, objectName string) (model.ImageInfo, error) {
+	obj, err := s.minio.GetFile(ctx, ImagesBucket, objectName)
+	if err != nil {
+		return model.ImageInfo{}, err
+	}
+	defer obj.Close()
+
+	processed, err := processImage(obj)
+	if err != nil {
+		return model.ImageInfo{}, err
+	}
+
+	info := model.ImageInfo{
+		ImageWidth:  &processed.Width,
+		ImageHeight: &processed.Height,
+		Blurhash:    &processed.Blurhash,
+	}
+
+	for _, thumb := range processed.Thumbnails {
+		name := thumbnailObjectName(objectName, thumb.Name, thumb.Ext)
+		if err := s.minio.UploadFile(ctx, ImagesBucket, name, bytes.NewReader(thumb.Data), int64(len(thumb.Data)), thumb.ContentType); err != nil {
+			s.DeleteThumbnails(ctx, objectName)
+			return model.ImageInfo{}, err
+		}
+
+		info.Thumbnails = append(info.Thumbnails, model.Thumbnail{
+			Name:   thumb.Name,
+			Object: name,
+			Width:  thumb.Width,
+			Height: thumb.Height,
+		})
+	}
+
+	return info, nil
+}
+
+// SignThumbnails returns a copy of thumbs with download URLs signed for the
+// stored thumbnails. A thumbnail that cannot be signed is left without one.
+func SignThumbnails(ctx context.Context, signer ports.FileURLSigner, thumbs model.Thumbnails) model.Thumbnails {
+	if thumbs == nil {
+		return nil
+	}
+
+	signed := make(model.Thumbnails, len(thumbs))
+	for i, thumb := range thumbs {
+		signed[i] = thumb
+		if thumb.Object == "" {
+			continue
+		}
+		url, err := signer.GetFileURL(ctx, ImagesBucket, thumb.Object, fileURLExpiry)
+		if err != nil {
+			log.Printf("Failed to sign thumbnail %s: %v", thumb.Object, err)
+			continue
+		}
+		signed[i].URL = url
+	}
+	return signed
+}
+
+// DeleteThumbnails removes the thumbnails stored for an image.
+func (s *ImageService) DeleteThumbnails(ctx context.Context, objectName string) {
+	for _, size := range thumbnailSizes {
+		for _, ext := range []string{".jpg", ".png"} {
+			_ = s.minio.DeleteFile(ctx, ImagesBucket, thumbnailObjectName(objectName, size.Name, ext))
+		}
+	}
+}
+
+// thumbnailObjectName places a thumbnail next to its image, e.g.
+// 1/abc_small.jpg for 1/abc.png.
+func thumbnailObjectName(objectName, size, ext string) string {
+	return strings.TrimSuffix(objectName, path.Ext(objectName)) + "_" + size + ext
+}
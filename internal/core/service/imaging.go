@@ -0,0 +1,290 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+)
+
+// maxImagePixels bounds the size of decoded images, so a small file cannot
+// claim huge dimensions and exhaust memory.
+const maxImagePixels = 32 << 20
+
+const (
+	blurhashComponentsX = 4
+	blurhashComponentsY = 3
+	// blurhashSampleSide is the size images are scaled down to before the
+	// placeholder is computed. It holds far more detail than 4x3 components
+	// can represent.
+	blurhashSampleSide   = 32
+	thumbnailJPEGQuality = 80
+)
+
+// thumbnailSizes are the thumbnails made for every image, by the longest
+// side. Images already smaller than a size do not get that thumbnail.
+var thumbnailSizes = []struct {
+	Name    string
+	MaxSide int
+}{
+	{Name: "small", MaxSide: 160},
+	{Name: "medium", MaxSide: 480},
+}
+
+var ErrUnsupportedImage = errors.New("image format cannot be decoded")
+
+type processedImage struct {
+	Width      int
+	Height     int
+	Blurhash   string
+	Thumbnails []encodedThumbnail
+}
+
+type encodedThumbnail struct {
+	Name        string
+	Width       int
+	Height      int
+	Ext         string
+	ContentType string
+	Data        []byte
+}
+
+// processImage decodes an image and prepares its thumbnails and blurhash.
+func processImage(r io.Reader) (*processedImage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxImagePixels {
+		return nil, fmt.Errorf("image dimensions %dx%d are not supported", config.Width, config.Height)
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	src := toRGBA(decoded)
+	opaque := src.Opaque()
+
+	result := &processedImage{
+		Width:  src.Bounds().Dx(),
+		Height: src.Bounds().Dy(),
+	}
+
+	for _, size := range thumbnailSizes {
+		if result.Width <= size.MaxSide && result.Height <= size.MaxSide {
+			continue
+		}
+
+		width, height := fitWithin(result.Width, result.Height, size.MaxSide)
+		thumb, err := encodeThumbnail(scaleDown(src, width, height), opaque)
+		if err != nil {
+			return nil, err
+		}
+		thumb.Name = size.Name
+		result.Thumbnails = append(result.Thumbnails, *thumb)
+	}
+
+	width, height := fitWithin(result.Width, result.Height, blurhashSampleSide)
+	result.Blurhash = encodeBlurhash(scaleDown(src, width, height), blurhashComponentsX, blurhashComponentsY)
+
+	return result, nil
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// fitWithin scales width and height down so the longest side is maxSide,
+// keeping the aspect ratio.
+func fitWithin(width, height, maxSide int) (int, int) {
+	if width <= maxSide && height <= maxSide {
+		return width, height
+	}
+	if width >= height {
+		return maxSide, max(1, height*maxSide/width)
+	}
+	return max(1, width*maxSide/height), maxSide
+}
+
+// scaleDown resizes src to width x height by averaging the block of source
+// pixels behind every destination pixel.
+func scaleDown(src *image.RGBA, width, height int) *image.RGBA {
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for dy := 0; dy < height; dy++ {
+		y0 := dy * srcH / height
+		y1 := max(y0+1, (dy+1)*srcH/height)
+		for dx := 0; dx < width; dx++ {
+			x0 := dx * srcW / width
+			x1 := max(x0+1, (dx+1)*srcW/width)
+
+			var r, g, b, a, n int
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride:]
+				for x := x0; x < x1; x++ {
+					p := row[x*4 : x*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+
+			i := dy*dst.Stride + dx*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// encodeThumbnail stores opaque thumbnails as JPEG and keeps transparency by
+// using PNG otherwise.
+func encodeThumbnail(img *image.RGBA, opaque bool) (*encodedThumbnail, error) {
+	var buf bytes.Buffer
+	thumb := &encodedThumbnail{
+		Width:  img.Bounds().Dx(),
+		Height: img.Bounds().Dy(),
+	}
+
+	if opaque {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailJPEGQuality}); err != nil {
+			return nil, err
+		}
+		thumb.Ext, thumb.ContentType = ".jpg", "image/jpeg"
+	} else {
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+		thumb.Ext, thumb.ContentType = ".png", "image/png"
+	}
+
+	thumb.Data = buf.Bytes()
+	return thumb, nil
+}
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// encodeBlurhash computes a BlurHash (https://blurha.sh): the image's
+// average color plus a few cosine components, packed into a short string
+// clients decode into a blurred placeholder.
+func encodeBlurhash(img *image.RGBA, componentsX, componentsY int) string {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	// Linear values of every pixel, computed once for all components.
+	linear := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := img.Pix[y*img.Stride+x*4:]
+			linear[y*width+x] = [3]float64{srgbToLinear(p[0]), srgbToLinear(p[1]), srgbToLinear(p[2])}
+		}
+	}
+
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for j := 0; j < componentsY; j++ {
+		for i := 0; i < componentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				basisY := math.Cos(math.Pi * float64(j) * float64(y) / float64(height))
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) * basisY
+					pixel := linear[y*width+x]
+					factor[0] += basis * pixel[0]
+					factor[1] += basis * pixel[1]
+					factor[2] += basis * pixel[2]
+				}
+			}
+
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encodeBase83((componentsX-1)+(componentsY-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+	maximumValue := 1.0
+	if len(ac) > 0 {
+		actualMax := 0.0
+		for _, factor := range ac {
+			actualMax = math.Max(actualMax, math.Max(math.Abs(factor[0]), math.Max(math.Abs(factor[1]), math.Abs(factor[2]))))
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maximumValue = float64(quantisedMax+1) / 166
+		hash.WriteString(encodeBase83(quantisedMax, 1))
+	} else {
+		hash.WriteString(encodeBase83(0, 1))
+	}
+
+	hash.WriteString(encodeBase83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+	for _, factor := range ac {
+		hash.WriteString(encodeBase83(encodeACComponent(factor, maximumValue), 2))
+	}
+
+	return hash.String()
+}
+
+func encodeACComponent(factor [3]float64, maximumValue float64) int {
+	quantise := func(v float64) int {
+		return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximumValue, 0.5)*9+9.5))))
+	}
+	return quantise(factor[0])*19*19 + quantise(factor[1])*19 + quantise(factor[2])
+}
+
+func encodeBase83(value, length int) string {
+	result := make([]byte, length)
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		result[i-1] = base83Chars[digit]
+	}
+	return string(result)
+}
+
+func srgbToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
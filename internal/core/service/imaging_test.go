@@ -0,0 +1,92 @@
+package service
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func solidImage(width, height int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestEncodeBlurhash_SolidBlack(t *testing.T) {
+	hash := encodeBlurhash(solidImage(32, 32, color.Black), 4, 3)
+
+	assert.Equal(t, "L00000fQfQfQfQfQfQfQfQfQfQfQ", hash)
+}
+
+func TestEncodeBlurhash_EncodesAverageColor(t *testing.T) {
+	hash := encodeBlurhash(solidImage(16, 8, color.RGBA{R: 255, G: 0, B: 0, A: 255}), 4, 3)
+
+	assert.Len(t, hash, 28)
+	assert.Equal(t, encodeBase83(0xff0000, 4), hash[2:6])
+}
+
+func TestProcessImage_MakesThumbnailsKeepingAspectRatio(t *testing.T) {
+	data := encodePNG(t, solidImage(800, 400, color.White))
+
+	result, err := processImage(bytes.NewReader(data))
+
+	require.NoError(t, err)
+	assert.Equal(t, 800, result.Width)
+	assert.Equal(t, 400, result.Height)
+	assert.Len(t, result.Blurhash, 28)
+	require.Len(t, result.Thumbnails, 2)
+
+	small := result.Thumbnails[0]
+	assert.Equal(t, "small", small.Name)
+	assert.Equal(t, 160, small.Width)
+	assert.Equal(t, 80, small.Height)
+	assert.Equal(t, "image/jpeg", small.ContentType)
+
+	decoded, err := jpeg.Decode(bytes.NewReader(small.Data))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 160, 80), decoded.Bounds())
+
+	medium := result.Thumbnails[1]
+	assert.Equal(t, "medium", medium.Name)
+	assert.Equal(t, 480, medium.Width)
+	assert.Equal(t, 240, medium.Height)
+}
+
+func TestProcessImage_SmallTransparentImage(t *testing.T) {
+	data := encodePNG(t, solidImage(300, 200, color.Transparent))
+
+	result, err := processImage(bytes.NewReader(data))
+
+	require.NoError(t, err)
+	require.Len(t, result.Thumbnails, 1)
+	assert.Equal(t, "small", result.Thumbnails[0].Name)
+	assert.Equal(t, "image/png", result.Thumbnails[0].ContentType)
+}
+
+func TestProcessImage_RejectsUndecodableData(t *testing.T) {
+	_, err := processImage(strings.NewReader("not an image"))
+
+	assert.ErrorIs(t, err, ErrUnsupportedImage)
+}
+
+func TestThumbnailObjectName(t *testing.T) {
+	assert.Equal(t, "1/abc_small.jpg", thumbnailObjectName("1/abc.png", "small", ".jpg"))
+	assert.Equal(t, "1/abc_medium.png", thumbnailObjectName("1/abc", "medium", ".png"))
+}
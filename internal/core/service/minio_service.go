@@ -3,13 +3,19 @@ package service
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
+// ImagesBucket holds image files and their thumbnails.
+const ImagesBucket = "chat-images"
+
 type MinioConfig struct {
 	Endpoint  string
 	AccessKey string
@@ -19,6 +25,7 @@ type MinioConfig struct {
 
 type MinioService struct {
 	client *minio.Client
+	core   minio.Core
 }
 
 func NewMinioService(cfg MinioConfig) (*MinioService, error) {
@@ -32,7 +39,7 @@ func NewMinioService(cfg MinioConfig) (*MinioService, error) {
 
 	log.Printf("MinIO client initialized: %s", cfg.Endpoint)
 
-	service := &MinioService{client: client}
+	service := &MinioService{client: client, core: minio.Core{Client: client}}
 	if err := service.initBuckets(context.Background()); err != nil {
 		return nil, err
 	}
@@ -42,7 +49,7 @@ func NewMinioService(cfg MinioConfig) (*MinioService, error) {
 
 func (s *MinioService) initBuckets(ctx context.Context) error {
 	buckets := []string{
-		"chat-images",
+		ImagesBucket,
 		"chat-files",
 		"chat-audio",
 		"chat-video",
@@ -93,6 +100,10 @@ func (s *MinioService) UploadFile(ctx context.Context, bucket, objectName string
 	return err
 }
 
+func (s *MinioService) GetFile(ctx context.Context, bucket, objectName string) (io.ReadCloser, error) {
+	return s.client.GetObject(ctx, bucket, objectName, minio.GetObjectOptions{})
+}
+
 func (s *MinioService) GetFileURL(ctx context.Context, bucket, objectName string, expires time.Duration) (string, error) {
 	url, err := s.client.PresignedGetObject(ctx, bucket, objectName, expires, nil)
 	if err != nil {
@@ -101,10 +112,68 @@ func (s *MinioService) GetFileURL(ctx context.Context, bucket, objectName string
 	return url.String(), nil
 }
 
+// PresignedPutURL lets a client PUT one object straight to storage. The
+// content type and exact length are signed, so the request must carry them.
+func (s *MinioService) PresignedPutURL(ctx context.Context, bucket, objectName, contentType string, size int64, expires time.Duration) (string, http.Header, error) {
+	headers := http.Header{}
+	headers.Set("Content-Type", contentType)
+	headers.Set("Content-Length", strconv.FormatInt(size, 10))
+
+	url, err := s.client.PresignHeader(ctx, http.MethodPut, bucket, objectName, expires, nil, headers)
+	if err != nil {
+		return "", nil, err
+	}
+	return url.String(), headers, nil
+}
+
+// PresignedPostPolicy lets a client upload one object with a browser form
+// POST, limited to the content type and at most maxSize bytes.
+func (s *MinioService) PresignedPostPolicy(ctx context.Context, bucket, objectName, contentType string, maxSize int64, expires time.Duration) (string, map[string]string, error) {
+	policy := minio.NewPostPolicy()
+	if err := policy.SetBucket(bucket); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetKey(objectName); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetContentType(contentType); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetContentLengthRange(1, maxSize); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetExpires(time.Now().UTC().Add(expires)); err != nil {
+		return "", nil, err
+	}
+
+	url, formData, err := s.client.PresignedPostPolicy(ctx, policy)
+	if err != nil {
+		return "", nil, err
+	}
+	return url.String(), formData, nil
+}
+
 func (s *MinioService) DeleteFile(ctx context.Context, bucket, objectName string) error {
 	return s.client.RemoveObject(ctx, bucket, objectName, minio.RemoveObjectOptions{})
 }
 
+func (s *MinioService) FileSize(ctx context.Context, bucket, objectName string) (int64, error) {
+	info, err := s.client.StatObject(ctx, bucket, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// CopyFile copies an object within a bucket on the storage side.
+func (s *MinioService) CopyFile(ctx context.Context, bucket, srcObject, dstObject string) error {
+	_, err := s.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: bucket, Object: dstObject},
+		minio.CopySrcOptions{Bucket: bucket, Object: srcObject},
+	)
+	return err
+}
+
 func (s *MinioService) GetFileInfo(ctx context.Context, bucket, objectName string) (*minio.ObjectInfo, error) {
 	info, err := s.client.StatObject(ctx, bucket, objectName, minio.StatObjectOptions{})
 	if err != nil {
@@ -113,10 +182,49 @@ func (s *MinioService) GetFileInfo(ctx context.Context, bucket, objectName strin
 	return &info, nil
 }
 
+func (s *MinioService) NewMultipartUpload(ctx context.Context, bucket, objectName, contentType string) (string, error) {
+	return s.core.NewMultipartUpload(ctx, bucket, objectName, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+}
+
+// UploadPart stores one part of a multipart upload. Uploading a part number
+// again replaces the part, so a failed chunk can simply be retried.
+func (s *MinioService) UploadPart(ctx context.Context, bucket, objectName, uploadID string, partNumber int, reader io.Reader, size int64) error {
+	_, err := s.core.PutObjectPart(ctx, bucket, objectName, uploadID, partNumber, reader, size, minio.PutObjectPartOptions{})
+	return err
+}
+
+// CompleteMultipartUpload joins every part uploaded so far into the object.
+func (s *MinioService) CompleteMultipartUpload(ctx context.Context, bucket, objectName, uploadID string) error {
+	var parts []minio.CompletePart
+	marker := 0
+	for {
+		result, err := s.core.ListObjectParts(ctx, bucket, objectName, uploadID, marker, 1000)
+		if err != nil {
+			return err
+		}
+		for _, part := range result.ObjectParts {
+			parts = append(parts, minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag})
+		}
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextPartNumberMarker
+	}
+
+	_, err := s.core.CompleteMultipartUpload(ctx, bucket, objectName, uploadID, parts, minio.PutObjectOptions{})
+	return err
+}
+
+func (s *MinioService) AbortMultipartUpload(ctx context.Context, bucket, objectName, uploadID string) error {
+	return s.core.AbortMultipartUpload(ctx, bucket, objectName, uploadID)
+}
+
 func DetermineBucket(mimeType string) string {
 	switch {
 	case isImage(mimeType):
-		return "chat-images"
+		return ImagesBucket
 	case isAudio(mimeType):
 		return "chat-audio"
 	case isVideo(mimeType):
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/ports"
+	redisAdapter "github.com/zhanserikAmangeldi/chat-service/internal/redis"
+)
+
+const (
+	maxPresenceLookup  = 200
+	presenceSweepBatch = 500
+)
+
+// PresenceService derives users' online status from their WebSocket sessions
+// and announces changes to their contacts.
+type PresenceService struct {
+	repo     ports.ChatRepository
+	redis    redisAdapter.IRedisClient
+	sessions redisAdapter.IPresenceStore
+	ttl      time.Duration
+}
+
+// NewPresenceService creates a presence service whose session entries expire
+// after ttl unless refreshed by a heartbeat.
+func NewPresenceService(repo ports.ChatRepository, redis redisAdapter.IRedisClient, sessions redisAdapter.IPresenceStore, ttl time.Duration) *PresenceService {
+	return &PresenceService{
+		repo:     repo,
+		redis:    redis,
+		sessions: sessions,
+		ttl:      ttl,
+	}
+}
+
+// Connect registers a new session and announces the user as online if it is
+// their first live session on any instance.
+func (s *PresenceService) Connect(ctx context.Context, userID int64, sessionID string) error {
+	before, err := s.status(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.sessions.SetSession(ctx, userID, sessionID, model.PresenceOnline, s.ttl); err != nil {
+		return err
+	}
+
+	if before != model.PresenceOnline {
+		s.announce(ctx, model.OnlineStatusEvent{UserID: userID, Status: model.PresenceOnline})
+	}
+	return nil
+}
+
+// Heartbeat keeps a session alive. It is called whenever the client answers
+// a ping.
+func (s *PresenceService) Heartbeat(ctx context.Context, userID int64, sessionID string) error {
+	return s.sessions.RefreshSession(ctx, userID, sessionID, s.ttl)
+}
+
+// SetStatus switches one session between online and away, e.g. when the app
+// goes to the background, and announces the user's combined status if it
+// changed.
+func (s *PresenceService) SetStatus(ctx context.Context, userID int64, sessionID, status string) error {
+	if status != model.PresenceOnline && status != model.PresenceAway {
+		return errors.New("status must be online or away")
+	}
+
+	before, err := s.status(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.sessions.SetSession(ctx, userID, sessionID, status, s.ttl); err != nil {
+		return err
+	}
+
+	after, err := s.status(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if after != before {
+		s.announce(ctx, model.OnlineStatusEvent{UserID: userID, Status: after})
+	}
+	return nil
+}
+
+// Disconnect removes a session. When it was the user's last live session the
+// user's last seen time is recorded and they are announced as offline.
+func (s *PresenceService) Disconnect(ctx context.Context, userID int64, sessionID string) error {
+	if err := s.sessions.RemoveSession(ctx, userID, sessionID); err != nil {
+		return err
+	}
+
+	after, err := s.status(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if after != model.PresenceOffline {
+		return nil
+	}
+
+	lastSeen := time.Now()
+	claimed, err := s.sessions.ClaimOffline(ctx, userID, lastSeen)
+	if err != nil {
+		return err
+	}
+	// SweepExpired already announced the user offline.
+	if !claimed {
+		return nil
+	}
+
+	s.goOffline(ctx, userID, lastSeen)
+	return nil
+}
+
+// SweepExpired announces offline the users whose last sessions expired
+// without disconnecting, e.g. because their instance crashed, and records
+// their last heartbeat as their last seen time. It returns how many users
+// went offline.
+func (s *PresenceService) SweepExpired(ctx context.Context) (int, error) {
+	before := time.Now().Add(-s.ttl)
+	stale, err := s.sessions.StaleUsers(ctx, before, presenceSweepBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	offline := 0
+	for userID, lastSeen := range stale {
+		status, err := s.status(ctx, userID)
+		if err != nil {
+			return offline, err
+		}
+		if status != model.PresenceOffline {
+			continue
+		}
+
+		claimed, err := s.sessions.ClaimOffline(ctx, userID, before)
+		if err != nil {
+			return offline, err
+		}
+		if !claimed {
+			continue
+		}
+
+		s.goOffline(ctx, userID, lastSeen)
+		offline++
+	}
+	return offline, nil
+}
+
+// GetPresence looks up the status and last seen time of several users as
+// seen by viewerID, hiding users whose privacy setting excludes the viewer.
+func (s *PresenceService) GetPresence(ctx context.Context, viewerID int64, userIDs []int64) ([]model.Presence, error) {
+	if len(userIDs) == 0 {
+		return []model.Presence{}, nil
+	}
+	if len(userIDs) > maxPresenceLookup {
+		return nil, errors.New("too many users requested")
+	}
+
+	settings, err := s.repo.GetPresenceSettings(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var contacts map[int64]bool
+	for _, id := range userIDs {
+		if id != viewerID && settings[id].Visibility == model.VisibilityContacts {
+			contacts, err = s.contactSet(ctx, viewerID)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	statuses, err := s.sessions.GetStatuses(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	presences := make([]model.Presence, 0, len(userIDs))
+	for _, id := range userIDs {
+		setting := settings[id]
+		visible := id == viewerID ||
+			setting.Visibility == model.VisibilityEveryone ||
+			(setting.Visibility == model.VisibilityContacts && contacts[id])
+
+		if !visible {
+			presences = append(presences, model.Presence{UserID: id, Hidden: true})
+			continue
+		}
+
+		presence := model.Presence{UserID: id, Status: statuses[id]}
+		if presence.Status == model.PresenceOffline {
+			presence.LastSeenAt = setting.LastSeenAt
+		}
+		presences = append(presences, presence)
+	}
+
+	return presences, nil
+}
+
+func (s *PresenceService) SetVisibility(ctx context.Context, userID int64, visibility string) error {
+	switch visibility {
+	case model.VisibilityEveryone, model.VisibilityContacts, model.VisibilityNobody:
+		return s.repo.SetPresenceVisibility(ctx, userID, visibility)
+	default:
+		return errors.New("visibility must be everyone, contacts or nobody")
+	}
+}
+
+func (s *PresenceService) goOffline(ctx context.Context, userID int64, lastSeen time.Time) {
+	if err := s.repo.UpdateLastSeen(ctx, userID, lastSeen); err != nil {
+		log.Printf("Failed to record last seen of user %d: %v", userID, err)
+	}
+
+	s.announce(ctx, model.OnlineStatusEvent{UserID: userID, Status: model.PresenceOffline, LastSeenAt: &lastSeen})
+}
+
+func (s *PresenceService) status(ctx context.Context, userID int64) (string, error) {
+	statuses, err := s.sessions.GetStatuses(ctx, []int64{userID})
+	if err != nil {
+		return "", err
+	}
+	return statuses[userID], nil
+}
+
+// announce publishes a status change to the user's contacts unless the user
+// hides their presence from everyone.
+func (s *PresenceService) announce(ctx context.Context, event model.OnlineStatusEvent) {
+	settings, err := s.repo.GetPresenceSettings(ctx, []int64{event.UserID})
+	if err != nil {
+		log.Printf("Failed to load presence settings of user %d: %v", event.UserID, err)
+		return
+	}
+	if settings[event.UserID].Visibility == model.VisibilityNobody {
+		return
+	}
+
+	contacts, err := s.repo.GetContacts(ctx, event.UserID)
+	if err != nil {
+		log.Printf("Failed to load contacts of user %d: %v", event.UserID, err)
+		return
+	}
+
+	if len(contacts) > 0 {
+		_ = s.redis.PublishStatus(ctx, event, contacts)
+	}
+}
+
+func (s *PresenceService) contactSet(ctx context.Context, userID int64) (map[int64]bool, error) {
+	contacts, err := s.repo.GetContacts(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[int64]bool, len(contacts))
+	for _, id := range contacts {
+		set[id] = true
+	}
+	return set, nil
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+	repoMocks "github.com/zhanserikAmangeldi/chat-service/internal/core/ports/mocks"
+	redisMocks "github.com/zhanserikAmangeldi/chat-service/internal/redis/mocks"
+)
+
+const testPresenceTTL = 2 * time.Minute
+
+func everyoneCanSee(userID int64) map[int64]model.PresenceSettings {
+	return map[int64]model.PresenceSettings{userID: {UserID: userID, Visibility: model.VisibilityEveryone}}
+}
+
+func TestPresenceConnect_FirstSessionAnnouncesOnline(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockStore := new(redisMocks.MockPresenceStore)
+
+	service := NewPresenceService(mockRepo, mockRedis, mockStore, testPresenceTTL)
+
+	ctx := context.Background()
+
+	mockStore.On("GetStatuses", ctx, []int64{1}).Return(map[int64]string{1: model.PresenceOffline}, nil)
+	mockStore.On("SetSession", ctx, int64(1), "s1", model.PresenceOnline, testPresenceTTL).Return(nil)
+	mockRepo.On("GetPresenceSettings", ctx, []int64{1}).Return(everyoneCanSee(1), nil)
+	mockRepo.On("GetContacts", ctx, int64(1)).Return([]int64{2, 3}, nil)
+	mockRedis.On("PublishStatus", ctx, model.OnlineStatusEvent{UserID: 1, Status: model.PresenceOnline}, []int64{2, 3}).Return(nil)
+
+	err := service.Connect(ctx, 1, "s1")
+
+	assert.NoError(t, err)
+	mockStore.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestPresenceConnect_SecondSessionIsSilent(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockStore := new(redisMocks.MockPresenceStore)
+
+	service := NewPresenceService(mockRepo, mockRedis, mockStore, testPresenceTTL)
+
+	ctx := context.Background()
+
+	mockStore.On("GetStatuses", ctx, []int64{1}).Return(map[int64]string{1: model.PresenceOnline}, nil)
+	mockStore.On("SetSession", ctx, int64(1), "s2", model.PresenceOnline, testPresenceTTL).Return(nil)
+
+	err := service.Connect(ctx, 1, "s2")
+
+	assert.NoError(t, err)
+	mockRedis.AssertNotCalled(t, "PublishStatus", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPresenceDisconnect_LastSessionRecordsLastSeen(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockStore := new(redisMocks.MockPresenceStore)
+
+	service := NewPresenceService(mockRepo, mockRedis, mockStore, testPresenceTTL)
+
+	ctx := context.Background()
+
+	mockStore.On("RemoveSession", ctx, int64(1), "s1").Return(nil)
+	mockStore.On("GetStatuses", ctx, []int64{1}).Return(map[int64]string{1: model.PresenceOffline}, nil)
+	mockStore.On("ClaimOffline", ctx, int64(1), mock.AnythingOfType("time.Time")).Return(true, nil)
+	mockRepo.On("UpdateLastSeen", ctx, int64(1), mock.AnythingOfType("time.Time")).Return(nil)
+	mockRepo.On("GetPresenceSettings", ctx, []int64{1}).Return(everyoneCanSee(1), nil)
+	mockRepo.On("GetContacts", ctx, int64(1)).Return([]int64{2}, nil)
+	mockRedis.On("PublishStatus", ctx, mock.MatchedBy(func(event model.OnlineStatusEvent) bool {
+		return event.Status == model.PresenceOffline && event.LastSeenAt != nil
+	}), []int64{2}).Return(nil)
+
+	err := service.Disconnect(ctx, 1, "s1")
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestPresenceDisconnect_OtherDeviceStillOnline(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockStore := new(redisMocks.MockPresenceStore)
+
+	service := NewPresenceService(mockRepo, mockRedis, mockStore, testPresenceTTL)
+
+	ctx := context.Background()
+
+	mockStore.On("RemoveSession", ctx, int64(1), "s1").Return(nil)
+	mockStore.On("GetStatuses", ctx, []int64{1}).Return(map[int64]string{1: model.PresenceOnline}, nil)
+
+	err := service.Disconnect(ctx, 1, "s1")
+
+	assert.NoError(t, err)
+	mockRepo.AssertNotCalled(t, "UpdateLastSeen", mock.Anything, mock.Anything, mock.Anything)
+	mockRedis.AssertNotCalled(t, "PublishStatus", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPresenceDisconnect_AlreadySweptIsSilent(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockStore := new(redisMocks.MockPresenceStore)
+
+	service := NewPresenceService(mockRepo, mockRedis, mockStore, testPresenceTTL)
+
+	ctx := context.Background()
+
+	mockStore.On("RemoveSession", ctx, int64(1), "s1").Return(nil)
+	mockStore.On("GetStatuses", ctx, []int64{1}).Return(map[int64]string{1: model.PresenceOffline}, nil)
+	mockStore.On("ClaimOffline", ctx, int64(1), mock.AnythingOfType("time.Time")).Return(false, nil)
+
+	err := service.Disconnect(ctx, 1, "s1")
+
+	assert.NoError(t, err)
+	mockRepo.AssertNotCalled(t, "UpdateLastSeen", mock.Anything, mock.Anything, mock.Anything)
+	mockRedis.AssertNotCalled(t, "PublishStatus", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPresenceSweepExpired_AnnouncesExpiredUsers(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockStore := new(redisMocks.MockPresenceStore)
+
+	service := NewPresenceService(mockRepo, mockRedis, mockStore, testPresenceTTL)
+
+	ctx := context.Background()
+	lastHeartbeat := time.Now().Add(-5 * time.Minute)
+
+	mockStore.On("StaleUsers", ctx, mock.AnythingOfType("time.Time"), int64(presenceSweepBatch)).
+		Return(map[int64]time.Time{1: lastHeartbeat, 2: lastHeartbeat}, nil)
+	mockStore.On("GetStatuses", ctx, []int64{1}).Return(map[int64]string{1: model.PresenceOffline}, nil)
+	mockStore.On("GetStatuses", ctx, []int64{2}).Return(map[int64]string{2: model.PresenceOffline}, nil)
+	mockStore.On("ClaimOffline", ctx, int64(1), mock.AnythingOfType("time.Time")).Return(true, nil)
+	// Another instance swept user 2 first.
+	mockStore.On("ClaimOffline", ctx, int64(2), mock.AnythingOfType("time.Time")).Return(false, nil)
+	mockRepo.On("UpdateLastSeen", ctx, int64(1), lastHeartbeat).Return(nil)
+	mockRepo.On("GetPresenceSettings", ctx, []int64{1}).Return(everyoneCanSee(1), nil)
+	mockRepo.On("GetContacts", ctx, int64(1)).Return([]int64{3}, nil)
+	mockRedis.On("PublishStatus", ctx, mock.MatchedBy(func(event model.OnlineStatusEvent) bool {
+		return event.UserID == 1 && event.Status == model.PresenceOffline && event.LastSeenAt.Equal(lastHeartbeat)
+	}), []int64{3}).Return(nil)
+
+	offline, err := service.SweepExpired(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, offline)
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "UpdateLastSeen", ctx, int64(2), mock.Anything)
+}
+
+func TestPresenceSweepExpired_SkipsUsersStillOnline(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockStore := new(redisMocks.MockPresenceStore)
+
+	service := NewPresenceService(mockRepo, mockRedis, mockStore, testPresenceTTL)
+
+	ctx := context.Background()
+
+	mockStore.On("StaleUsers", ctx, mock.AnythingOfType("time.Time"), int64(presenceSweepBatch)).
+		Return(map[int64]time.Time{1: time.Now().Add(-5 * time.Minute)}, nil)
+	mockStore.On("GetStatuses", ctx, []int64{1}).Return(map[int64]string{1: model.PresenceOnline}, nil)
+
+	offline, err := service.SweepExpired(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, offline)
+	mockStore.AssertNotCalled(t, "ClaimOffline", mock.Anything, mock.Anything, mock.Anything)
+	mockRedis.AssertNotCalled(t, "PublishStatus", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPresenceSetStatus_HiddenUserIsNotAnnounced(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockStore := new(redisMocks.MockPresenceStore)
+
+	service := NewPresenceService(mockRepo, mockRedis, mockStore, testPresenceTTL)
+
+	ctx := context.Background()
+
+	mockStore.On("GetStatuses", ctx, []int64{1}).Return(map[int64]string{1: model.PresenceOnline}, nil).Once()
+	mockStore.On("SetSession", ctx, int64(1), "s1", model.PresenceAway, testPresenceTTL).Return(nil)
+	mockStore.On("GetStatuses", ctx, []int64{1}).Return(map[int64]string{1: model.PresenceAway}, nil).Once()
+	mockRepo.On("GetPresenceSettings", ctx, []int64{1}).
+		Return(map[int64]model.PresenceSettings{1: {UserID: 1, Visibility: model.VisibilityNobody}}, nil)
+
+	err := service.SetStatus(ctx, 1, "s1", model.PresenceAway)
+
+	assert.NoError(t, err)
+	mockRedis.AssertNotCalled(t, "PublishStatus", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPresenceSetStatus_RejectsOffline(t *testing.T) {
+	service := NewPresenceService(new(repoMocks.MockChatRepository), new(redisMocks.MockRedisClient), new(redisMocks.MockPresenceStore), testPresenceTTL)
+
+	err := service.SetStatus(context.Background(), 1, "s1", model.PresenceOffline)
+
+	assert.Error(t, err)
+}
+
+func TestGetPresence_RespectsPrivacy(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockStore := new(redisMocks.MockPresenceStore)
+
+	service := NewPresenceService(mockRepo, mockRedis, mockStore, testPresenceTTL)
+
+	ctx := context.Background()
+	viewerID := int64(1)
+	lastSeen := time.Now().Add(-time.Hour)
+	userIDs := []int64{2, 3, 4, 5}
+
+	mockRepo.On("GetPresenceSettings", ctx, userIDs).Return(map[int64]model.PresenceSettings{
+		2: {UserID: 2, Visibility: model.VisibilityEveryone, LastSeenAt: &lastSeen},
+		3: {UserID: 3, Visibility: model.VisibilityContacts},
+		4: {UserID: 4, Visibility: model.VisibilityContacts},
+		5: {UserID: 5, Visibility: model.VisibilityNobody},
+	}, nil)
+	mockRepo.On("GetContacts", ctx, viewerID).Return([]int64{3}, nil)
+	mockStore.On("GetStatuses", ctx, userIDs).Return(map[int64]string{
+		2: model.PresenceOffline,
+		3: model.PresenceAway,
+		4: model.PresenceOnline,
+		5: model.PresenceOnline,
+	}, nil)
+
+	presences, err := service.GetPresence(ctx, viewerID, userIDs)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []model.Presence{
+		{UserID: 2, Status: model.PresenceOffline, LastSeenAt: &lastSeen},
+		{UserID: 3, Status: model.PresenceAway},
+		{UserID: 4, Hidden: true},
+		{UserID: 5, Hidden: true},
+	}, presences)
+}
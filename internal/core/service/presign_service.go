@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/ports"
+)
+
+// stagingGracePeriod is how long a staging object is kept after its presigned
+// request expired: storage only checks the expiry when a request starts, so
+// a large upload begun just before can still be running.
+const stagingGracePeriod = time.Hour
+
+const expiredPresignBatch = 100
+
+// PresignedRequest is the request a client sends to upload a file straight
+// to storage. A PUT carries Headers, a POST is a form carrying FormData.
+type PresignedRequest struct {
+	Method   string
+	URL      string
+	Headers  map[string]string
+	FormData map[string]string
+}
+
+// PresignService lets clients upload files straight to storage instead of
+// through this service. Files are presigned to a staging key and copied to
+// their final key when finalized; staging objects are deleted by
+// ExpireUploads once their request can no longer be used.
+type PresignService struct {
+	repo    ports.ChatRepository
+	storage ports.DirectUploadStorage
+	expiry  time.Duration
+}
+
+// NewPresignService creates a presign service whose requests are valid for
+// expiry.
+func NewPresignService(repo ports.ChatRepository, storage ports.DirectUploadStorage, expiry time.Duration) *PresignService {
+	return &PresignService{
+		repo:    repo,
+		storage: storage,
+		expiry:  expiry,
+	}
+}
+
+// Presign records a direct upload and signs the request for it. A PUT must
+// send exactly size bytes, a POST at most size. Size limits and allowed types
+// are checked by the caller.
+func (s *PresignService) Presign(ctx context.Context, userID int64, method, fileName, mimeType string, size int64) (*model.PresignedUpload, *PresignedRequest, error) {
+	id, err := newUploadID()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	upload := &model.PresignedUpload{
+		ID:            id,
+		UserID:        userID,
+		Bucket:        DetermineBucket(mimeType),
+		StagingObject: fmt.Sprintf("staging/%d/%s%s", userID, id, filepath.Ext(fileName)),
+		FileName:      fileName,
+		MimeType:      mimeType,
+		ExpiresAt:     time.Now().Add(s.expiry),
+	}
+
+	request := &PresignedRequest{Method: method}
+	switch method {
+	case http.MethodPut:
+		url, headers, err := s.storage.PresignedPutURL(ctx, upload.Bucket, upload.StagingObject, mimeType, size, s.expiry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to presign upload: %w", err)
+		}
+		request.URL = url
+		request.Headers = make(map[string]string, len(headers))
+		for name := range headers {
+			request.Headers[name] = headers.Get(name)
+		}
+	case http.MethodPost:
+		url, formData, err := s.storage.PresignedPostPolicy(ctx, upload.Bucket, upload.StagingObject, mimeType, size, s.expiry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to presign upload: %w", err)
+		}
+		request.URL = url
+		request.FormData = formData
+	default:
+		return nil, nil, errors.New("method must be PUT or POST")
+	}
+
+	if err := s.repo.CreatePresignedUpload(ctx, upload); err != nil {
+		return nil, nil, err
+	}
+
+	return upload, request, nil
+}
+
+// Finalize moves an uploaded file from its staging key to a new key of the
+// user's and returns that key. An upload is finalized at most once.
+func (s *PresignService) Finalize(ctx context.Context, userID int64, id string) (*model.PresignedUpload, string, error) {
+	upload, err := s.repo.GetPresignedUpload(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if upload == nil || upload.UserID != userID || upload.Finalized ||
+		time.Now().After(upload.ExpiresAt.Add(stagingGracePeriod)) {
+		return nil, "", ErrUploadNotFound
+	}
+
+	if _, err := s.storage.FileSize(ctx, upload.Bucket, upload.StagingObject); err != nil {
+		return nil, "", ErrUploadNotFound
+	}
+
+	newID, err := newUploadID()
+	if err != nil {
+		return nil, "", err
+	}
+	objectName := fmt.Sprintf("%d/%s%s", userID, newID, filepath.Ext(upload.FileName))
+
+	if err := s.storage.CopyFile(ctx, upload.Bucket, upload.StagingObject, objectName); err != nil {
+		return nil, "", fmt.Errorf("failed to store upload: %w", err)
+	}
+
+	finalized, err := s.repo.FinalizePresignedUpload(ctx, id)
+	if err != nil || !finalized {
+		// A concurrent request finalized the upload first.
+		_ = s.storage.DeleteFile(ctx, upload.Bucket, objectName)
+		if err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrUploadNotFound
+	}
+
+	// Anything uploaded to the staging key again until the request expires
+	// is deleted by ExpireUploads.
+	if err := s.storage.DeleteFile(ctx, upload.Bucket, upload.StagingObject); err != nil {
+		log.Printf("Failed to delete staging object %s/%s: %v", upload.Bucket, upload.StagingObject, err)
+	}
+
+	upload.Finalized = true
+	return upload, objectName, nil
+}
+
+// ExpireUploads deletes the staging objects and records of the uploads whose
+// requests expired, finalized or not, and returns how many there were. An
+// upload whose staging object cannot be deleted is kept for the next run.
+func (s *PresignService) ExpireUploads(ctx context.Context) (int, error) {
+	expired := 0
+	for {
+		uploads, err := s.repo.GetExpiredPresignedUploads(ctx, time.Now().Add(-stagingGracePeriod), expiredPresignBatch)
+		if err != nil {
+			return expired, err
+		}
+
+		failed := false
+		for _, upload := range uploads {
+			// Deleting a staging object nothing was uploaded to, or that a
+			// finalize already deleted, succeeds.
+			if err := s.storage.DeleteFile(ctx, upload.Bucket, upload.StagingObject); err != nil {
+				log.Printf("Failed to delete staging object %s/%s: %v", upload.Bucket, upload.StagingObject, err)
+				failed = true
+				continue
+			}
+			if err := s.repo.DeletePresignedUpload(ctx, upload.ID); err != nil {
+				return expired, err
+			}
+			expired++
+		}
+
+		if failed || len(uploads) < expiredPresignBatch {
+			return expired, nil
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+	repoMocks "github.com/zhanserikAmangeldi/chat-service/internal/core/ports/mocks"
+)
+
+const testPresignExpiry = 15 * time.Minute
+
+func presignedUpload() *model.PresignedUpload {
+	return &model.PresignedUpload{
+		ID:            "p1",
+		UserID:        1,
+		Bucket:        "chat-files",
+		StagingObject: "staging/1/p1.pdf",
+		FileName:      "report.pdf",
+		MimeType:      "application/pdf",
+		ExpiresAt:     time.Now().Add(testPresignExpiry),
+	}
+}
+
+func TestPresign_PutSignsStagingKey(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockDirectUploadStorage)
+
+	service := NewPresignService(mockRepo, mockStorage, testPresignExpiry)
+
+	ctx := context.Background()
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/pdf")
+
+	isStagingKey := mock.MatchedBy(func(name string) bool {
+		return strings.HasPrefix(name, "staging/1/") && strings.HasSuffix(name, ".pdf")
+	})
+	mockStorage.On("PresignedPutURL", ctx, "chat-files", isStagingKey, "application/pdf", int64(1024), testPresignExpiry).
+		Return("https://storage/put", headers, nil)
+	mockRepo.On("CreatePresignedUpload", ctx, mock.MatchedBy(func(u *model.PresignedUpload) bool {
+		return u.UserID == 1 && u.FileName == "report.pdf" && !u.Finalized
+	})).Return(nil)
+
+	upload, request, err := service.Presign(ctx, 1, http.MethodPut, "report.pdf", "application/pdf", 1024)
+
+	require.NoError(t, err)
+	assert.Equal(t, "staging/1/"+upload.ID+".pdf", upload.StagingObject)
+	assert.Equal(t, http.MethodPut, request.Method)
+	assert.Equal(t, "https://storage/put", request.URL)
+	assert.Equal(t, map[string]string{"Content-Type": "application/pdf"}, request.Headers)
+	mockStorage.AssertExpectations(t)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPresign_PostSignsStagingKey(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockDirectUploadStorage)
+
+	service := NewPresignService(mockRepo, mockStorage, testPresignExpiry)
+
+	ctx := context.Background()
+
+	mockStorage.On("PresignedPostPolicy", ctx, "chat-images", mock.MatchedBy(func(name string) bool {
+		return strings.HasPrefix(name, "staging/1/")
+	}), "image/png", int64(10<<20), testPresignExpiry).Return("https://storage/post", map[string]string{"key": "k"}, nil)
+	mockRepo.On("CreatePresignedUpload", ctx, mock.AnythingOfType("*model.PresignedUpload")).Return(nil)
+
+	_, request, err := service.Presign(ctx, 1, http.MethodPost, "photo.png", "image/png", 10<<20)
+
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodPost, request.Method)
+	assert.Equal(t, map[string]string{"key": "k"}, request.FormData)
+}
+
+func TestPresign_RejectsOtherMethods(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockDirectUploadStorage)
+
+	service := NewPresignService(mockRepo, mockStorage, testPresignExpiry)
+
+	_, _, err := service.Presign(context.Background(), 1, http.MethodGet, "report.pdf", "application/pdf", 1024)
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "CreatePresignedUpload", mock.Anything, mock.Anything)
+}
+
+func TestFinalize_CopiesToNewKeyAndDeletesStaging(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockDirectUploadStorage)
+
+	service := NewPresignService(mockRepo, mockStorage, testPresignExpiry)
+
+	ctx := context.Background()
+	var copiedTo string
+
+	mockRepo.On("GetPresignedUpload", ctx, "p1").Return(presignedUpload(), nil)
+	mockStorage.On("FileSize", ctx, "chat-files", "staging/1/p1.pdf").Return(int64(1024), nil)
+	mockStorage.On("CopyFile", ctx, "chat-files", "staging/1/p1.pdf", mock.AnythingOfType("string")).
+		Run(func(args mock.Arguments) { copiedTo = args.String(3) }).Return(nil)
+	mockRepo.On("FinalizePresignedUpload", ctx, "p1").Return(true, nil)
+	mockStorage.On("DeleteFile", ctx, "chat-files", "staging/1/p1.pdf").Return(nil)
+
+	upload, objectName, err := service.Finalize(ctx, 1, "p1")
+
+	require.NoError(t, err)
+	assert.Equal(t, copiedTo, objectName)
+	assert.True(t, strings.HasPrefix(objectName, "1/"))
+	assert.True(t, strings.HasSuffix(objectName, ".pdf"))
+	assert.NotContains(t, objectName, "p1")
+	assert.Equal(t, "chat-files", upload.Bucket)
+	assert.True(t, upload.Finalized)
+	mockStorage.AssertExpectations(t)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestFinalize_OtherUsersUpload(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockDirectUploadStorage)
+
+	service := NewPresignService(mockRepo, mockStorage, testPresignExpiry)
+
+	ctx := context.Background()
+
+	mockRepo.On("GetPresignedUpload", ctx, "p1").Return(presignedUpload(), nil)
+
+	_, _, err := service.Finalize(ctx, 2, "p1")
+
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+	mockStorage.AssertNotCalled(t, "CopyFile", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestFinalize_AlreadyFinalized(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockDirectUploadStorage)
+
+	service := NewPresignService(mockRepo, mockStorage, testPresignExpiry)
+
+	ctx := context.Background()
+	upload := presignedUpload()
+	upload.Finalized = true
+
+	mockRepo.On("GetPresignedUpload", ctx, "p1").Return(upload, nil)
+
+	_, _, err := service.Finalize(ctx, 1, "p1")
+
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+	mockStorage.AssertNotCalled(t, "CopyFile", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestFinalize_NothingUploaded(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockDirectUploadStorage)
+
+	service := NewPresignService(mockRepo, mockStorage, testPresignExpiry)
+
+	ctx := context.Background()
+
+	mockRepo.On("GetPresignedUpload", ctx, "p1").Return(presignedUpload(), nil)
+	mockStorage.On("FileSize", ctx, "chat-files", "staging/1/p1.pdf").Return(int64(0), errors.New("key does not exist"))
+
+	_, _, err := service.Finalize(ctx, 1, "p1")
+
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+	mockRepo.AssertNotCalled(t, "FinalizePresignedUpload", mock.Anything, mock.Anything)
+}
+
+func TestFinalize_ConcurrentFinalizeDeletesCopy(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockDirectUploadStorage)
+
+	service := NewPresignService(mockRepo, mockStorage, testPresignExpiry)
+
+	ctx := context.Background()
+	var copiedTo string
+
+	mockRepo.On("GetPresignedUpload", ctx, "p1").Return(presignedUpload(), nil)
+	mockStorage.On("FileSize", ctx, "chat-files", "staging/1/p1.pdf").Return(int64(1024), nil)
+	mockStorage.On("CopyFile", ctx, "chat-files", "staging/1/p1.pdf", mock.AnythingOfType("string")).
+		Run(func(args mock.Arguments) { copiedTo = args.String(3) }).Return(nil)
+	mockRepo.On("FinalizePresignedUpload", ctx, "p1").Return(false, nil)
+	mockStorage.On("DeleteFile", ctx, "chat-files", mock.AnythingOfType("string")).Return(nil)
+
+	_, _, err := service.Finalize(ctx, 1, "p1")
+
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+	mockStorage.AssertCalled(t, "DeleteFile", ctx, "chat-files", copiedTo)
+	mockStorage.AssertNotCalled(t, "DeleteFile", ctx, "chat-files", "staging/1/p1.pdf")
+}
+
+func TestExpirePresignedUploads_DeletesStagingObjects(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockDirectUploadStorage)
+
+	service := NewPresignService(mockRepo, mockStorage, testPresignExpiry)
+
+	ctx := context.Background()
+	finalized := presignedUpload()
+	finalized.Finalized = true
+	abandoned := presignedUpload()
+	abandoned.ID, abandoned.StagingObject = "p2", "staging/1/p2.pdf"
+
+	mockRepo.On("GetExpiredPresignedUploads", ctx, mock.AnythingOfType("time.Time"), expiredPresignBatch).
+		Return([]model.PresignedUpload{*finalized, *abandoned}, nil)
+	mockStorage.On("DeleteFile", ctx, "chat-files", "staging/1/p1.pdf").Return(nil)
+	mockStorage.On("DeleteFile", ctx, "chat-files", "staging/1/p2.pdf").Return(errors.New("storage unavailable"))
+	mockRepo.On("DeletePresignedUpload", ctx, "p1").Return(nil)
+
+	expired, err := service.ExpireUploads(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	mockStorage.AssertExpectations(t)
+	mockRepo.AssertExpectations(t)
+	// The upload whose staging object is still there is retried next time.
+	mockRepo.AssertNotCalled(t, "DeletePresignedUpload", ctx, "p2")
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/ports"
+)
+
+const (
+	maxConcurrentScans = 4
+	// ScanRetryInterval is how long a file stays pending before a failed or
+	// interrupted scan is retried.
+	ScanRetryInterval = 5 * time.Minute
+	scanRetryBatch    = 100
+)
+
+// ScanService quarantines uploaded files until a scanner has looked at them.
+// Messages sent with a file are held back while it is pending, delivered once
+// it is clean, and deleted together with the file when it is infected.
+type ScanService struct {
+	repo    ports.ChatRepository
+	storage ports.ObjectStorage
+	scanner ports.FileScanner
+	chat    *ChatService
+	images  *ImageService
+	slots   chan struct{}
+}
+
+// NewScanService creates a ScanService. Without a scanner every file is
+// considered clean as soon as it is stored.
+func NewScanService(repo ports.ChatRepository, storage ports.ObjectStorage, scanner ports.FileScanner, chat *ChatService, images *ImageService) *ScanService {
+	return &ScanService{
+		repo:    repo,
+		storage: storage,
+		scanner: scanner,
+		chat:    chat,
+		images:  images,
+		slots:   make(chan struct{}, maxConcurrentScans),
+	}
+}
+
+// Register records an object the user just stored, together with what was
+// found out about it when it was processed, and starts scanning it in the
+// background. It returns the object's scan status.
+func (s *ScanService) Register(ctx context.Context, userID int64, bucket, objectName, fileName string, info model.ImageInfo) (string, error) {
+	scan := &model.FileScan{
+		Bucket:     bucket,
+		ObjectName: objectName,
+		UserID:     userID,
+		FileName:   fileName,
+		Status:     model.FileStatusPending,
+		ImageInfo:  info,
+	}
+	if s.scanner == nil {
+		scan.Status = model.FileStatusClean
+	}
+
+	if err := s.repo.CreateFileScan(ctx, scan); err != nil {
+		return "", err
+	}
+
+	if scan.Status == model.FileStatusPending {
+		go func() {
+			s.slots <- struct{}{}
+			defer func() { <-s.slots }()
+
+			if err := s.Scan(context.Background(), scan); err != nil {
+				log.Printf("Failed to scan %s/%s: %v", bucket, objectName, err)
+			}
+		}()
+	}
+	return scan.Status, nil
+}
+
+// Lookup returns the scan record of an object, or nil if it has none.
+func (s *ScanService) Lookup(ctx context.Context, bucket, objectName string) (*model.FileScan, error) {
+	return s.repo.GetFileScan(ctx, bucket, objectName)
+}
+
+// Forget drops the record of an object that was deleted before being sent.
+func (s *ScanService) Forget(ctx context.Context, bucket, objectName string) {
+	_ = s.repo.DeleteFileScan(ctx, bucket, objectName)
+}
+
+// Scan runs the scanner over a pending object and applies the verdict. An
+// infected object is deleted before the verdict is recorded. On error the
+// object stays pending and RetryPending scans it again.
+func (s *ScanService) Scan(ctx context.Context, scan *model.FileScan) error {
+	obj, err := s.storage.GetFile(ctx, scan.Bucket, scan.ObjectName)
+	if err != nil {
+		return err
+	}
+	result, err := s.scanner.Scan(ctx, obj)
+	obj.Close()
+	if err != nil {
+		return err
+	}
+
+	status := model.FileStatusClean
+	var signature *string
+	if result.Infected {
+		status = model.FileStatusInfected
+		signature = &result.Signature
+
+		if err := s.storage.DeleteFile(ctx, scan.Bucket, scan.ObjectName); err != nil {
+			return err
+		}
+		if scan.Bucket == ImagesBucket && s.images != nil {
+			s.images.DeleteThumbnails(ctx, scan.ObjectName)
+		}
+		log.Printf("Deleted infected file %s/%s of user %d: %s", scan.Bucket, scan.ObjectName, scan.UserID, result.Signature)
+	}
+
+	finished, err := s.repo.FinishFileScan(ctx, scan.Bucket, scan.ObjectName, status, signature)
+	if err != nil {
+		return err
+	}
+	// A concurrent scan already recorded its verdict and released the
+	// messages.
+	if !finished {
+		return nil
+	}
+
+	resolved := *scan
+	resolved.Status = status
+	resolved.Signature = signature
+	return s.chat.ResolveFileScan(ctx, &resolved)
+}
+
+// RetryPending scans again objects that stayed pending for longer than
+// ScanRetryInterval, e.g. because the scanner was unreachable or the service
+// restarted mid-scan. It returns how many were scanned successfully.
+func (s *ScanService) RetryPending(ctx context.Context) (int, error) {
+	if s.scanner == nil {
+		return 0, nil
+	}
+
+	scans, err := s.repo.GetPendingFileScans(ctx, time.Now().Add(-ScanRetryInterval), scanRetryBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	scanned := 0
+	for i := range scans {
+		if err := s.Scan(ctx, &scans[i]); err != nil {
+			log.Printf("Failed to scan %s/%s: %v", scans[i].Bucket, scans[i].ObjectName, err)
+			continue
+		}
+		scanned++
+	}
+	return scanned, nil
+}
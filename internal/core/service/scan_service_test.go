@@ -0,0 +1,387 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	grpcMocks "github.com/zhanserikAmangeldi/chat-service/internal/adapters/grpc/mocks"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+	repoMocks "github.com/zhanserikAmangeldi/chat-service/internal/core/ports/mocks"
+	redisMocks "github.com/zhanserikAmangeldi/chat-service/internal/redis/mocks"
+)
+
+func fileScan(status string) *model.FileScan {
+	return &model.FileScan{
+		Bucket:     "chat-files",
+		ObjectName: "1/abc.pdf",
+		UserID:     1,
+		FileName:   "report.pdf",
+		Status:     status,
+	}
+}
+
+func expectConversation(mockRepo *repoMocks.MockChatRepository, ctx context.Context, conversationID, senderID int64) {
+	mockRepo.On("GetConversationByID", ctx, conversationID).
+		Return(&model.Conversation{ID: conversationID, CreatedAt: time.Now()}, nil)
+	mockRepo.On("IsParticipant", ctx, conversationID, senderID).
+		Return(true, nil)
+}
+
+func TestSendMessage_PendingFileIsHeldBack(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	service := NewChatService(mockRepo, mockRedis, new(grpcMocks.MockUserClient), nil)
+
+	ctx := context.Background()
+	expectConversation(mockRepo, ctx, 5, 1)
+	mockRepo.On("GetFileScan", ctx, "chat-files", "1/abc.pdf").
+		Return(fileScan(model.FileStatusPending), nil)
+	mockRepo.On("SaveMessage", ctx, mock.AnythingOfType("*model.Message")).
+		Return(nil)
+
+	attachment := model.Attachment{Bucket: "chat-files", ObjectName: "1/abc.pdf"}
+	msg, err := service.SendMessage(ctx, 1, 0, "", 5, "file", nil, nil, nil, nil, nil, attachment)
+
+	assert.NoError(t, err)
+	assert.Equal(t, model.FileStatusPending, *msg.FileStatus)
+	assert.Equal(t, "1/abc.pdf", *msg.FileObject)
+	mockRepo.AssertNotCalled(t, "GetParticipants", mock.Anything, mock.Anything)
+	mockRedis.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSendMessage_CleanFileIsDelivered(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	service := NewChatService(mockRepo, mockRedis, new(grpcMocks.MockUserClient), nil)
+
+	ctx := context.Background()
+	expectConversation(mockRepo, ctx, 5, 1)
+	mockRepo.On("GetFileScan", ctx, "chat-files", "1/abc.pdf").
+		Return(fileScan(model.FileStatusClean), nil)
+	mockRepo.On("SaveMessage", ctx, mock.AnythingOfType("*model.Message")).
+		Return(nil)
+	mockRepo.On("GetParticipants", ctx, int64(5)).
+		Return([]int64{1, 2}, nil)
+	mockRedis.On("Publish", ctx, mock.AnythingOfType("model.Message"), []int64{2}).
+		Return(nil)
+
+	attachment := model.Attachment{Bucket: "chat-files", ObjectName: "1/abc.pdf"}
+	_, err := service.SendMessage(ctx, 1, 0, "", 5, "file", nil, nil, nil, nil, nil, attachment)
+
+	assert.NoError(t, err)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestSendMessage_ScanFinishedWhileSending(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	service := NewChatService(mockRepo, mockRedis, new(grpcMocks.MockUserClient), nil)
+
+	ctx := context.Background()
+	expectConversation(mockRepo, ctx, 5, 1)
+	mockRepo.On("GetFileScan", ctx, "chat-files", "1/abc.pdf").
+		Return(fileScan(model.FileStatusPending), nil).Once()
+	mockRepo.On("GetFileScan", ctx, "chat-files", "1/abc.pdf").
+		Return(fileScan(model.FileStatusClean), nil).Once()
+	mockRepo.On("SaveMessage", ctx, mock.AnythingOfType("*model.Message")).
+		Return(nil)
+	mockRepo.On("SetMessagesFileStatus", ctx, "chat-files", "1/abc.pdf", model.FileStatusClean).
+		Return([]int64{42}, nil)
+	mockRepo.On("GetMessageByID", ctx, int64(42)).
+		Return(&model.Message{ID: 42, ConversationID: 5, SenderID: 1}, nil)
+	mockRepo.On("GetParticipants", ctx, int64(5)).
+		Return([]int64{1, 2}, nil)
+	mockRedis.On("Publish", ctx, mock.AnythingOfType("model.Message"), []int64{2}).
+		Return(nil)
+
+	attachment := model.Attachment{Bucket: "chat-files", ObjectName: "1/abc.pdf"}
+	_, err := service.SendMessage(ctx, 1, 0, "", 5, "file", nil, nil, nil, nil, nil, attachment)
+
+	assert.NoError(t, err)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestSendMessage_RejectsFileOfAnotherUser(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	service := NewChatService(mockRepo, new(redisMocks.MockRedisClient), new(grpcMocks.MockUserClient), nil)
+
+	ctx := context.Background()
+	expectConversation(mockRepo, ctx, 5, 2)
+	mockRepo.On("GetFileScan", ctx, "chat-files", "1/abc.pdf").
+		Return(fileScan(model.FileStatusClean), nil)
+
+	attachment := model.Attachment{Bucket: "chat-files", ObjectName: "1/abc.pdf"}
+	_, err := service.SendMessage(ctx, 2, 0, "", 5, "file", nil, nil, nil, nil, nil, attachment)
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "SaveMessage", mock.Anything, mock.Anything)
+}
+
+func imageScan() *model.FileScan {
+	width, height, blurhash := 640, 480, "LEHV6nWB2yk8"
+	return &model.FileScan{
+		Bucket:     ImagesBucket,
+		ObjectName: "1/abc.png",
+		UserID:     1,
+		FileName:   "photo.png",
+		Status:     model.FileStatusClean,
+		ImageInfo: model.ImageInfo{
+			ImageWidth:  &width,
+			ImageHeight: &height,
+			Blurhash:    &blurhash,
+			Thumbnails:  model.Thumbnails{{Name: "small", Object: "1/abc_small.jpg", Width: 320, Height: 240}},
+		},
+	}
+}
+
+func TestSendMessage_TakesImageInfoFromFileScan(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockFiles := new(repoMocks.MockFileURLSigner)
+	service := NewChatService(mockRepo, mockRedis, new(grpcMocks.MockUserClient), mockFiles)
+
+	ctx := context.Background()
+	var saved model.Thumbnails
+	expectConversation(mockRepo, ctx, 5, 1)
+	mockRepo.On("GetFileScan", ctx, ImagesBucket, "1/abc.png").
+		Return(imageScan(), nil)
+	mockRepo.On("SaveMessage", ctx, mock.AnythingOfType("*model.Message")).
+		Run(func(args mock.Arguments) { saved = args.Get(1).(*model.Message).Thumbnails }).
+		Return(nil)
+	mockRepo.On("GetParticipants", ctx, int64(5)).
+		Return([]int64{1, 2}, nil)
+	mockFiles.On("GetFileURL", ctx, ImagesBucket, "1/abc.png", fileURLExpiry).
+		Return("https://files/1/abc.png", nil)
+	mockFiles.On("GetFileURL", ctx, ImagesBucket, "1/abc_small.jpg", fileURLExpiry).
+		Return("https://files/1/abc_small.jpg", nil)
+	mockRedis.On("Publish", ctx, mock.MatchedBy(func(msg model.Message) bool {
+		return len(msg.Thumbnails) == 1 && msg.Thumbnails[0].URL == "https://files/1/abc_small.jpg"
+	}), []int64{2}).Return(nil)
+
+	attachment := model.Attachment{Bucket: ImagesBucket, ObjectName: "1/abc.png"}
+	msg, err := service.SendMessage(ctx, 1, 0, "", 5, "image", nil, nil, nil, nil, nil, attachment)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://files/1/abc.png", *msg.FileURL)
+	assert.Equal(t, "photo.png", *msg.FileName)
+	assert.Equal(t, 640, *msg.ImageWidth)
+	assert.Equal(t, "LEHV6nWB2yk8", *msg.Blurhash)
+	// The thumbnail is stored by object name and only signed on the way out.
+	assert.Equal(t, "1/abc_small.jpg", saved[0].Object)
+	assert.Empty(t, saved[0].URL)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestGetHistory_SignsThumbnails(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockFiles := new(repoMocks.MockFileURLSigner)
+	service := NewChatService(mockRepo, new(redisMocks.MockRedisClient), new(grpcMocks.MockUserClient), mockFiles)
+
+	ctx := context.Background()
+	legacy := model.Thumbnails{{Name: "small", URL: "https://files/old_small.jpg"}}
+	messages := []model.Message{
+		{ID: 2, SenderID: 1, MessageType: "image", ImageInfo: imageScan().ImageInfo},
+		{ID: 1, SenderID: 1, MessageType: "image", ImageInfo: model.ImageInfo{Thumbnails: legacy}},
+	}
+	mockRepo.On("IsParticipant", ctx, int64(5), int64(2)).Return(true, nil)
+	mockRepo.On("GetMessages", ctx, int64(5), int64(2), int64(0), int64(0), 51).Return(messages, nil)
+	mockFiles.On("GetFileURL", ctx, ImagesBucket, "1/abc_small.jpg", fileURLExpiry).
+		Return("https://files/1/abc_small.jpg", nil)
+
+	page, err := service.GetHistory(ctx, 2, 5, model.MessageCursor{}, 50)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://files/1/abc_small.jpg", page.Messages[0].Thumbnails[0].URL)
+	assert.Equal(t, "https://files/old_small.jpg", page.Messages[1].Thumbnails[0].URL)
+	mockFiles.AssertNumberOfCalls(t, "GetFileURL", 1)
+}
+
+func TestGetHistory_WithholdsPendingFiles(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockFiles := new(repoMocks.MockFileURLSigner)
+	service := NewChatService(mockRepo, new(redisMocks.MockRedisClient), new(grpcMocks.MockUserClient), mockFiles)
+
+	ctx := context.Background()
+	pending := model.FileStatusPending
+	url := "https://files/1/abc.pdf"
+	bucket, object := "chat-files", "1/abc.pdf"
+	fileRef := model.FileRef{FileBucket: &bucket, FileObject: &object, FileStatus: &pending}
+	messages := []model.Message{
+		{ID: 2, SenderID: 1, FileURL: &url, FileRef: fileRef},
+		// Not even the sender gets a link before the scan is done.
+		{ID: 1, SenderID: 2, FileURL: &url, FileRef: fileRef, ImageInfo: imageScan().ImageInfo},
+	}
+	mockRepo.On("IsParticipant", ctx, int64(5), int64(2)).Return(true, nil)
+	mockRepo.On("GetMessages", ctx, int64(5), int64(2), int64(0), int64(0), 51).Return(messages, nil)
+
+	page, err := service.GetHistory(ctx, 2, 5, model.MessageCursor{}, 50)
+
+	assert.NoError(t, err)
+	assert.Nil(t, page.Messages[0].FileURL)
+	assert.Nil(t, page.Messages[1].FileURL)
+	assert.Empty(t, page.Messages[1].Thumbnails)
+	mockFiles.AssertNotCalled(t, "GetFileURL", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSendMessage_FileMessageRequiresAttachment(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	service := NewChatService(mockRepo, new(redisMocks.MockRedisClient), new(grpcMocks.MockUserClient), nil)
+
+	fileName := "report.pdf"
+	_, err := service.SendMessage(context.Background(), 1, 0, "", 5, "file", &fileName, nil, nil, nil, nil, model.Attachment{})
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "SaveMessage", mock.Anything, mock.Anything)
+}
+
+func TestGetUserConversations_WithholdsPendingFiles(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockFiles := new(repoMocks.MockFileURLSigner)
+	service := NewChatService(mockRepo, new(redisMocks.MockRedisClient), new(grpcMocks.MockUserClient), mockFiles)
+
+	ctx := context.Background()
+	pending, clean := model.FileStatusPending, model.FileStatusClean
+	bucket, object := "chat-files", "1/abc.pdf"
+	convs := []model.ConversationWithLastMessage{{
+		ID:            5,
+		LastMessage:   &model.Message{ID: 2, FileRef: model.FileRef{FileBucket: &bucket, FileObject: &object, FileStatus: &pending}},
+		PinnedMessage: &model.Message{ID: 1, FileRef: model.FileRef{FileBucket: &bucket, FileObject: &object, FileStatus: &clean}},
+	}}
+	mockRepo.On("GetUserConversations", ctx, int64(2), 20, 0).Return(convs, nil)
+	mockFiles.On("GetFileURL", ctx, bucket, object, fileURLExpiry).Return("https://files/1/abc.pdf", nil).Once()
+
+	result, err := service.GetUserConversations(ctx, 2, 20, 0)
+
+	assert.NoError(t, err)
+	assert.Nil(t, result[0].LastMessage.FileURL)
+	assert.Equal(t, "https://files/1/abc.pdf", *result[0].PinnedMessage.FileURL)
+	mockFiles.AssertExpectations(t)
+}
+
+func newTestScanService() (*ScanService, *repoMocks.MockChatRepository, *redisMocks.MockRedisClient, *repoMocks.MockObjectStorage, *repoMocks.MockFileScanner) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockRedis := new(redisMocks.MockRedisClient)
+	mockStorage := new(repoMocks.MockObjectStorage)
+	mockScanner := new(repoMocks.MockFileScanner)
+
+	chat := NewChatService(mockRepo, mockRedis, new(grpcMocks.MockUserClient), nil)
+	return NewScanService(mockRepo, mockStorage, mockScanner, chat, nil), mockRepo, mockRedis, mockStorage, mockScanner
+}
+
+func TestScan_CleanFileReleasesMessages(t *testing.T) {
+	service, mockRepo, mockRedis, mockStorage, mockScanner := newTestScanService()
+
+	ctx := context.Background()
+	mockStorage.On("GetFile", ctx, "chat-files", "1/abc.pdf").
+		Return(io.NopCloser(strings.NewReader("%PDF-1.7")), nil)
+	mockScanner.On("Scan", ctx, mock.Anything).
+		Return(model.ScanResult{}, nil)
+	mockRepo.On("FinishFileScan", ctx, "chat-files", "1/abc.pdf", model.FileStatusClean, (*string)(nil)).
+		Return(true, nil)
+	mockRepo.On("SetMessagesFileStatus", ctx, "chat-files", "1/abc.pdf", model.FileStatusClean).
+		Return([]int64{7}, nil)
+	mockRepo.On("GetMessageByID", ctx, int64(7)).
+		Return(&model.Message{ID: 7, ConversationID: 5, SenderID: 1}, nil)
+	mockRepo.On("GetParticipants", ctx, int64(5)).
+		Return([]int64{1, 2, 3}, nil)
+	mockRedis.On("Publish", ctx, mock.MatchedBy(func(msg model.Message) bool { return msg.ID == 7 }), []int64{2, 3}).
+		Return(nil)
+
+	err := service.Scan(ctx, fileScan(model.FileStatusPending))
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+	mockStorage.AssertNotCalled(t, "DeleteFile", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestScan_InfectedFileIsDeletedAndSenderNotified(t *testing.T) {
+	service, mockRepo, mockRedis, mockStorage, mockScanner := newTestScanService()
+
+	ctx := context.Background()
+	signature := "Eicar-Test-Signature"
+	mockStorage.On("GetFile", ctx, "chat-files", "1/abc.pdf").
+		Return(io.NopCloser(strings.NewReader("X5O!P%@AP")), nil)
+	mockScanner.On("Scan", ctx, mock.Anything).
+		Return(model.ScanResult{Infected: true, Signature: signature}, nil)
+	mockStorage.On("DeleteFile", ctx, "chat-files", "1/abc.pdf").
+		Return(nil)
+	mockRepo.On("FinishFileScan", ctx, "chat-files", "1/abc.pdf", model.FileStatusInfected, &signature).
+		Return(true, nil)
+	mockRepo.On("SetMessagesFileStatus", ctx, "chat-files", "1/abc.pdf", model.FileStatusInfected).
+		Return([]int64{7, 8}, nil)
+	mockRepo.On("DeleteMessage", ctx, int64(7)).Return(nil)
+	mockRepo.On("DeleteMessage", ctx, int64(8)).Return(nil)
+	mockRedis.On("PublishFileInfected", ctx, model.FileInfectedEvent{
+		Bucket:     "chat-files",
+		ObjectName: "1/abc.pdf",
+		FileName:   "report.pdf",
+		Signature:  signature,
+		MessageIDs: []int64{7, 8},
+	}, []int64{1}).Return(nil)
+
+	err := service.Scan(ctx, fileScan(model.FileStatusPending))
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRedis.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+	mockRedis.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestScan_ScannerErrorLeavesFilePending(t *testing.T) {
+	service, mockRepo, _, mockStorage, mockScanner := newTestScanService()
+
+	ctx := context.Background()
+	mockStorage.On("GetFile", ctx, "chat-files", "1/abc.pdf").
+		Return(io.NopCloser(strings.NewReader("data")), nil)
+	mockScanner.On("Scan", ctx, mock.Anything).
+		Return(model.ScanResult{}, errors.New("clamd: connection refused"))
+
+	err := service.Scan(ctx, fileScan(model.FileStatusPending))
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "FinishFileScan", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "SetMessagesFileStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestScan_VerdictAlreadyRecorded(t *testing.T) {
+	service, mockRepo, _, mockStorage, mockScanner := newTestScanService()
+
+	ctx := context.Background()
+	mockStorage.On("GetFile", ctx, "chat-files", "1/abc.pdf").
+		Return(io.NopCloser(strings.NewReader("data")), nil)
+	mockScanner.On("Scan", ctx, mock.Anything).
+		Return(model.ScanResult{}, nil)
+	mockRepo.On("FinishFileScan", ctx, "chat-files", "1/abc.pdf", model.FileStatusClean, (*string)(nil)).
+		Return(false, nil)
+
+	err := service.Scan(ctx, fileScan(model.FileStatusPending))
+
+	assert.NoError(t, err)
+	mockRepo.AssertNotCalled(t, "SetMessagesFileStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRegister_WithoutScannerIsClean(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	chat := NewChatService(mockRepo, new(redisMocks.MockRedisClient), new(grpcMocks.MockUserClient), nil)
+	service := NewScanService(mockRepo, new(repoMocks.MockObjectStorage), nil, chat, nil)
+
+	ctx := context.Background()
+	mockRepo.On("CreateFileScan", ctx, mock.MatchedBy(func(scan *model.FileScan) bool {
+		return scan.Status == model.FileStatusClean && scan.UserID == 1
+	})).Return(nil)
+
+	status, err := service.Register(ctx, 1, "chat-files", "1/abc.pdf", "report.pdf", model.ImageInfo{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, model.FileStatusClean, status)
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/ports"
+)
+
+// MinUploadChunkSize is the smallest chunk accepted before the last one. It
+// is the minimum part size of S3 multipart uploads.
+const MinUploadChunkSize = 5 * 1024 * 1024
+
+const expiredUploadBatch = 100
+
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("chunk offset does not match the upload offset")
+	ErrUploadChunkTooSmall  = fmt.Errorf("chunks before the last one must be at least %d MB", MinUploadChunkSize/(1024*1024))
+	ErrUploadChunkTooLarge  = errors.New("chunk exceeds the declared upload length")
+)
+
+// UploadService receives files in chunks that can be resumed after a dropped
+// connection. Every chunk becomes a part of a multipart upload, which is
+// completed into the final object when the last byte arrives. A finished
+// upload is kept until it expires, so a client that lost the response to its
+// last chunk can still learn where the file went.
+type UploadService struct {
+	repo    ports.ChatRepository
+	storage ports.MultipartStorage
+	expiry  time.Duration
+}
+
+// NewUploadService creates an upload service whose uploads are abandoned
+// when no chunk arrives for expiry.
+func NewUploadService(repo ports.ChatRepository, storage ports.MultipartStorage, expiry time.Duration) *UploadService {
+	return &UploadService{
+		repo:    repo,
+		storage: storage,
+		expiry:  expiry,
+	}
+}
+
+// CreateUpload starts an upload of size bytes. Size limits and allowed types
+// are checked by the caller.
+func (s *UploadService) CreateUpload(ctx context.Context, userID int64, fileName, mimeType string, size int64) (*model.FileUpload, error) {
+	if size <= 0 {
+		return nil, errors.New("upload length must be positive")
+	}
+
+	id, err := newUploadID()
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &model.FileUpload{
+		ID:         id,
+		UserID:     userID,
+		Bucket:     DetermineBucket(mimeType),
+		ObjectName: fmt.Sprintf("%d/%s%s", userID, id, filepath.Ext(fileName)),
+		FileName:   fileName,
+		MimeType:   mimeType,
+		Size:       size,
+		ExpiresAt:  time.Now().Add(s.expiry),
+	}
+
+	upload.MultipartID, err = s.storage.NewMultipartUpload(ctx, upload.Bucket, upload.ObjectName, mimeType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start upload: %w", err)
+	}
+
+	if err := s.repo.CreateUpload(ctx, upload); err != nil {
+		_ = s.storage.AbortMultipartUpload(ctx, upload.Bucket, upload.ObjectName, upload.MultipartID)
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// GetUpload returns one of the user's uploads that has not expired, finished
+// or not.
+func (s *UploadService) GetUpload(ctx context.Context, userID int64, id string) (*model.FileUpload, error) {
+	upload, err := s.repo.GetUpload(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if upload == nil || upload.UserID != userID || time.Now().After(upload.ExpiresAt) {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// WriteChunk stores length bytes read from chunk at offset, which must be the
+// upload's current offset. A chunk cut short fails without moving the offset,
+// so the client resumes from where the last complete chunk ended. The
+// returned upload is Done once the file has been assembled; sending the last
+// chunk again then returns it without storing anything.
+func (s *UploadService) WriteChunk(ctx context.Context, userID int64, id string, offset int64, chunk io.Reader, length int64) (*model.FileUpload, error) {
+	upload, err := s.GetUpload(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if upload.Done() {
+		if length > 0 && offset+length == upload.Size {
+			return upload, nil
+		}
+		return nil, ErrUploadOffsetMismatch
+	}
+
+	if offset != upload.Offset {
+		return nil, ErrUploadOffsetMismatch
+	}
+	if length <= 0 {
+		return nil, errors.New("chunk is empty")
+	}
+	if offset+length > upload.Size {
+		return nil, ErrUploadChunkTooLarge
+	}
+
+	last := offset+length == upload.Size
+	if !last && length < MinUploadChunkSize {
+		return nil, ErrUploadChunkTooSmall
+	}
+
+	partNumber := upload.PartCount + 1
+	if err := s.storage.UploadPart(ctx, upload.Bucket, upload.ObjectName, upload.MultipartID, partNumber, chunk, length); err != nil {
+		return nil, fmt.Errorf("failed to store chunk: %w", err)
+	}
+
+	if last {
+		if err := s.storage.CompleteMultipartUpload(ctx, upload.Bucket, upload.ObjectName, upload.MultipartID); err != nil {
+			return nil, fmt.Errorf("failed to complete upload: %w", err)
+		}
+	}
+
+	expiresAt := time.Now().Add(s.expiry)
+	advanced, err := s.repo.AdvanceUpload(ctx, id, offset, offset+length, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if !advanced {
+		return nil, ErrUploadOffsetMismatch
+	}
+
+	upload.Offset += length
+	upload.PartCount = partNumber
+	upload.ExpiresAt = expiresAt
+
+	return upload, nil
+}
+
+// CancelUpload abandons an upload and discards its chunks. Cancelling a
+// finished upload only forgets it; the file itself is kept.
+func (s *UploadService) CancelUpload(ctx context.Context, userID int64, id string) error {
+	upload, err := s.GetUpload(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	return s.discard(ctx, upload)
+}
+
+// ExpireUploads discards the uploads that received no chunk within the
+// expiry, and forgets finished ones once they expire. It returns how many
+// there were.
+func (s *UploadService) ExpireUploads(ctx context.Context) (int, error) {
+	expired := 0
+	for {
+		uploads, err := s.repo.GetExpiredUploads(ctx, time.Now(), expiredUploadBatch)
+		if err != nil {
+			return expired, err
+		}
+
+		for i := range uploads {
+			if err := s.discard(ctx, &uploads[i]); err != nil {
+				return expired, err
+			}
+			expired++
+		}
+
+		if len(uploads) < expiredUploadBatch {
+			return expired, nil
+		}
+	}
+}
+
+func (s *UploadService) discard(ctx context.Context, upload *model.FileUpload) error {
+	if upload.Done() {
+		return s.repo.DeleteUpload(ctx, upload.ID)
+	}
+
+	// The multipart upload may already be gone, e.g. when an earlier attempt
+	// aborted it but failed to delete the row. The row is removed regardless.
+	if err := s.storage.AbortMultipartUpload(ctx, upload.Bucket, upload.ObjectName, upload.MultipartID); err != nil {
+		log.Printf("Failed to abort upload %s: %v", upload.ID, err)
+	}
+	return s.repo.DeleteUpload(ctx, upload.ID)
+}
+
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+	repoMocks "github.com/zhanserikAmangeldi/chat-service/internal/core/ports/mocks"
+)
+
+const testUploadExpiry = time.Hour
+
+func pendingUpload(offset, size int64, parts int) *model.FileUpload {
+	return &model.FileUpload{
+		ID:          "u1",
+		UserID:      1,
+		Bucket:      "chat-video",
+		ObjectName:  "1/u1.mp4",
+		MultipartID: "mp1",
+		FileName:    "clip.mp4",
+		MimeType:    "video/mp4",
+		Size:        size,
+		Offset:      offset,
+		PartCount:   parts,
+		ExpiresAt:   time.Now().Add(testUploadExpiry),
+	}
+}
+
+func TestCreateUpload_StartsMultipartUpload(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockMultipartStorage)
+
+	service := NewUploadService(mockRepo, mockStorage, testUploadExpiry)
+
+	ctx := context.Background()
+
+	mockStorage.On("NewMultipartUpload", ctx, "chat-video", mock.MatchedBy(func(name string) bool {
+		return strings.HasPrefix(name, "1/") && strings.HasSuffix(name, ".mp4")
+	}), "video/mp4").Return("mp1", nil)
+	mockRepo.On("CreateUpload", ctx, mock.MatchedBy(func(u *model.FileUpload) bool {
+		return u.MultipartID == "mp1" && u.UserID == 1 && u.Size == 20<<20 && u.Offset == 0
+	})).Return(nil)
+
+	upload, err := service.CreateUpload(ctx, 1, "clip.mp4", "video/mp4", 20<<20)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "chat-video", upload.Bucket)
+	assert.Equal(t, "1/"+upload.ID+".mp4", upload.ObjectName)
+	mockStorage.AssertExpectations(t)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestWriteChunk_StoresPartAndAdvancesOffset(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockMultipartStorage)
+
+	service := NewUploadService(mockRepo, mockStorage, testUploadExpiry)
+
+	ctx := context.Background()
+	chunk := strings.NewReader("chunk")
+
+	mockRepo.On("GetUpload", ctx, "u1").Return(pendingUpload(0, 20<<20, 0), nil)
+	mockStorage.On("UploadPart", ctx, "chat-video", "1/u1.mp4", "mp1", 1, chunk, int64(MinUploadChunkSize)).Return(nil)
+	mockRepo.On("AdvanceUpload", ctx, "u1", int64(0), int64(MinUploadChunkSize), mock.AnythingOfType("time.Time")).Return(true, nil)
+
+	upload, err := service.WriteChunk(ctx, 1, "u1", 0, chunk, MinUploadChunkSize)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(MinUploadChunkSize), upload.Offset)
+	assert.False(t, upload.Done())
+	mockStorage.AssertNotCalled(t, "CompleteMultipartUpload")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestWriteChunk_LastChunkCompletesUpload(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockMultipartStorage)
+
+	service := NewUploadService(mockRepo, mockStorage, testUploadExpiry)
+
+	ctx := context.Background()
+	chunk := strings.NewReader("tail")
+	size := int64(MinUploadChunkSize + 100)
+
+	mockRepo.On("GetUpload", ctx, "u1").Return(pendingUpload(MinUploadChunkSize, size, 1), nil)
+	mockStorage.On("UploadPart", ctx, "chat-video", "1/u1.mp4", "mp1", 2, chunk, int64(100)).Return(nil)
+	mockStorage.On("CompleteMultipartUpload", ctx, "chat-video", "1/u1.mp4", "mp1").Return(nil)
+	mockRepo.On("AdvanceUpload", ctx, "u1", int64(MinUploadChunkSize), size, mock.AnythingOfType("time.Time")).Return(true, nil)
+
+	upload, err := service.WriteChunk(ctx, 1, "u1", MinUploadChunkSize, chunk, 100)
+
+	assert.NoError(t, err)
+	assert.True(t, upload.Done())
+	mockStorage.AssertExpectations(t)
+	mockRepo.AssertExpectations(t)
+	// The finished upload is kept until it expires.
+	mockRepo.AssertNotCalled(t, "DeleteUpload", mock.Anything, mock.Anything)
+}
+
+func TestWriteChunk_RepeatedLastChunkReturnsFinishedUpload(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockMultipartStorage)
+
+	service := NewUploadService(mockRepo, mockStorage, testUploadExpiry)
+
+	ctx := context.Background()
+	size := int64(MinUploadChunkSize + 100)
+
+	mockRepo.On("GetUpload", ctx, "u1").Return(pendingUpload(size, size, 2), nil)
+
+	upload, err := service.WriteChunk(ctx, 1, "u1", MinUploadChunkSize, strings.NewReader("tail"), 100)
+
+	assert.NoError(t, err)
+	assert.True(t, upload.Done())
+	mockStorage.AssertNotCalled(t, "UploadPart")
+	mockStorage.AssertNotCalled(t, "CompleteMultipartUpload")
+	mockRepo.AssertNotCalled(t, "AdvanceUpload")
+}
+
+func TestWriteChunk_FinishedUploadRejectsOtherChunks(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockMultipartStorage)
+
+	service := NewUploadService(mockRepo, mockStorage, testUploadExpiry)
+
+	ctx := context.Background()
+	size := int64(MinUploadChunkSize + 100)
+
+	mockRepo.On("GetUpload", ctx, "u1").Return(pendingUpload(size, size, 2), nil)
+
+	_, err := service.WriteChunk(ctx, 1, "u1", 0, strings.NewReader("chunk"), MinUploadChunkSize)
+
+	assert.ErrorIs(t, err, ErrUploadOffsetMismatch)
+	mockStorage.AssertNotCalled(t, "UploadPart")
+}
+
+func TestWriteChunk_RejectsWrongOffset(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockMultipartStorage)
+
+	service := NewUploadService(mockRepo, mockStorage, testUploadExpiry)
+
+	ctx := context.Background()
+
+	mockRepo.On("GetUpload", ctx, "u1").Return(pendingUpload(MinUploadChunkSize, 20<<20, 1), nil)
+
+	_, err := service.WriteChunk(ctx, 1, "u1", 0, strings.NewReader("again"), MinUploadChunkSize)
+
+	assert.ErrorIs(t, err, ErrUploadOffsetMismatch)
+	mockStorage.AssertNotCalled(t, "UploadPart")
+}
+
+func TestWriteChunk_RejectsSmallChunkBeforeLast(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockMultipartStorage)
+
+	service := NewUploadService(mockRepo, mockStorage, testUploadExpiry)
+
+	ctx := context.Background()
+
+	mockRepo.On("GetUpload", ctx, "u1").Return(pendingUpload(0, 20<<20, 0), nil)
+
+	_, err := service.WriteChunk(ctx, 1, "u1", 0, strings.NewReader("tiny"), 4)
+
+	assert.ErrorIs(t, err, ErrUploadChunkTooSmall)
+	mockStorage.AssertNotCalled(t, "UploadPart")
+}
+
+func TestWriteChunk_OtherUsersUploadNotFound(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockMultipartStorage)
+
+	service := NewUploadService(mockRepo, mockStorage, testUploadExpiry)
+
+	ctx := context.Background()
+
+	mockRepo.On("GetUpload", ctx, "u1").Return(pendingUpload(0, 20<<20, 0), nil)
+
+	_, err := service.WriteChunk(ctx, 2, "u1", 0, strings.NewReader("chunk"), MinUploadChunkSize)
+
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+}
+
+func TestExpireUploads_AbortsAndDeletes(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockMultipartStorage)
+
+	service := NewUploadService(mockRepo, mockStorage, testUploadExpiry)
+
+	ctx := context.Background()
+
+	mockRepo.On("GetExpiredUploads", ctx, mock.AnythingOfType("time.Time"), expiredUploadBatch).
+		Return([]model.FileUpload{*pendingUpload(0, 20<<20, 0)}, nil)
+	mockStorage.On("AbortMultipartUpload", ctx, "chat-video", "1/u1.mp4", "mp1").Return(nil)
+	mockRepo.On("DeleteUpload", ctx, "u1").Return(nil)
+
+	expired, err := service.ExpireUploads(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	mockStorage.AssertExpectations(t)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestExpireUploads_ForgetsFinishedUploads(t *testing.T) {
+	mockRepo := new(repoMocks.MockChatRepository)
+	mockStorage := new(repoMocks.MockMultipartStorage)
+
+	service := NewUploadService(mockRepo, mockStorage, testUploadExpiry)
+
+	ctx := context.Background()
+
+	mockRepo.On("GetExpiredUploads", ctx, mock.AnythingOfType("time.Time"), expiredUploadBatch).
+		Return([]model.FileUpload{*pendingUpload(20<<20, 20<<20, 4)}, nil)
+	mockRepo.On("DeleteUpload", ctx, "u1").Return(nil)
+
+	expired, err := service.ExpireUploads(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	mockRepo.AssertExpectations(t)
+	mockStorage.AssertNotCalled(t, "AbortMultipartUpload", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
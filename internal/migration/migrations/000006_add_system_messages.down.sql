@@ -0,0 +1,4 @@
+DELETE FROM messages WHERE message_type = 'system';
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_message_type_check;
+ALTER TABLE messages ADD CONSTRAINT messages_message_type_check
+    CHECK (message_type IN ('text', 'image', 'file', 'audio', 'video'));
@@ -0,0 +1,3 @@
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_message_type_check;
+ALTER TABLE messages ADD CONSTRAINT messages_message_type_check
+    CHECK (message_type IN ('text', 'image', 'file', 'audio', 'video', 'system'));
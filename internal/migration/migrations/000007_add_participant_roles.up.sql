@@ -0,0 +1,15 @@
+ALTER TABLE participants
+    ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'admin', 'member'));
+
+-- Existing groups have no owner; promote the earliest member of each one.
+UPDATE participants p
+SET role = 'owner'
+FROM (
+    SELECT DISTINCT ON (p2.conversation_id) p2.conversation_id, p2.user_id
+    FROM participants p2
+    JOIN conversations c ON c.id = p2.conversation_id
+    WHERE c.is_group = true
+    ORDER BY p2.conversation_id, p2.joined_at, p2.user_id
+) first_member
+WHERE p.conversation_id = first_member.conversation_id
+  AND p.user_id = first_member.user_id;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_messages_conv_id_id ON messages(conversation_id, id);
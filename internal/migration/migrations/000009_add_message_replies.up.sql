@@ -0,0 +1 @@
+ALTER TABLE messages ADD COLUMN reply_to_message_id BIGINT REFERENCES messages(id) ON DELETE SET NULL;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS thread_reads;
+DROP INDEX IF EXISTS idx_messages_thread_root_id_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS thread_root_id;
@@ -0,0 +1,13 @@
+ALTER TABLE messages ADD COLUMN thread_root_id BIGINT REFERENCES messages(id) ON DELETE CASCADE;
+
+CREATE INDEX idx_messages_thread_root_id_id ON messages(thread_root_id, id) WHERE thread_root_id IS NOT NULL;
+
+CREATE TABLE thread_reads (
+                              root_message_id BIGINT REFERENCES messages(id) ON DELETE CASCADE,
+                              user_id BIGINT NOT NULL,
+                              last_read_message_id BIGINT NOT NULL DEFAULT 0,
+                              updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+                              PRIMARY KEY (root_message_id, user_id)
+);
+
+CREATE INDEX idx_thread_reads_user_id ON thread_reads(user_id);
@@ -0,0 +1,3 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS forwarded_from_conversation_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS forwarded_from_sender_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS forwarded_from_message_id;
@@ -0,0 +1,3 @@
+ALTER TABLE messages ADD COLUMN forwarded_from_message_id BIGINT REFERENCES messages(id) ON DELETE SET NULL;
+ALTER TABLE messages ADD COLUMN forwarded_from_sender_id BIGINT;
+ALTER TABLE messages ADD COLUMN forwarded_from_conversation_id BIGINT REFERENCES conversations(id) ON DELETE SET NULL;
@@ -0,0 +1,8 @@
+CREATE TABLE message_mentions (
+                                  message_id BIGINT REFERENCES messages(id) ON DELETE CASCADE,
+                                  user_id BIGINT NOT NULL,
+                                  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+                                  PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX idx_message_mentions_user_id ON message_mentions(user_id);
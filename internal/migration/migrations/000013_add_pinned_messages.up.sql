@@ -0,0 +1,9 @@
+CREATE TABLE pinned_messages (
+                                 conversation_id BIGINT REFERENCES conversations(id) ON DELETE CASCADE,
+                                 message_id BIGINT REFERENCES messages(id) ON DELETE CASCADE,
+                                 pinned_by BIGINT NOT NULL,
+                                 pinned_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+                                 PRIMARY KEY (conversation_id, message_id)
+);
+
+CREATE INDEX idx_pinned_messages_conv_pinned_at ON pinned_messages(conversation_id, pinned_at DESC);
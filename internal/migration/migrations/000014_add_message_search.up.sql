@@ -0,0 +1,4 @@
+ALTER TABLE messages ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple', coalesce(content, '') || ' ' || coalesce(file_name, ''))) STORED;
+
+CREATE INDEX idx_messages_search_vector ON messages USING GIN (search_vector);
@@ -0,0 +1,7 @@
+CREATE TABLE user_presence (
+                               user_id BIGINT PRIMARY KEY,
+                               visibility VARCHAR(20) NOT NULL DEFAULT 'everyone',
+                               last_seen_at TIMESTAMP WITH TIME ZONE,
+                               updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+                               CONSTRAINT user_presence_visibility_check CHECK (visibility IN ('everyone', 'contacts', 'nobody'))
+);
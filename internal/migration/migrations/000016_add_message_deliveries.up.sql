@@ -0,0 +1,8 @@
+CREATE TABLE message_deliveries (
+                                    message_id BIGINT REFERENCES messages(id) ON DELETE CASCADE,
+                                    user_id BIGINT NOT NULL,
+                                    delivered_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+                                    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX idx_message_deliveries_user_id ON message_deliveries(user_id);
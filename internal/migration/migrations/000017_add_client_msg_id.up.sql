@@ -0,0 +1,3 @@
+ALTER TABLE messages ADD COLUMN client_msg_id VARCHAR(64);
+
+CREATE UNIQUE INDEX idx_messages_client_msg_id ON messages(conversation_id, sender_id, client_msg_id) WHERE client_msg_id IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE participants DROP COLUMN IF EXISTS last_read_message_id;
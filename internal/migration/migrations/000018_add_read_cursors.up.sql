@@ -0,0 +1,15 @@
+ALTER TABLE participants ADD COLUMN last_read_message_id BIGINT NOT NULL DEFAULT 0;
+
+-- Start every cursor at the newest main timeline message the participant
+-- had marked as read individually.
+UPDATE participants p
+SET last_read_message_id = latest.message_id
+FROM (
+    SELECT m.conversation_id, mr.user_id, MAX(m.id) AS message_id
+    FROM message_reads mr
+    JOIN messages m ON m.id = mr.message_id
+    WHERE m.thread_root_id IS NULL
+    GROUP BY m.conversation_id, mr.user_id
+) latest
+WHERE p.conversation_id = latest.conversation_id
+  AND p.user_id = latest.user_id;
@@ -0,0 +1,16 @@
+CREATE TABLE file_uploads (
+                              id VARCHAR(32) PRIMARY KEY,
+                              user_id BIGINT NOT NULL,
+                              bucket VARCHAR(64) NOT NULL,
+                              object_name VARCHAR(255) NOT NULL,
+                              multipart_id VARCHAR(255) NOT NULL,
+                              file_name VARCHAR(255) NOT NULL,
+                              mime_type VARCHAR(255) NOT NULL,
+                              size BIGINT NOT NULL,
+                              upload_offset BIGINT NOT NULL DEFAULT 0,
+                              part_count INT NOT NULL DEFAULT 0,
+                              created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+                              expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_file_uploads_expires_at ON file_uploads(expires_at);
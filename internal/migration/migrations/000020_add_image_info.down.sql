@@ -0,0 +1,4 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS thumbnails;
+ALTER TABLE messages DROP COLUMN IF EXISTS blurhash;
+ALTER TABLE messages DROP COLUMN IF EXISTS image_height;
+ALTER TABLE messages DROP COLUMN IF EXISTS image_width;
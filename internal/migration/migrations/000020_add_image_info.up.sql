@@ -0,0 +1,4 @@
+ALTER TABLE messages ADD COLUMN image_width INT;
+ALTER TABLE messages ADD COLUMN image_height INT;
+ALTER TABLE messages ADD COLUMN blurhash VARCHAR(64);
+ALTER TABLE messages ADD COLUMN thumbnails JSONB;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_messages_file_object;
+ALTER TABLE messages DROP COLUMN IF EXISTS file_status;
+ALTER TABLE messages DROP COLUMN IF EXISTS file_object;
+ALTER TABLE messages DROP COLUMN IF EXISTS file_bucket;
+DROP TABLE IF EXISTS file_scans;
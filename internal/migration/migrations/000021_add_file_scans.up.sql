@@ -0,0 +1,19 @@
+CREATE TABLE file_scans (
+                            bucket VARCHAR(64) NOT NULL,
+                            object_name VARCHAR(255) NOT NULL,
+                            user_id BIGINT NOT NULL,
+                            file_name VARCHAR(255) NOT NULL,
+                            status VARCHAR(16) NOT NULL DEFAULT 'pending',
+                            signature VARCHAR(255),
+                            created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+                            updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+                            PRIMARY KEY (bucket, object_name)
+);
+
+CREATE INDEX idx_file_scans_pending ON file_scans(updated_at) WHERE status = 'pending';
+
+ALTER TABLE messages ADD COLUMN file_bucket VARCHAR(64);
+ALTER TABLE messages ADD COLUMN file_object VARCHAR(255);
+ALTER TABLE messages ADD COLUMN file_status VARCHAR(16);
+
+CREATE INDEX idx_messages_file_object ON messages(file_bucket, file_object) WHERE file_object IS NOT NULL;
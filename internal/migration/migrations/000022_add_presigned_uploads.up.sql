@@ -0,0 +1,13 @@
+CREATE TABLE presigned_uploads (
+                                   id VARCHAR(32) PRIMARY KEY,
+                                   user_id BIGINT NOT NULL,
+                                   bucket VARCHAR(64) NOT NULL,
+                                   staging_object VARCHAR(255) NOT NULL,
+                                   file_name VARCHAR(255) NOT NULL,
+                                   mime_type VARCHAR(255) NOT NULL,
+                                   finalized BOOLEAN NOT NULL DEFAULT FALSE,
+                                   created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+                                   expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_presigned_uploads_expires_at ON presigned_uploads(expires_at);
@@ -0,0 +1,4 @@
+ALTER TABLE file_scans DROP COLUMN IF EXISTS thumbnails;
+ALTER TABLE file_scans DROP COLUMN IF EXISTS blurhash;
+ALTER TABLE file_scans DROP COLUMN IF EXISTS image_height;
+ALTER TABLE file_scans DROP COLUMN IF EXISTS image_width;
@@ -0,0 +1,4 @@
+ALTER TABLE file_scans ADD COLUMN image_width INT;
+ALTER TABLE file_scans ADD COLUMN image_height INT;
+ALTER TABLE file_scans ADD COLUMN blurhash VARCHAR(64);
+ALTER TABLE file_scans ADD COLUMN thumbnails JSONB;
@@ -0,0 +1,48 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockPresenceStore struct {
+	mock.Mock
+}
+
+func (m *MockPresenceStore) SetSession(ctx context.Context, userID int64, sessionID, status string, ttl time.Duration) error {
+	args := m.Called(ctx, userID, sessionID, status, ttl)
+	return args.Error(0)
+}
+
+func (m *MockPresenceStore) RefreshSession(ctx context.Context, userID int64, sessionID string, ttl time.Duration) error {
+	args := m.Called(ctx, userID, sessionID, ttl)
+	return args.Error(0)
+}
+
+func (m *MockPresenceStore) RemoveSession(ctx context.Context, userID int64, sessionID string) error {
+	args := m.Called(ctx, userID, sessionID)
+	return args.Error(0)
+}
+
+func (m *MockPresenceStore) GetStatuses(ctx context.Context, userIDs []int64) (map[int64]string, error) {
+	args := m.Called(ctx, userIDs)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[int64]string), args.Error(1)
+}
+
+func (m *MockPresenceStore) StaleUsers(ctx context.Context, before time.Time, limit int64) (map[int64]time.Time, error) {
+	args := m.Called(ctx, before, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[int64]time.Time), args.Error(1)
+}
+
+func (m *MockPresenceStore) ClaimOffline(ctx context.Context, userID int64, before time.Time) (bool, error) {
+	args := m.Called(ctx, userID, before)
+	return args.Bool(0), args.Error(1)
+}
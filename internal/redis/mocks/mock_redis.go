@@ -42,16 +42,61 @@ func (m *MockRedisClient) PublishReadReceipt(ctx context.Context, readReceipt mo
 	return args.Error(0)
 }
 
+func (m *MockRedisClient) PublishDeliveryReceipt(ctx context.Context, delivery model.MessageDelivery, recipients []int64) error {
+	args := m.Called(ctx, delivery, recipients)
+	return args.Error(0)
+}
+
 func (m *MockRedisClient) PublishMessageEdit(ctx context.Context, msg model.Message, recipients []int64) error {
 	args := m.Called(ctx, msg, recipients)
 	return args.Error(0)
 }
 
+func (m *MockRedisClient) PublishThreadReply(ctx context.Context, msg model.Message, recipients []int64) error {
+	args := m.Called(ctx, msg, recipients)
+	return args.Error(0)
+}
+
+func (m *MockRedisClient) PublishMention(ctx context.Context, msg model.Message, recipients []int64) error {
+	args := m.Called(ctx, msg, recipients)
+	return args.Error(0)
+}
+
+func (m *MockRedisClient) PublishMessagePinned(ctx context.Context, pin model.PinnedMessage, recipients []int64) error {
+	args := m.Called(ctx, pin, recipients)
+	return args.Error(0)
+}
+
+func (m *MockRedisClient) PublishMessageUnpinned(ctx context.Context, unpin model.UnpinnedMessage, recipients []int64) error {
+	args := m.Called(ctx, unpin, recipients)
+	return args.Error(0)
+}
+
 func (m *MockRedisClient) PublishMessageDeletion(ctx context.Context, messageID int64, recipients []int64) error {
 	args := m.Called(ctx, messageID, recipients)
 	return args.Error(0)
 }
 
+func (m *MockRedisClient) PublishParticipantAdded(ctx context.Context, event model.ParticipantEvent, recipients []int64) error {
+	args := m.Called(ctx, event, recipients)
+	return args.Error(0)
+}
+
+func (m *MockRedisClient) PublishParticipantRemoved(ctx context.Context, event model.ParticipantEvent, recipients []int64) error {
+	args := m.Called(ctx, event, recipients)
+	return args.Error(0)
+}
+
+func (m *MockRedisClient) PublishParticipantRoleChanged(ctx context.Context, event model.ParticipantEvent, recipients []int64) error {
+	args := m.Called(ctx, event, recipients)
+	return args.Error(0)
+}
+
+func (m *MockRedisClient) PublishFileInfected(ctx context.Context, event model.FileInfectedEvent, recipients []int64) error {
+	args := m.Called(ctx, event, recipients)
+	return args.Error(0)
+}
+
 func (m *MockRedisClient) Subscribe(ctx context.Context) <-chan redis.BroadcastMessage {
 	args := m.Called(ctx)
 	return args.Get(0).(<-chan redis.BroadcastMessage)
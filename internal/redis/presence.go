@@ -0,0 +1,201 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+)
+
+// IPresenceStore tracks which sessions of a user are alive. Every session has
+// its own key that expires unless refreshed, so sessions of a crashed
+// instance fall away on their own. Users are also kept in a sorted set by the
+// time any of their sessions was last written, which outlives the session
+// keys so that users whose sessions expired can still be found and announced
+// offline.
+type IPresenceStore interface {
+	SetSession(ctx context.Context, userID int64, sessionID, status string, ttl time.Duration) error
+	RefreshSession(ctx context.Context, userID int64, sessionID string, ttl time.Duration) error
+	RemoveSession(ctx context.Context, userID int64, sessionID string) error
+	GetStatuses(ctx context.Context, userIDs []int64) (map[int64]string, error)
+	StaleUsers(ctx context.Context, before time.Time, limit int64) (map[int64]time.Time, error)
+	ClaimOffline(ctx context.Context, userID int64, before time.Time) (bool, error)
+}
+
+type PresenceStore struct {
+	client *redis.Client
+}
+
+func NewPresenceStore(redisClient *RedisClient) *PresenceStore {
+	return &PresenceStore{client: redisClient.client}
+}
+
+func presenceSessionKey(userID int64, sessionID string) string {
+	return fmt.Sprintf("presence:session:%d:%s", userID, sessionID)
+}
+
+func presenceIndexKey(userID int64) string {
+	return fmt.Sprintf("presence:sessions:%d", userID)
+}
+
+// presenceSeenKey scores each user with a live or recently expired session by
+// the Unix milliseconds of their last session write.
+const presenceSeenKey = "presence:seen"
+
+func (p *PresenceStore) SetSession(ctx context.Context, userID int64, sessionID, status string, ttl time.Duration) error {
+	_, err := p.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, presenceSessionKey(userID, sessionID), status, ttl)
+		pipe.SAdd(ctx, presenceIndexKey(userID), sessionID)
+		pipe.Expire(ctx, presenceIndexKey(userID), ttl)
+		pipe.ZAdd(ctx, presenceSeenKey, seenMember(userID, time.Now()))
+		return nil
+	})
+	return err
+}
+
+// RefreshSession extends a session's TTL. A session whose key already
+// expired, e.g. after a long GC pause, is recreated as online.
+func (p *PresenceStore) RefreshSession(ctx context.Context, userID int64, sessionID string, ttl time.Duration) error {
+	alive, err := p.client.Expire(ctx, presenceSessionKey(userID, sessionID), ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !alive {
+		return p.SetSession(ctx, userID, sessionID, model.PresenceOnline, ttl)
+	}
+	_, err = p.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Expire(ctx, presenceIndexKey(userID), ttl)
+		pipe.ZAdd(ctx, presenceSeenKey, seenMember(userID, time.Now()))
+		return nil
+	})
+	return err
+}
+
+func (p *PresenceStore) RemoveSession(ctx context.Context, userID int64, sessionID string) error {
+	_, err := p.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, presenceSessionKey(userID, sessionID))
+		pipe.SRem(ctx, presenceIndexKey(userID), sessionID)
+		return nil
+	})
+	return err
+}
+
+// GetStatuses returns the combined status of each user: online if any
+// session is online, away if all live sessions are away, offline otherwise.
+// Index entries of expired sessions are pruned along the way.
+func (p *PresenceStore) GetStatuses(ctx context.Context, userIDs []int64) (map[int64]string, error) {
+	statuses := make(map[int64]string, len(userIDs))
+	if len(userIDs) == 0 {
+		return statuses, nil
+	}
+
+	indexCmds := make([]*redis.StringSliceCmd, len(userIDs))
+	_, err := p.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, userID := range userIDs {
+			indexCmds[i] = pipe.SMembers(ctx, presenceIndexKey(userID))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	type sessionRef struct {
+		userID    int64
+		sessionID string
+	}
+	var refs []sessionRef
+	var keys []string
+	for i, userID := range userIDs {
+		statuses[userID] = model.PresenceOffline
+		for _, sessionID := range indexCmds[i].Val() {
+			refs = append(refs, sessionRef{userID, sessionID})
+			keys = append(keys, presenceSessionKey(userID, sessionID))
+		}
+	}
+	if len(keys) == 0 {
+		return statuses, nil
+	}
+
+	values, err := p.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []sessionRef
+	for i, value := range values {
+		status, ok := value.(string)
+		if !ok {
+			expired = append(expired, refs[i])
+			continue
+		}
+		userID := refs[i].userID
+		if status == model.PresenceOnline || statuses[userID] == model.PresenceOffline {
+			statuses[userID] = status
+		}
+	}
+
+	if len(expired) > 0 {
+		p.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, ref := range expired {
+				pipe.SRem(ctx, presenceIndexKey(ref.userID), ref.sessionID)
+			}
+			return nil
+		})
+	}
+
+	return statuses, nil
+}
+
+// StaleUsers returns up to limit users none of whose sessions was written
+// since before, with the time of their last session write.
+func (p *PresenceStore) StaleUsers(ctx context.Context, before time.Time, limit int64) (map[int64]time.Time, error) {
+	members, err := p.client.ZRangeArgsWithScores(ctx, redis.ZRangeArgs{
+		Key:     presenceSeenKey,
+		Start:   "-inf",
+		Stop:    strconv.FormatInt(before.UnixMilli(), 10),
+		ByScore: true,
+		Count:   limit,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[int64]time.Time, len(members))
+	for _, member := range members {
+		userID, err := strconv.ParseInt(fmt.Sprint(member.Member), 10, 64)
+		if err != nil {
+			continue
+		}
+		users[userID] = time.UnixMilli(int64(member.Score))
+	}
+	return users, nil
+}
+
+// claimOfflineScript removes a user from the seen set unless one of their
+// sessions was written since ARGV[2].
+var claimOfflineScript = redis.NewScript(`
+local seen = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if seen and tonumber(seen) <= tonumber(ARGV[2]) then
+	return redis.call('ZREM', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// ClaimOffline removes a user none of whose sessions was written since before
+// from the seen set. Only one caller gets true for a given user, so a user who
+// goes offline is announced once however many instances notice.
+func (p *PresenceStore) ClaimOffline(ctx context.Context, userID int64, before time.Time) (bool, error) {
+	removed, err := claimOfflineScript.Run(ctx, p.client, []string{presenceSeenKey}, userID, before.UnixMilli()).Int()
+	if err != nil {
+		return false, err
+	}
+	return removed == 1, nil
+}
+
+func seenMember(userID int64, at time.Time) redis.Z {
+	return redis.Z{Score: float64(at.UnixMilli()), Member: userID}
+}
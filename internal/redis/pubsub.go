@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
@@ -16,8 +17,17 @@ type IRedisClient interface {
 	PublishReaction(ctx context.Context, reaction model.Reaction, recipients []int64) error
 	PublishReactionRemoval(ctx context.Context, reaction model.Reaction, recipients []int64) error
 	PublishReadReceipt(ctx context.Context, readReceipt model.MessageRead, recipients []int64) error
+	PublishDeliveryReceipt(ctx context.Context, delivery model.MessageDelivery, recipients []int64) error
 	PublishMessageEdit(ctx context.Context, msg model.Message, recipients []int64) error
+	PublishThreadReply(ctx context.Context, msg model.Message, recipients []int64) error
+	PublishMention(ctx context.Context, msg model.Message, recipients []int64) error
+	PublishMessagePinned(ctx context.Context, pin model.PinnedMessage, recipients []int64) error
+	PublishMessageUnpinned(ctx context.Context, unpin model.UnpinnedMessage, recipients []int64) error
 	PublishMessageDeletion(ctx context.Context, messageID int64, recipients []int64) error
+	PublishParticipantAdded(ctx context.Context, event model.ParticipantEvent, recipients []int64) error
+	PublishParticipantRemoved(ctx context.Context, event model.ParticipantEvent, recipients []int64) error
+	PublishParticipantRoleChanged(ctx context.Context, event model.ParticipantEvent, recipients []int64) error
+	PublishFileInfected(ctx context.Context, event model.FileInfectedEvent, recipients []int64) error
 	Subscribe(ctx context.Context) <-chan BroadcastMessage
 }
 
@@ -27,24 +37,56 @@ const (
 	ChannelStatus      = "chat.status"
 	ChannelReaction    = "chat.reaction"
 	ChannelReadReceipt = "chat.read_receipt"
+	ChannelParticipant = "chat.participant"
 )
 
 type BroadcastMessage struct {
-	Type         string         `json:"type"` // message, typing, status, reaction, read_receipt, message_edit, message_delete
+	Type         string         `json:"type"` // message, typing, status, reaction, read_receipt, delivered, message_edit, message_delete, participant_added, participant_removed, participant_role_changed, thread_reply, mention, message_pinned, message_unpinned, file_infected
 	Message      *model.Message `json:"message,omitempty"`
 	RecipientIDs []int64        `json:"recipient_ids"`
 	Payload      interface{}    `json:"payload,omitempty"`
+	// EventIDs holds each recipient's sequence number of a durable event.
+	EventIDs map[int64]int64 `json:"event_ids,omitempty"`
+}
+
+// WSMessage converts the event into the frame sent to clients. It reports
+// false for unknown or malformed events.
+func (b BroadcastMessage) WSMessage() (model.WSMessage, bool) {
+	switch b.Type {
+	case "message", "message_edit", "thread_reply", "mention":
+		if b.Message == nil {
+			return model.WSMessage{}, false
+		}
+		return model.WSMessage{Type: b.Type, Payload: b.Message}, true
+	case "message_delete", "message_pinned", "message_unpinned", "typing", "status", "reaction_add", "reaction_remove",
+		"read_receipt", "delivered", "participant_added", "participant_removed", "participant_role_changed", "file_infected":
+		return model.WSMessage{Type: b.Type, Payload: b.Payload}, true
+	default:
+		return model.WSMessage{}, false
+	}
 }
 
 type RedisClient struct {
-	client *redis.Client
+	client  *redis.Client
+	routing RoutingConfig
 }
 
-func NewRedisClient(addr string) *RedisClient {
+func NewRedisClient(addr string, routing RoutingConfig) *RedisClient {
+	if routing.Mode != RoutingBroadcast {
+		routing.Mode = RoutingTargeted
+	}
+	if routing.InstanceID == "" {
+		routing.InstanceID = newInstanceID()
+	}
+	if routing.InstanceTTL <= 0 {
+		routing.InstanceTTL = 30 * time.Second
+	}
+
 	return &RedisClient{
 		client: redis.NewClient(&redis.Options{
 			Addr: addr,
 		}),
+		routing: routing,
 	}
 }
 
@@ -55,12 +97,7 @@ func (r *RedisClient) Publish(ctx context.Context, msg model.Message, recipients
 		RecipientIDs: recipients,
 	}
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	return r.client.Publish(ctx, ChannelMessage, data).Err()
+	return r.publish(ctx, ChannelMessage, payload)
 }
 
 func (r *RedisClient) PublishTyping(ctx context.Context, event model.TypingEvent, recipients []int64) error {
@@ -70,12 +107,7 @@ func (r *RedisClient) PublishTyping(ctx context.Context, event model.TypingEvent
 		Payload:      event,
 	}
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	return r.client.Publish(ctx, ChannelTyping, data).Err()
+	return r.publish(ctx, ChannelTyping, payload)
 }
 
 func (r *RedisClient) PublishStatus(ctx context.Context, event model.OnlineStatusEvent, recipients []int64) error {
@@ -85,12 +117,7 @@ func (r *RedisClient) PublishStatus(ctx context.Context, event model.OnlineStatu
 		Payload:      event,
 	}
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	return r.client.Publish(ctx, ChannelStatus, data).Err()
+	return r.publish(ctx, ChannelStatus, payload)
 }
 
 func (r *RedisClient) PublishReaction(ctx context.Context, reaction model.Reaction, recipients []int64) error {
@@ -100,12 +127,7 @@ func (r *RedisClient) PublishReaction(ctx context.Context, reaction model.Reacti
 		Payload:      reaction,
 	}
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	return r.client.Publish(ctx, ChannelReaction, data).Err()
+	return r.publish(ctx, ChannelReaction, payload)
 }
 
 func (r *RedisClient) PublishReactionRemoval(ctx context.Context, reaction model.Reaction, recipients []int64) error {
@@ -115,12 +137,7 @@ func (r *RedisClient) PublishReactionRemoval(ctx context.Context, reaction model
 		Payload:      reaction,
 	}
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	return r.client.Publish(ctx, ChannelReaction, data).Err()
+	return r.publish(ctx, ChannelReaction, payload)
 }
 
 func (r *RedisClient) PublishReadReceipt(ctx context.Context, readReceipt model.MessageRead, recipients []int64) error {
@@ -130,12 +147,17 @@ func (r *RedisClient) PublishReadReceipt(ctx context.Context, readReceipt model.
 		Payload:      readReceipt,
 	}
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return err
+	return r.publish(ctx, ChannelReadReceipt, payload)
+}
+
+func (r *RedisClient) PublishDeliveryReceipt(ctx context.Context, delivery model.MessageDelivery, recipients []int64) error {
+	payload := BroadcastMessage{
+		Type:         "delivered",
+		RecipientIDs: recipients,
+		Payload:      delivery,
 	}
 
-	return r.client.Publish(ctx, ChannelReadReceipt, data).Err()
+	return r.publish(ctx, ChannelReadReceipt, payload)
 }
 
 func (r *RedisClient) PublishMessageEdit(ctx context.Context, msg model.Message, recipients []int64) error {
@@ -145,12 +167,47 @@ func (r *RedisClient) PublishMessageEdit(ctx context.Context, msg model.Message,
 		RecipientIDs: recipients,
 	}
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return err
+	return r.publish(ctx, ChannelMessage, payload)
+}
+
+func (r *RedisClient) PublishThreadReply(ctx context.Context, msg model.Message, recipients []int64) error {
+	payload := BroadcastMessage{
+		Type:         "thread_reply",
+		Message:      &msg,
+		RecipientIDs: recipients,
+	}
+
+	return r.publish(ctx, ChannelMessage, payload)
+}
+
+func (r *RedisClient) PublishMention(ctx context.Context, msg model.Message, recipients []int64) error {
+	payload := BroadcastMessage{
+		Type:         "mention",
+		Message:      &msg,
+		RecipientIDs: recipients,
 	}
 
-	return r.client.Publish(ctx, ChannelMessage, data).Err()
+	return r.publish(ctx, ChannelMessage, payload)
+}
+
+func (r *RedisClient) PublishMessagePinned(ctx context.Context, pin model.PinnedMessage, recipients []int64) error {
+	payload := BroadcastMessage{
+		Type:         "message_pinned",
+		RecipientIDs: recipients,
+		Payload:      pin,
+	}
+
+	return r.publish(ctx, ChannelMessage, payload)
+}
+
+func (r *RedisClient) PublishMessageUnpinned(ctx context.Context, unpin model.UnpinnedMessage, recipients []int64) error {
+	payload := BroadcastMessage{
+		Type:         "message_unpinned",
+		RecipientIDs: recipients,
+		Payload:      unpin,
+	}
+
+	return r.publish(ctx, ChannelMessage, payload)
 }
 
 func (r *RedisClient) PublishMessageDeletion(ctx context.Context, messageID int64, recipients []int64) error {
@@ -160,18 +217,60 @@ func (r *RedisClient) PublishMessageDeletion(ctx context.Context, messageID int6
 		Payload:      map[string]int64{"message_id": messageID},
 	}
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return err
+	return r.publish(ctx, ChannelMessage, payload)
+}
+
+func (r *RedisClient) PublishParticipantAdded(ctx context.Context, event model.ParticipantEvent, recipients []int64) error {
+	payload := BroadcastMessage{
+		Type:         "participant_added",
+		RecipientIDs: recipients,
+		Payload:      event,
+	}
+
+	return r.publish(ctx, ChannelParticipant, payload)
+}
+
+func (r *RedisClient) PublishParticipantRemoved(ctx context.Context, event model.ParticipantEvent, recipients []int64) error {
+	payload := BroadcastMessage{
+		Type:         "participant_removed",
+		RecipientIDs: recipients,
+		Payload:      event,
+	}
+
+	return r.publish(ctx, ChannelParticipant, payload)
+}
+
+func (r *RedisClient) PublishParticipantRoleChanged(ctx context.Context, event model.ParticipantEvent, recipients []int64) error {
+	payload := BroadcastMessage{
+		Type:         "participant_role_changed",
+		RecipientIDs: recipients,
+		Payload:      event,
+	}
+
+	return r.publish(ctx, ChannelParticipant, payload)
+}
+
+func (r *RedisClient) PublishFileInfected(ctx context.Context, event model.FileInfectedEvent, recipients []int64) error {
+	payload := BroadcastMessage{
+		Type:         "file_infected",
+		RecipientIDs: recipients,
+		Payload:      event,
 	}
 
-	return r.client.Publish(ctx, ChannelMessage, data).Err()
+	return r.publish(ctx, ChannelMessage, payload)
 }
 
 func (r *RedisClient) Subscribe(ctx context.Context) <-chan BroadcastMessage {
 	ch := make(chan BroadcastMessage)
 
-	pubsub := r.client.Subscribe(ctx, ChannelMessage, ChannelTyping, ChannelStatus, ChannelReaction, ChannelReadReceipt)
+	// The shared channels stay subscribed in targeted mode too, so events
+	// from instances running in broadcast mode or falling back to it still
+	// arrive.
+	channels := []string{ChannelMessage, ChannelTyping, ChannelStatus, ChannelReaction, ChannelReadReceipt, ChannelParticipant}
+	if r.targeted() {
+		channels = append(channels, instanceChannel(r.routing.InstanceID))
+	}
+	pubsub := r.client.Subscribe(ctx, channels...)
 
 	go func() {
 		defer close(ch)
@@ -0,0 +1,229 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// RoutingTargeted publishes every event only to the instances that hold
+	// one of its recipients, looked up in the connection registry.
+	RoutingTargeted = "targeted"
+	// RoutingBroadcast publishes every event on the shared chat.* channels
+	// that all instances subscribe to.
+	RoutingBroadcast = "broadcast"
+
+	channelInstancePrefix = "chat.instance."
+)
+
+type RoutingConfig struct {
+	Mode string
+	// InstanceID names this instance in the registry. A random one is
+	// generated when empty; the stream consumer groups of instances that are
+	// gone are pruned by StreamClient.PruneDeadInstances.
+	InstanceID string
+	// InstanceTTL is how long the instance is considered alive without
+	// refreshing its registration.
+	InstanceTTL time.Duration
+}
+
+func instanceChannel(instanceID string) string {
+	return channelInstancePrefix + instanceID
+}
+
+func routeUserKey(userID int64) string {
+	return fmt.Sprintf("route:user:%d", userID)
+}
+
+func routeInstanceKey(instanceID string) string {
+	return fmt.Sprintf("route:instance:%s", instanceID)
+}
+
+func newInstanceID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	host, err := os.Hostname()
+	if err != nil {
+		host = "chat"
+	}
+	return host + "-" + hex.EncodeToString(b)
+}
+
+func (r *RedisClient) InstanceID() string {
+	return r.routing.InstanceID
+}
+
+func (r *RedisClient) targeted() bool {
+	return r.routing.Mode == RoutingTargeted
+}
+
+// RegisterUser records that the user has a connection on this instance.
+func (r *RedisClient) RegisterUser(ctx context.Context, userID int64) error {
+	if !r.targeted() {
+		return nil
+	}
+	return r.client.SAdd(ctx, routeUserKey(userID), r.routing.InstanceID).Err()
+}
+
+// UnregisterUser removes this instance from the user's routes once the user's
+// last connection on it has closed.
+func (r *RedisClient) UnregisterUser(ctx context.Context, userID int64) error {
+	if !r.targeted() {
+		return nil
+	}
+	return r.client.SRem(ctx, routeUserKey(userID), r.routing.InstanceID).Err()
+}
+
+// RefreshInstance keeps this instance alive in the registry and re-registers
+// the users connected to it, repairing routes lost to racing disconnects.
+func (r *RedisClient) RefreshInstance(ctx context.Context, userIDs []int64) error {
+	if !r.targeted() {
+		return nil
+	}
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, routeInstanceKey(r.routing.InstanceID), time.Now().Unix(), r.routing.InstanceTTL)
+		for _, userID := range userIDs {
+			pipe.SAdd(ctx, routeUserKey(userID), r.routing.InstanceID)
+		}
+		return nil
+	})
+	return err
+}
+
+// publish sends an event to the instances holding its recipients. In
+// broadcast mode, or when the registry cannot be read, it falls back to the
+// shared channel every instance listens on.
+func (r *RedisClient) publish(ctx context.Context, channel string, payload BroadcastMessage) error {
+	if r.targeted() {
+		routes, err := r.lookupRoutes(ctx, payload.RecipientIDs)
+		if err == nil {
+			return r.publishRoutes(ctx, payload, routes)
+		}
+		log.Printf("Route lookup failed, broadcasting on %s: %v", channel, err)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return r.client.Publish(ctx, channel, data).Err()
+}
+
+func (r *RedisClient) publishRoutes(ctx context.Context, payload BroadcastMessage, routes map[string][]int64) error {
+	if len(routes) == 0 {
+		return nil
+	}
+
+	messages := make(map[string][]byte, len(routes))
+	for instanceID, recipients := range routes {
+		payload.RecipientIDs = recipients
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		messages[instanceID] = data
+	}
+
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for instanceID, data := range messages {
+			pipe.Publish(ctx, instanceChannel(instanceID), data)
+		}
+		return nil
+	})
+	return err
+}
+
+// lookupRoutes groups recipients by the live instances they are connected
+// to. Recipients connected nowhere are left out, and routes to instances
+// that stopped refreshing are pruned.
+func (r *RedisClient) lookupRoutes(ctx context.Context, recipients []int64) (map[string][]int64, error) {
+	if len(recipients) == 0 {
+		return map[string][]int64{}, nil
+	}
+
+	routeCmds := make([]*redis.StringSliceCmd, len(recipients))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, userID := range recipients {
+			routeCmds[i] = pipe.SMembers(ctx, routeUserKey(userID))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([][]string, len(recipients))
+	aliveCmds := make(map[string]*redis.IntCmd)
+	for i, cmd := range routeCmds {
+		instances[i] = cmd.Val()
+		for _, instanceID := range instances[i] {
+			aliveCmds[instanceID] = nil
+		}
+	}
+	if len(aliveCmds) == 0 {
+		return map[string][]int64{}, nil
+	}
+
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for instanceID := range aliveCmds {
+			aliveCmds[instanceID] = pipe.Exists(ctx, routeInstanceKey(instanceID))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	alive := make(map[string]bool, len(aliveCmds))
+	for instanceID, cmd := range aliveCmds {
+		alive[instanceID] = cmd.Val() > 0
+	}
+
+	routes, stale := groupByInstance(recipients, instances, alive)
+	if len(stale) > 0 {
+		r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for userID, instanceIDs := range stale {
+				for _, instanceID := range instanceIDs {
+					pipe.SRem(ctx, routeUserKey(userID), instanceID)
+				}
+			}
+			return nil
+		})
+	}
+
+	return routes, nil
+}
+
+// groupByInstance maps each live instance to the recipients it holds, given
+// the instances every recipient is registered on. Registrations on dead
+// instances are returned separately so they can be cleaned up.
+func groupByInstance(recipients []int64, instances [][]string, alive map[string]bool) (map[string][]int64, map[int64][]string) {
+	routes := make(map[string][]int64)
+	stale := make(map[int64][]string)
+	seen := make(map[int64]bool, len(recipients))
+
+	for i, userID := range recipients {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+
+		for _, instanceID := range instances[i] {
+			if !alive[instanceID] {
+				stale[userID] = append(stale[userID], instanceID)
+				continue
+			}
+			routes[instanceID] = append(routes[instanceID], userID)
+		}
+	}
+	return routes, stale
+}
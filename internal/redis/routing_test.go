@@ -0,0 +1,27 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupByInstance(t *testing.T) {
+	recipients := []int64{1, 2, 3, 4, 1}
+	instances := [][]string{
+		{"a"},
+		{"a", "b"},
+		{"dead"},
+		nil,
+		{"a"},
+	}
+	alive := map[string]bool{"a": true, "b": true}
+
+	routes, stale := groupByInstance(recipients, instances, alive)
+
+	assert.Equal(t, map[string][]int64{
+		"a": {1, 2},
+		"b": {2},
+	}, routes)
+	assert.Equal(t, map[int64][]string{3: {"dead"}}, stale)
+}
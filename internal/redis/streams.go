@@ -0,0 +1,503 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+)
+
+// IEventLog gives access to the durable events of a user, for replaying the
+// ones a client missed while it was disconnected.
+type IEventLog interface {
+	// EventsSince returns the user's events after afterID in order. complete
+	// is false when some of them are no longer retained.
+	EventsSince(ctx context.Context, userID, afterID int64) (events []BroadcastMessage, complete bool, err error)
+}
+
+const (
+	streamLive         = "chat.stream"
+	streamInstanceLive = "chat.stream."
+)
+
+type StreamConfig struct {
+	// UserEvents is roughly how many events are kept per user for catch-up.
+	UserEvents int64
+	// Retention is how long a user's events are kept after the last one.
+	Retention time.Duration
+	// LiveEvents caps the live streams instances consume from.
+	LiveEvents int64
+	// BlockTimeout bounds a single blocking read of the live streams.
+	BlockTimeout time.Duration
+	// DeadAfter is how long an instance can stop reading the live streams
+	// before its consumer group and stream are removed.
+	DeadAfter time.Duration
+}
+
+func DefaultStreamConfig() StreamConfig {
+	return StreamConfig{
+		UserEvents:   1000,
+		Retention:    24 * time.Hour,
+		LiveEvents:   10000,
+		BlockTimeout: 5 * time.Second,
+		DeadAfter:    10 * time.Minute,
+	}
+}
+
+// StreamClient is an IRedisClient that delivers events through Redis Streams.
+// Every recipient gets the event appended to their own stream under the next
+// number of their sequence, which is what clients catch up from. Live
+// delivery goes through streams read by one consumer group per instance, so
+// an instance that restarts under the same ID within DeadAfter picks up what
+// it missed. Typing and status events are ephemeral and still use pub/sub.
+type StreamClient struct {
+	*RedisClient
+	config StreamConfig
+}
+
+func NewStreamClient(redisClient *RedisClient, cfg StreamConfig) *StreamClient {
+	return &StreamClient{
+		RedisClient: redisClient,
+		config:      cfg,
+	}
+}
+
+// appendEventScript assigns the next sequence number of a user and appends
+// the event under it, atomically so the stream IDs stay in order.
+var appendEventScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[1])
+redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[2], seq .. '-0', 'event', ARGV[1])
+redis.call('EXPIRE', KEYS[2], ARGV[3])
+return seq
+`)
+
+// The braces keep a user's keys in one cluster slot, as the script needs.
+func userSeqKey(userID int64) string {
+	return fmt.Sprintf("events:{%d}:seq", userID)
+}
+
+func userStreamKey(userID int64) string {
+	return fmt.Sprintf("events:{%d}", userID)
+}
+
+func instanceStream(instanceID string) string {
+	return streamInstanceLive + instanceID
+}
+
+func (s *StreamClient) Publish(ctx context.Context, msg model.Message, recipients []int64) error {
+	return s.append(ctx, BroadcastMessage{Type: "message", Message: &msg, RecipientIDs: recipients})
+}
+
+func (s *StreamClient) PublishReaction(ctx context.Context, reaction model.Reaction, recipients []int64) error {
+	return s.append(ctx, BroadcastMessage{Type: "reaction_add", RecipientIDs: recipients, Payload: reaction})
+}
+
+func (s *StreamClient) PublishReactionRemoval(ctx context.Context, reaction model.Reaction, recipients []int64) error {
+	return s.append(ctx, BroadcastMessage{Type: "reaction_remove", RecipientIDs: recipients, Payload: reaction})
+}
+
+func (s *StreamClient) PublishReadReceipt(ctx context.Context, readReceipt model.MessageRead, recipients []int64) error {
+	return s.append(ctx, BroadcastMessage{Type: "read_receipt", RecipientIDs: recipients, Payload: readReceipt})
+}
+
+func (s *StreamClient) PublishDeliveryReceipt(ctx context.Context, delivery model.MessageDelivery, recipients []int64) error {
+	return s.append(ctx, BroadcastMessage{Type: "delivered", RecipientIDs: recipients, Payload: delivery})
+}
+
+func (s *StreamClient) PublishMessageEdit(ctx context.Context, msg model.Message, recipients []int64) error {
+	return s.append(ctx, BroadcastMessage{Type: "message_edit", Message: &msg, RecipientIDs: recipients})
+}
+
+func (s *StreamClient) PublishThreadReply(ctx context.Context, msg model.Message, recipients []int64) error {
+	return s.append(ctx, BroadcastMessage{Type: "thread_reply", Message: &msg, RecipientIDs: recipients})
+}
+
+func (s *StreamClient) PublishMention(ctx context.Context, msg model.Message, recipients []int64) error {
+	return s.append(ctx, BroadcastMessage{Type: "mention", Message: &msg, RecipientIDs: recipients})
+}
+
+func (s *StreamClient) PublishMessagePinned(ctx context.Context, pin model.PinnedMessage, recipients []int64) error {
+	return s.append(ctx, BroadcastMessage{Type: "message_pinned", RecipientIDs: recipients, Payload: pin})
+}
+
+func (s *StreamClient) PublishMessageUnpinned(ctx context.Context, unpin model.UnpinnedMessage, recipients []int64) error {
+	return s.append(ctx, BroadcastMessage{Type: "message_unpinned", RecipientIDs: recipients, Payload: unpin})
+}
+
+func (s *StreamClient) PublishMessageDeletion(ctx context.Context, messageID int64, recipients []int64) error {
+	return s.append(ctx, BroadcastMessage{Type: "message_delete", RecipientIDs: recipients, Payload: map[string]int64{"message_id": messageID}})
+}
+
+func (s *StreamClient) PublishParticipantAdded(ctx context.Context, event model.ParticipantEvent, recipients []int64) error {
+	return s.append(ctx, BroadcastMessage{Type: "participant_added", RecipientIDs: recipients, Payload: event})
+}
+
+func (s *StreamClient) PublishParticipantRemoved(ctx context.Context, event model.ParticipantEvent, recipients []int64) error {
+	return s.append(ctx, BroadcastMessage{Type: "participant_removed", RecipientIDs: recipients, Payload: event})
+}
+
+func (s *StreamClient) PublishParticipantRoleChanged(ctx context.Context, event model.ParticipantEvent, recipients []int64) error {
+	return s.append(ctx, BroadcastMessage{Type: "participant_role_changed", RecipientIDs: recipients, Payload: event})
+}
+
+func (s *StreamClient) PublishFileInfected(ctx context.Context, event model.FileInfectedEvent, recipients []int64) error {
+	return s.append(ctx, BroadcastMessage{Type: "file_infected", RecipientIDs: recipients, Payload: event})
+}
+
+// append records the event in every recipient's stream, then hands it to the
+// live streams of the instances holding the recipients.
+func (s *StreamClient) append(ctx context.Context, payload BroadcastMessage) error {
+	if len(payload.RecipientIDs) == 0 {
+		return nil
+	}
+
+	eventIDs, err := s.appendUserEvents(ctx, payload)
+	if err != nil {
+		return err
+	}
+	payload.EventIDs = eventIDs
+
+	if s.targeted() {
+		routes, err := s.lookupRoutes(ctx, payload.RecipientIDs)
+		if err == nil {
+			return s.addLiveRoutes(ctx, payload, routes)
+		}
+		log.Printf("Route lookup failed, appending to %s: %v", streamLive, err)
+	}
+
+	return s.addLive(ctx, s.client, streamLive, payload)
+}
+
+func (s *StreamClient) appendUserEvents(ctx context.Context, payload BroadcastMessage) (map[int64]int64, error) {
+	event := payload
+	event.RecipientIDs = nil
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	retention := int64(s.config.Retention / time.Second)
+	cmds := make(map[int64]*redis.Cmd, len(payload.RecipientIDs))
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, userID := range payload.RecipientIDs {
+			if _, ok := cmds[userID]; ok {
+				continue
+			}
+			keys := []string{userSeqKey(userID), userStreamKey(userID)}
+			cmds[userID] = appendEventScript.Eval(ctx, pipe, keys, data, s.config.UserEvents, retention)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	eventIDs := make(map[int64]int64, len(cmds))
+	for userID, cmd := range cmds {
+		seq, err := cmd.Int64()
+		if err != nil {
+			return nil, err
+		}
+		eventIDs[userID] = seq
+	}
+	return eventIDs, nil
+}
+
+func (s *StreamClient) addLiveRoutes(ctx context.Context, payload BroadcastMessage, routes map[string][]int64) error {
+	if len(routes) == 0 {
+		return nil
+	}
+
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for instanceID, recipients := range routes {
+			payload.RecipientIDs = recipients
+			if err := s.addLive(ctx, pipe, instanceStream(instanceID), payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *StreamClient) addLive(ctx context.Context, c redis.Cmdable, stream string, payload BroadcastMessage) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return c.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: s.config.LiveEvents,
+		Approx: true,
+		Values: map[string]interface{}{"event": data},
+	}).Err()
+}
+
+func (s *StreamClient) EventsSince(ctx context.Context, userID, afterID int64) ([]BroadcastMessage, bool, error) {
+	var seqCmd *redis.StringCmd
+	var rangeCmd *redis.XMessageSliceCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		seqCmd = pipe.Get(ctx, userSeqKey(userID))
+		rangeCmd = pipe.XRange(ctx, userStreamKey(userID), fmt.Sprintf("(%d-0", afterID), "+")
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, false, err
+	}
+
+	latest, err := seqCmd.Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, false, err
+	}
+
+	events := make([]BroadcastMessage, 0, len(rangeCmd.Val()))
+	seqs := make([]int64, 0, len(rangeCmd.Val()))
+	for _, entry := range rangeCmd.Val() {
+		seq, event, err := decodeUserEvent(entry)
+		if err != nil {
+			log.Printf("Skipping malformed event %s of user %d: %v", entry.ID, userID, err)
+			continue
+		}
+		event.RecipientIDs = []int64{userID}
+		event.EventIDs = map[int64]int64{userID: seq}
+		events = append(events, event)
+		seqs = append(seqs, seq)
+	}
+
+	return events, isCompleteReplay(afterID, latest, seqs), nil
+}
+
+// isCompleteReplay reports whether the replayed sequence numbers cover every
+// event after afterID up to latest. A gap at the start means older events
+// were trimmed; afterID beyond latest means the sequence was reset.
+func isCompleteReplay(afterID, latest int64, seqs []int64) bool {
+	if afterID > latest {
+		return false
+	}
+	if afterID == latest {
+		return true
+	}
+	return len(seqs) > 0 && seqs[0] == afterID+1 && seqs[len(seqs)-1] == latest
+}
+
+func decodeUserEvent(entry redis.XMessage) (int64, BroadcastMessage, error) {
+	var event BroadcastMessage
+
+	seq, err := strconv.ParseInt(strings.SplitN(entry.ID, "-", 2)[0], 10, 64)
+	if err != nil {
+		return 0, event, err
+	}
+
+	data, ok := entry.Values["event"].(string)
+	if !ok {
+		return 0, event, errors.New("event field missing")
+	}
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return 0, event, err
+	}
+	return seq, event, nil
+}
+
+// Subscribe merges the live streams this instance consumes with the pub/sub
+// channels still used for ephemeral events.
+func (s *StreamClient) Subscribe(ctx context.Context) <-chan BroadcastMessage {
+	ch := make(chan BroadcastMessage)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for msg := range s.RedisClient.Subscribe(ctx) {
+			ch <- msg
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		s.consume(ctx, ch)
+	}()
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+
+	return ch
+}
+
+// consume reads the live streams as this instance's consumer group. Entries
+// left unacknowledged by a previous run under the same instance ID are
+// delivered first.
+func (s *StreamClient) consume(ctx context.Context, out chan<- BroadcastMessage) {
+	group := s.routing.InstanceID
+	streams := []string{streamLive}
+	if s.targeted() {
+		streams = append(streams, instanceStream(group))
+	}
+	s.createGroups(ctx, group, streams)
+
+	start := "0"
+	for ctx.Err() == nil {
+		args := append([]string{}, streams...)
+		for range streams {
+			args = append(args, start)
+		}
+
+		result, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    group,
+			Consumer: group,
+			Streams:  args,
+			Count:    100,
+			Block:    s.config.BlockTimeout,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Error reading event streams: %v", err)
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				s.createGroups(ctx, group, streams)
+			}
+			time.Sleep(time.Second)
+			continue
+		}
+
+		delivered := 0
+		for _, stream := range result {
+			for _, entry := range stream.Messages {
+				delivered++
+				data, _ := entry.Values["event"].(string)
+				var msg BroadcastMessage
+				if err := json.Unmarshal([]byte(data), &msg); err != nil {
+					log.Println("Error unmarshaling stream event:", err)
+				} else {
+					select {
+					case out <- msg:
+					case <-ctx.Done():
+						return
+					}
+				}
+				s.client.XAck(ctx, stream.Stream, group, entry.ID)
+			}
+		}
+
+		// Pending entries come first; once none are left only new entries
+		// are read.
+		if start == "0" && delivered == 0 {
+			start = ">"
+		}
+	}
+}
+
+// createGroups creates this instance's consumer groups. On the shared stream
+// a new group starts at the end, on the instance's own stream it starts at
+// the beginning so events routed here before it came up are not lost.
+func (s *StreamClient) createGroups(ctx context.Context, group string, streams []string) {
+	for _, stream := range streams {
+		start := "0"
+		if stream == streamLive {
+			start = "$"
+		}
+		err := s.client.XGroupCreateMkStream(ctx, stream, group, start).Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			log.Printf("Failed to create consumer group on %s: %v", stream, err)
+		}
+	}
+}
+
+// PruneDeadInstances removes the consumer groups and streams of instances
+// that stopped reading the live streams more than DeadAfter ago and, with
+// targeted routing, are gone from the registry. Without it every restart
+// under a generated instance ID would leave them behind. It returns how many
+// instances were pruned.
+func (s *StreamClient) PruneDeadInstances(ctx context.Context) (int, error) {
+	groups, err := s.client.XInfoGroups(ctx, streamLive).Result()
+	if err != nil {
+		if strings.Contains(err.Error(), "no such key") {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	idle := make(map[string]time.Duration, len(groups))
+	for _, group := range groups {
+		if group.Name == s.routing.InstanceID || group.Consumers == 0 {
+			continue
+		}
+		consumers, err := s.client.XInfoConsumers(ctx, streamLive, group.Name).Result()
+		if err != nil {
+			return 0, err
+		}
+		idle[group.Name] = leastIdle(consumers)
+	}
+
+	alive := make(map[string]bool, len(idle))
+	if s.targeted() && len(idle) > 0 {
+		cmds := make(map[string]*redis.IntCmd, len(idle))
+		_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for instanceID := range idle {
+				cmds[instanceID] = pipe.Exists(ctx, routeInstanceKey(instanceID))
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		for instanceID, cmd := range cmds {
+			alive[instanceID] = cmd.Val() > 0
+		}
+	}
+
+	dead := deadInstances(idle, alive, s.config.DeadAfter)
+	if len(dead) == 0 {
+		return 0, nil
+	}
+
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, instanceID := range dead {
+			pipe.XGroupDestroy(ctx, streamLive, instanceID)
+			pipe.Del(ctx, instanceStream(instanceID))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(dead), nil
+}
+
+// leastIdle returns how long ago the most recent of a group's consumers
+// tried to read.
+func leastIdle(consumers []redis.XInfoConsumer) time.Duration {
+	least := time.Duration(-1)
+	for _, consumer := range consumers {
+		if least < 0 || consumer.Idle < least {
+			least = consumer.Idle
+		}
+	}
+	return least
+}
+
+// deadInstances picks the instances that have not read for longer than
+// maxIdle and are not registered as alive.
+func deadInstances(idle map[string]time.Duration, alive map[string]bool, maxIdle time.Duration) []string {
+	var dead []string
+	for instanceID, d := range idle {
+		if d > maxIdle && !alive[instanceID] {
+			dead = append(dead, instanceID)
+		}
+	}
+	sort.Strings(dead)
+	return dead
+}
@@ -0,0 +1,394 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhanserikAmangeldi/chat-service/internal/core/model"
+)
+
+func TestIsCompleteReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		afterID int64
+		latest  int64
+		seqs    []int64
+		want    bool
+	}{
+		{name: "nothing missed", afterID: 5, latest: 5, want: true},
+		{name: "all retained", afterID: 5, latest: 8, seqs: []int64{6, 7, 8}, want: true},
+		{name: "oldest trimmed", afterID: 5, latest: 8, seqs: []int64{7, 8}, want: false},
+		{name: "stream expired", afterID: 5, latest: 8, want: false},
+		{name: "sequence reset", afterID: 9, latest: 2, seqs: []int64{1, 2}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isCompleteReplay(tt.afterID, tt.latest, tt.seqs))
+		})
+	}
+}
+
+func TestDeadInstances(t *testing.T) {
+	idle := map[string]time.Duration{
+		"reading":    time.Second,
+		"crashed":    time.Hour,
+		"registered": time.Hour,
+	}
+	alive := map[string]bool{"registered": true}
+
+	assert.Equal(t, []string{"crashed"}, deadInstances(idle, alive, 10*time.Minute))
+}
+
+// fakeRedis answers the subset of Redis commands StreamClient uses to append
+// and replay user events. Scripts cannot be run, so EVAL of appendEventScript
+// is carried out natively.
+type fakeRedis struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	strings map[string]string
+	streams map[string][]fakeEntry
+	lastID  int64
+}
+
+type fakeEntry struct {
+	ms, seq int64
+	fields  []interface{}
+}
+
+type fakeStatus string
+
+type fakeError string
+
+func startFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	f := &fakeRedis{
+		listener: listener,
+		strings:  make(map[string]string),
+		streams:  make(map[string][]fakeEntry),
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	var queued [][]string
+	inMulti := false
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		switch name := strings.ToUpper(args[0]); {
+		case name == "MULTI":
+			inMulti = true
+			writeReply(w, fakeStatus("OK"))
+		case name == "EXEC":
+			replies := make([]interface{}, len(queued))
+			for i, cmd := range queued {
+				replies[i] = f.do(cmd)
+			}
+			queued, inMulti = nil, false
+			writeReply(w, replies)
+		case inMulti:
+			queued = append(queued, args)
+			writeReply(w, fakeStatus("QUEUED"))
+		default:
+			writeReply(w, f.do(args))
+		}
+
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (f *fakeRedis) do(args []string) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return fakeStatus("PONG")
+	case "GET":
+		if value, ok := f.strings[args[1]]; ok {
+			return value
+		}
+		return nil
+	case "INCR":
+		return f.incr(args[1])
+	case "EXPIRE":
+		return int64(1)
+	case "XADD":
+		return f.xadd(args[1:])
+	case "XRANGE":
+		return f.xrange(args[1], args[2], args[3])
+	case "EVAL":
+		sum := sha1.Sum([]byte(args[1]))
+		if hex.EncodeToString(sum[:]) != appendEventScript.Hash() {
+			return fakeError("ERR unknown script")
+		}
+		keys, argv := args[3:5], args[5:]
+		seq := f.incr(keys[0])
+		id := fmt.Sprintf("%d-0", seq)
+		if reply := f.xadd([]string{keys[1], "MAXLEN", "~", argv[1], id, "event", argv[0]}); reply != id {
+			return reply
+		}
+		return seq
+	default:
+		return fakeError("ERR unknown command '" + args[0] + "'")
+	}
+}
+
+func (f *fakeRedis) incr(key string) int64 {
+	n, _ := strconv.ParseInt(f.strings[key], 10, 64)
+	n++
+	f.strings[key] = strconv.FormatInt(n, 10)
+	return n
+}
+
+// xadd takes: key [MAXLEN [~|=] n] id field value...
+func (f *fakeRedis) xadd(args []string) interface{} {
+	key, args := args[0], args[1:]
+	maxLen := -1
+	if strings.ToUpper(args[0]) == "MAXLEN" {
+		args = args[1:]
+		if args[0] == "~" || args[0] == "=" {
+			args = args[1:]
+		}
+		maxLen, _ = strconv.Atoi(args[0])
+		args = args[1:]
+	}
+
+	entry := fakeEntry{}
+	if args[0] == "*" {
+		f.lastID++
+		entry.ms = time.Now().UnixMilli()
+		entry.seq = f.lastID
+	} else {
+		entry.ms, entry.seq = parseStreamID(args[0])
+	}
+	stream := f.streams[key]
+	if n := len(stream); n > 0 && !streamIDLess(stream[n-1], entry.ms, entry.seq) {
+		return fakeError("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	}
+	for _, field := range args[1:] {
+		entry.fields = append(entry.fields, field)
+	}
+
+	stream = append(stream, entry)
+	if maxLen >= 0 && len(stream) > maxLen {
+		stream = stream[len(stream)-maxLen:]
+	}
+	f.streams[key] = stream
+	return fmt.Sprintf("%d-%d", entry.ms, entry.seq)
+}
+
+func (f *fakeRedis) xrange(key, start, end string) interface{} {
+	exclusive := strings.HasPrefix(start, "(")
+	startMS, startSeq := parseStreamID(strings.TrimPrefix(start, "("))
+	if start == "-" {
+		startMS, startSeq = 0, 0
+	}
+
+	entries := []interface{}{}
+	for _, entry := range f.streams[key] {
+		if streamIDLess(entry, startMS, startSeq) {
+			continue
+		}
+		if exclusive && entry.ms == startMS && entry.seq == startSeq {
+			continue
+		}
+		if end != "+" {
+			endMS, endSeq := parseStreamID(end)
+			if !streamIDLess(entry, endMS, endSeq) && (entry.ms != endMS || entry.seq != endSeq) {
+				continue
+			}
+		}
+		entries = append(entries, []interface{}{fmt.Sprintf("%d-%d", entry.ms, entry.seq), entry.fields})
+	}
+	return entries
+}
+
+func parseStreamID(id string) (int64, int64) {
+	msPart, seqPart, _ := strings.Cut(id, "-")
+	ms, _ := strconv.ParseInt(msPart, 10, 64)
+	seq, _ := strconv.ParseInt(seqPart, 10, 64)
+	return ms, seq
+}
+
+func streamIDLess(entry fakeEntry, ms, seq int64) bool {
+	return entry.ms < ms || (entry.ms == ms && entry.seq < seq)
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case fakeStatus:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case fakeError:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	}
+}
+
+func newTestStreamClient(t *testing.T, cfg StreamConfig) *StreamClient {
+	f := startFakeRedis(t)
+	redisClient := NewRedisClient(f.addr(), RoutingConfig{Mode: RoutingBroadcast, InstanceID: "test"})
+	t.Cleanup(func() { redisClient.client.Close() })
+	return NewStreamClient(redisClient, cfg)
+}
+
+func publishContents(t *testing.T, s *StreamClient, recipients []int64, contents ...string) {
+	for _, content := range contents {
+		msg := model.Message{Content: content}
+		require.NoError(t, s.Publish(context.Background(), msg, recipients))
+	}
+}
+
+func eventContents(events []BroadcastMessage) []string {
+	contents := make([]string, len(events))
+	for i, event := range events {
+		contents[i] = event.Message.Content
+	}
+	return contents
+}
+
+func TestStreamClient_EventsSinceReplaysInOrder(t *testing.T) {
+	s := newTestStreamClient(t, DefaultStreamConfig())
+	ctx := context.Background()
+
+	publishContents(t, s, []int64{1, 2}, "a", "b")
+	publishContents(t, s, []int64{1}, "c", "d")
+
+	events, complete, err := s.EventsSince(ctx, 1, 1)
+
+	require.NoError(t, err)
+	assert.True(t, complete)
+	assert.Equal(t, []string{"b", "c", "d"}, eventContents(events))
+	for i, event := range events {
+		assert.Equal(t, []int64{1}, event.RecipientIDs)
+		assert.Equal(t, map[int64]int64{1: int64(i + 2)}, event.EventIDs)
+	}
+
+	// Every user has a sequence of their own.
+	events, complete, err = s.EventsSince(ctx, 2, 0)
+
+	require.NoError(t, err)
+	assert.True(t, complete)
+	assert.Equal(t, []string{"a", "b"}, eventContents(events))
+	assert.Equal(t, map[int64]int64{2: 2}, events[1].EventIDs)
+}
+
+func TestStreamClient_EventsSinceNothingMissed(t *testing.T) {
+	s := newTestStreamClient(t, DefaultStreamConfig())
+
+	publishContents(t, s, []int64{1}, "a", "b")
+
+	events, complete, err := s.EventsSince(context.Background(), 1, 2)
+
+	require.NoError(t, err)
+	assert.True(t, complete)
+	assert.Empty(t, events)
+}
+
+func TestStreamClient_EventsSinceTrimmed(t *testing.T) {
+	cfg := DefaultStreamConfig()
+	cfg.UserEvents = 2
+	s := newTestStreamClient(t, cfg)
+
+	publishContents(t, s, []int64{1}, "a", "b", "c", "d")
+
+	events, complete, err := s.EventsSince(context.Background(), 1, 0)
+
+	require.NoError(t, err)
+	assert.False(t, complete)
+	assert.Equal(t, []string{"c", "d"}, eventContents(events))
+}
+
+func TestStreamClient_EventsSinceUnknownUser(t *testing.T) {
+	s := newTestStreamClient(t, DefaultStreamConfig())
+
+	events, complete, err := s.EventsSince(context.Background(), 1, 0)
+
+	require.NoError(t, err)
+	assert.True(t, complete)
+	assert.Empty(t, events)
+}